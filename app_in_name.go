@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+const appIDLabelName = "app_id"
+
+// appInNameGatherer returns a gatherer moving the app_id label of the
+// metrics gathered by g into their name, for --metrics.app-in-name:
+// spark_executor_active_tasks{app_id="app-1"} becomes
+// spark_app_app_1_executor_active_tasks. Metrics without an app_id label are
+// left as is. Applications whose ids are sanitized into the same name, like
+// app-1 and app.1, would mix their metrics: only those of the first one are
+// kept. namespace is the prefix of the metric names.
+func appInNameGatherer(g prometheus.Gatherer, namespace string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		if err != nil {
+			return nil, err
+		}
+		byName := map[string]*dto.MetricFamily{}
+		// appIDs holds the application id of each sanitized id.
+		appIDs := map[string]string{}
+		collisions := map[string]bool{}
+		var result []*dto.MetricFamily
+		for _, mf := range mfs {
+			var kept []*dto.Metric
+			for _, m := range mf.Metric {
+				appID, labels := splitAppID(m.Label)
+				if appID == "" {
+					kept = append(kept, m)
+					continue
+				}
+				sanitized := sanitizeMetricName(appID)
+				if owner, ok := appIDs[sanitized]; !ok {
+					appIDs[sanitized] = appID
+				} else if owner != appID {
+					collisions[appID] = true
+					continue
+				}
+				name := appMetricName(namespace, mf.GetName(), appID)
+				family, ok := byName[name]
+				if !ok {
+					family = &dto.MetricFamily{Name: proto.String(name), Help: mf.Help, Type: mf.Type}
+					byName[name] = family
+					result = append(result, family)
+				}
+				m.Label = labels
+				family.Metric = append(family.Metric, m)
+			}
+			if len(kept) > 0 {
+				mf.Metric = kept
+				result = append(result, mf)
+			}
+		}
+		for appID := range collisions {
+			log.Errorf("Skipping the metrics of application %s: its id is the same as %s in metric names", appID, appIDs[sanitizeMetricName(appID)])
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].GetName() < result[j].GetName() })
+		return result, nil
+	})
+}
+
+// splitAppID returns the value of the app_id label among labels and the
+// other labels.
+func splitAppID(labels []*dto.LabelPair) (string, []*dto.LabelPair) {
+	for i, l := range labels {
+		if l.GetName() == appIDLabelName {
+			return l.GetValue(), append(append([]*dto.LabelPair{}, labels[:i]...), labels[i+1:]...)
+		}
+	}
+	return "", labels
+}
+
+// appMetricName returns the name of metric name for application appID,
+// inserting the sanitized id after namespace.
+func appMetricName(namespace, name, appID string) string {
+	return namespace + "_app_" + sanitizeMetricName(appID) + "_" + strings.TrimPrefix(name, namespace+"_")
+}
+
+// sanitizeMetricName replaces the characters not allowed in metric names by
+// underscores.
+func sanitizeMetricName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
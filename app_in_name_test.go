@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestSanitizeMetricName(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"app-20230102150405-0001", "app_20230102150405_0001"},
+		{"application_1672671845_0001", "application_1672671845_0001"},
+		{"local.1672671845", "local_1672671845"},
+		{"app-1.attempt:2", "app_1_attempt_2"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeMetricName(tt.id); got != tt.want {
+			t.Errorf("sanitizeMetricName(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+// gatherAppInName scrapes e and returns the metrics moved to per application
+// names by --metrics.app-in-name.
+func gatherAppInName(t *testing.T, e *Exporter) map[string]*dto.MetricFamily {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	mfs, err := e.gatherer(registry).Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	families := map[string]*dto.MetricFamily{}
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf
+	}
+	return families
+}
+
+func TestAppInName(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications":                     `[{"id": "app-1", "name": "etl", "attempts": []}, {"id": "local.2", "name": "shell", "attempts": []}]`,
+		"applications/app-1/executors":     `[{"id": "driver", "isActive": true, "activeTasks": 3}]`,
+		"applications/local.2/executors":   `[{"id": "driver", "isActive": true, "activeTasks": 5}]`,
+		"applications/local.2/jobs":        `[]`,
+		"applications/local.2/stages":      `[]`,
+		"applications/local.2/storage/rdd": `[]`,
+		"applications/local.2/environment": `{}`,
+	}))
+	families := gatherAppInName(t, newTestExporter(t, []string{s.URL}, Options{AppInName: true}))
+	checkSeries(t, families, []series{
+		{"spark_app_app_1_executor_active_tasks", map[string]string{"endpoint": s.URL, "executor_id": "driver"}, 3},
+		{"spark_app_local_2_executor_active_tasks", map[string]string{"endpoint": s.URL, "executor_id": "driver"}, 5},
+		// Metrics without an application keep their name.
+		{"spark_up", map[string]string{"endpoint": s.URL}, 1},
+	})
+	if mf := families["spark_executor_active_tasks"]; mf != nil {
+		t.Errorf("got spark_executor_active_tasks %v, want the metrics of each application under their own name", mf.Metric)
+	}
+	for _, m := range families["spark_app_app_1_executor_active_tasks"].GetMetric() {
+		if hasLabel(m, "app_id", "app-1") {
+			t.Error("got an app_id label in a metric named after the application")
+		}
+	}
+}
+
+func TestAppInNameCollision(t *testing.T) {
+	// app-1 and app.1 are both sanitized into app_1.
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications":                   `[{"id": "app-1", "name": "etl", "attempts": []}, {"id": "app.1", "name": "shell", "attempts": []}]`,
+		"applications/app-1/executors":   `[{"id": "driver", "isActive": true, "activeTasks": 3}]`,
+		"applications/app.1/executors":   `[{"id": "driver", "isActive": true, "activeTasks": 5}]`,
+		"applications/app.1/jobs":        `[]`,
+		"applications/app.1/stages":      `[]`,
+		"applications/app.1/storage/rdd": `[]`,
+		"applications/app.1/environment": `{}`,
+	}))
+	families := gatherAppInName(t, newTestExporter(t, []string{s.URL}, Options{AppInName: true}))
+	// Only the metrics of the first application are kept, instead of
+	// mixing both under the same name.
+	got := families["spark_app_app_1_executor_active_tasks"].GetMetric()
+	if len(got) != 1 || got[0].GetGauge().GetValue() != 3 {
+		t.Errorf("got spark_app_app_1_executor_active_tasks %v, want the 3 active tasks of app-1 only", got)
+	}
+	if n := len(families["spark_app_app_1_application_info"].GetMetric()); n > 1 {
+		t.Errorf("got %d spark_app_app_1_application_info series, want those of app-1 only", n)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// yarnATSAppsPath lists the finished Spark applications of a YARN
+// Application History Server or Timeline Server.
+const yarnATSAppsPath = "ws/v1/applicationhistory/apps?applicationTypes=SPARK&states=FINISHED,FAILED,KILLED"
+
+const finalStatusLabelName = "final_status"
+
+var yarnATSStatusLabelNames = append(append([]string{}, applicationLabelNames...), finalStatusLabelName)
+
+// YarnATSApps holds the applications listed by a YARN Timeline Server.
+type YarnATSApps struct {
+	App []YarnATSApp `json:"app"`
+}
+
+// YarnATSApp holds a single finished application listed by a YARN Timeline
+// Server. Times are in milliseconds and memory in MiB. Only some Hadoop
+// versions report the aggregated resource usage.
+type YarnATSApp struct {
+	AppID          string `json:"appId"`
+	Name           string `json:"name"`
+	FinalAppStatus string `json:"finalAppStatus"`
+	FinishedTime   int64  `json:"finishedTime"`
+	ElapsedTime    int64  `json:"elapsedTime"`
+	MemorySeconds  *int64 `json:"memorySeconds"`
+	VcoreSeconds   *int64 `json:"vcoreSeconds"`
+}
+
+func newYarnATSMetrics(namespace string, metricName string, docString string, labelNames []string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "yarn",
+			Name:        "application_" + metricName,
+			Help:        docString,
+			ConstLabels: constLabels,
+		},
+		labelNames,
+	)
+}
+
+func newYarnATSGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"duration_seconds":    newYarnATSMetrics(namespace, "duration_seconds", "Duration of the finished application in seconds", applicationLabelNames, constLabels),
+		"finish_time_seconds": newYarnATSMetrics(namespace, "finish_time_seconds", "Time the application finished since unix epoch in seconds", applicationLabelNames, constLabels),
+		"final_status":        newYarnATSMetrics(namespace, "final_status", "Final status of the application reported by YARN, with a constant value of 1", yarnATSStatusLabelNames, constLabels),
+		"memory_byte_seconds": newYarnATSMetrics(namespace, "memory_byte_seconds", "Memory allocated to the application integrated over its duration in byte-seconds", applicationLabelNames, constLabels),
+		"vcore_seconds":       newYarnATSMetrics(namespace, "vcore_seconds", "Virtual cores allocated to the application integrated over its duration in vcore-seconds", applicationLabelNames, constLabels),
+	}
+}
+
+func (e *Exporter) scrapeYarnATS(ctx context.Context, t *target) error {
+	body, err := t.fetch(ctx, yarnATSAppsPath)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	apps, err := parseYarnATSApps(body)
+	if err != nil {
+		return err
+	}
+	for _, app := range apps.App {
+		if e.applicationFilter.match(app.Name) {
+			e.setYarnATSMetrics(t.endpoint, app)
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) setYarnATSMetrics(endpoint string, app YarnATSApp) {
+	labels := applicationLabelValues(appKey{endpoint, app.AppID}, ApplicationMetrics{ID: app.AppID, Name: app.Name})
+	e.yarnATSGaugeMetrics["duration_seconds"].WithLabelValues(labels...).Set(float64(app.ElapsedTime) / 1000)
+	if app.FinishedTime > 0 {
+		e.yarnATSGaugeMetrics["finish_time_seconds"].WithLabelValues(labels...).Set(timeSeconds(time.Unix(0, app.FinishedTime*int64(time.Millisecond))))
+	}
+	e.yarnATSGaugeMetrics["final_status"].WithLabelValues(append(labels, app.FinalAppStatus)...).Set(1)
+	if app.MemorySeconds != nil {
+		e.yarnATSGaugeMetrics["memory_byte_seconds"].WithLabelValues(labels...).Set(float64(*app.MemorySeconds) * 1024 * 1024)
+	}
+	if app.VcoreSeconds != nil {
+		e.yarnATSGaugeMetrics["vcore_seconds"].WithLabelValues(labels...).Set(float64(*app.VcoreSeconds))
+	}
+}
+
+func parseYarnATSApps(r io.Reader) (YarnATSApps, error) {
+	var apps YarnATSApps
+	if err := json.NewDecoder(r).Decode(&apps); err != nil {
+		return apps, newDecodeError("YARN timeline applications", err)
+	}
+	return apps, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// yarnATSAppsSample is a response of the applicationhistory/apps endpoint of
+// a YARN Timeline Server, the second application without the aggregated
+// resource usage reported by newer Hadoop versions only.
+const yarnATSAppsSample = `{"app": [
+  {
+    "appId": "application_1609459200000_0001",
+    "name": "etl",
+    "applicationType": "SPARK",
+    "appState": "FINISHED",
+    "finalAppStatus": "SUCCEEDED",
+    "startedTime": 1609459200000,
+    "finishedTime": 1609462800500,
+    "elapsedTime": 3600500,
+    "memorySeconds": 7372800,
+    "vcoreSeconds": 14400
+  },
+  {
+    "appId": "application_1609459200000_0002",
+    "name": "report",
+    "applicationType": "SPARK",
+    "appState": "FAILED",
+    "finalAppStatus": "FAILED",
+    "startedTime": 1609459200000,
+    "finishedTime": 1609459260000,
+    "elapsedTime": 60000
+  }
+]}`
+
+func TestYarnATSMetrics(t *testing.T) {
+	ats := newSparkServer(t, map[string]string{"/ws/v1/applicationhistory/apps": yarnATSAppsSample})
+	families := gather(t, newTestExporter(t, nil, Options{YarnATSURI: ats.URL}))
+	succeeded := map[string]string{"app_id": "application_1609459200000_0001", "app_name": "etl"}
+	failed := map[string]string{"app_id": "application_1609459200000_0002", "app_name": "report"}
+	checkSeries(t, families, []series{
+		{"spark_up", map[string]string{"endpoint": ats.URL}, 1},
+		{"spark_yarn_application_duration_seconds", succeeded, 3600.5},
+		{"spark_yarn_application_finish_time_seconds", succeeded, 1609462800.5},
+		{"spark_yarn_application_final_status", map[string]string{"app_id": "application_1609459200000_0001", "final_status": "SUCCEEDED"}, 1},
+		{"spark_yarn_application_memory_byte_seconds", succeeded, 7372800 * 1024 * 1024},
+		{"spark_yarn_application_vcore_seconds", succeeded, 14400},
+		{"spark_yarn_application_duration_seconds", failed, 60},
+		{"spark_yarn_application_final_status", map[string]string{"app_id": "application_1609459200000_0002", "final_status": "FAILED"}, 1},
+	})
+	// The resource usage isn't reported as 0 when YARN doesn't report it.
+	for _, name := range []string{"spark_yarn_application_memory_byte_seconds", "spark_yarn_application_vcore_seconds"} {
+		if _, ok := metricValue(families, name, failed); ok {
+			t.Errorf("got %s for an application without resource usage", name)
+		}
+	}
+}
+
+func TestParseYarnATSApps(t *testing.T) {
+	if _, err := parseYarnATSApps(strings.NewReader(`{"app": [`)); err == nil {
+		t.Error("parsing a truncated listing succeeded, want an error")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var attemptLabelNames = []string{"endpoint", "app_id", "attempt_id"}
+
+const sparkUserLabelName = "spark_user"
+
+// sparkTimeLayouts are the layouts of the dates returned by the Spark REST
+// API, which uses a GMT suffix instead of a numeric zone, and of RFC 3339
+// dates.
+var sparkTimeLayouts = []string{
+	"2006-01-02T15:04:05.000GMT",
+	"2006-01-02T15:04:05GMT",
+	time.RFC3339Nano,
+}
+
+func newAttemptMetrics(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "application_" + metricName,
+			Help:        docString,
+			ConstLabels: constLabels,
+		},
+		attemptLabelNames,
+	)
+}
+
+func newAttemptGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"start_time_seconds": newAttemptMetrics(namespace, "start_time_seconds", "Start time of the application attempt since unix epoch in seconds", constLabels),
+		"end_time_seconds":   newAttemptMetrics(namespace, "end_time_seconds", "End time of the completed application attempt since unix epoch in seconds", constLabels),
+		"duration_seconds":   newAttemptMetrics(namespace, "duration_seconds", "Duration of the application attempt in seconds, until now for running attempts", constLabels),
+		"completed":          newAttemptMetrics(namespace, "completed", "Whether the application attempt is completed", constLabels),
+	}
+}
+
+func newAttemptUserInfoMetric(namespace string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "application_user_info",
+			Help:        "User running the application attempt, with a constant value of 1",
+			ConstLabels: constLabels,
+		},
+		append(append([]string{}, attemptLabelNames...), sparkUserLabelName),
+	)
+}
+
+// attemptID returns the id of the i-th attempt of attempts. Applications
+// not running on a cluster manager retrying them, such as local ones, have
+// attempts without id: they are numbered from 1 in the order they were run,
+// the API listing the most recent attempt first.
+func attemptID(attempts []ApplicationAttempt, i int) string {
+	if id := attempts[i].AttemptID; id != "" {
+		return id
+	}
+	return strconv.Itoa(len(attempts) - i)
+}
+
+// setAttemptMetrics sets the metrics of attempt, computing the duration of
+// running attempts until now.
+func (e *Exporter) setAttemptMetrics(key appKey, id string, attempt ApplicationAttempt, now time.Time) {
+	labels := append(key.labelValues(), id)
+	completed := 0.0
+	if attempt.Completed {
+		completed = 1
+	}
+	e.attemptGaugeMetrics["completed"].WithLabelValues(labels...).Set(completed)
+	if e.applicationUserLabel && attempt.SparkUser != "" {
+		e.attemptGaugeMetrics["user_info"].WithLabelValues(append(labels, attempt.SparkUser)...).Set(1)
+	}
+
+	start, ok := attempt.startTime()
+	if !ok {
+		return
+	}
+	e.attemptGaugeMetrics["start_time_seconds"].WithLabelValues(labels...).Set(timeSeconds(start))
+	end := now
+	if attempt.Completed {
+		if end, ok = attempt.endTime(); !ok {
+			return
+		}
+		e.attemptGaugeMetrics["end_time_seconds"].WithLabelValues(labels...).Set(timeSeconds(end))
+	}
+	e.attemptGaugeMetrics["duration_seconds"].WithLabelValues(labels...).Set(end.Sub(start).Seconds())
+}
+
+// startTime returns the start time of the attempt, preferring the epoch
+// field of recent Spark versions to the formatted date.
+func (a ApplicationAttempt) startTime() (time.Time, bool) {
+	return attemptTime(a.StartTimeEpoch, a.StartTime)
+}
+
+// endTime returns the end time of the attempt. Running attempts have an end
+// time before the epoch, reported as missing.
+func (a ApplicationAttempt) endTime() (time.Time, bool) {
+	return attemptTime(a.EndTimeEpoch, a.EndTime)
+}
+
+func attemptTime(epochMillis int64, date string) (time.Time, bool) {
+	if epochMillis > 0 {
+		return time.Unix(0, epochMillis*int64(time.Millisecond)), true
+	}
+	t, err := parseSparkTime(date)
+	if err != nil || t.Unix() <= 0 {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// parseSparkTime parses a date returned by the Spark REST API, such as
+// 2023-01-02T15:04:05.123GMT.
+func parseSparkTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range sparkTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid spark date %q", s)
+}
+
+func timeSeconds(t time.Time) float64 {
+	return float64(t.Unix()) + float64(t.Nanosecond())/float64(time.Second)
+}
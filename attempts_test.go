@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseSparkTime(t *testing.T) {
+	want := time.Date(2023, 1, 2, 15, 4, 5, 123e6, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2023-01-02T15:04:05.123GMT", want},
+		{" 2023-01-02T15:04:05.123GMT\n", want},
+		{"2023-01-02T15:04:05GMT", want.Truncate(time.Second)},
+		{"2023-01-02T15:04:05.123Z", want},
+		{"2023-01-02T17:04:05.123+02:00", want},
+		{"2023-01-02T10:04:05.123-05:00", want},
+	}
+	for _, tt := range tests {
+		got, err := parseSparkTime(tt.value)
+		if err != nil {
+			t.Errorf("parseSparkTime(%q): %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseSparkTime(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	for _, value := range []string{"", "2023-01-02", "2023-01-02 15:04:05"} {
+		if _, err := parseSparkTime(value); err == nil {
+			t.Errorf("parseSparkTime(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestAttemptTimeMetrics(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications": `[{"id": "app-1", "name": "etl", "attempts": [
+			{"attemptId": "1", "completed": true, "startTime": "2023-01-02T15:04:05.250GMT", "endTime": "2023-01-02T15:14:05.750GMT"},
+			{"attemptId": "2", "completed": true, "startTime": "2023-01-02T16:00:00.000GMT", "startTimeEpoch": 1672675200500, "endTime": "2023-01-02T16:10:00.000GMT", "endTimeEpoch": 1672675800500},
+			{"attemptId": "3", "completed": false, "startTime": "2023-01-02T17:00:00.000GMT", "endTime": "1969-12-31T23:59:59.999GMT"}]}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{}))
+	checkSeries(t, families, []series{
+		{"spark_application_start_time_seconds", map[string]string{"app_id": "app-1", "attempt_id": "1"}, 1672671845.25},
+		{"spark_application_end_time_seconds", map[string]string{"app_id": "app-1", "attempt_id": "1"}, 1672672445.75},
+		// The epoch fields are preferred to the formatted dates.
+		{"spark_application_start_time_seconds", map[string]string{"app_id": "app-1", "attempt_id": "2"}, 1672675200.5},
+		{"spark_application_end_time_seconds", map[string]string{"app_id": "app-1", "attempt_id": "2"}, 1672675800.5},
+		{"spark_application_start_time_seconds", map[string]string{"app_id": "app-1", "attempt_id": "3"}, 1672678800},
+	})
+	// Running attempts have no end time.
+	if _, ok := metricValue(families, "spark_application_end_time_seconds", map[string]string{"attempt_id": "3"}); ok {
+		t.Error("spark_application_end_time_seconds exported for the running attempt")
+	}
+}
+
+func TestAttemptDurationMetrics(t *testing.T) {
+	s := newSparkServer(t, appRoutes(nil))
+	e := newTestExporter(t, []string{s.URL}, Options{})
+	key := appKey{"http://driver:4040", "app-1"}
+	now := time.Date(2023, 1, 2, 18, 0, 0, 0, time.UTC)
+	e.setAttemptMetrics(key, "1", ApplicationAttempt{AttemptID: "1", Completed: true, StartTime: "2023-01-02T15:04:05.250GMT", EndTime: "2023-01-02T15:14:05.750GMT"}, now)
+	e.setAttemptMetrics(key, "2", ApplicationAttempt{AttemptID: "2", StartTime: "2023-01-02T17:00:00.000GMT", EndTime: "1969-12-31T23:59:59.999GMT"}, now)
+
+	tests := []struct {
+		metric    string
+		attemptID string
+		want      float64
+	}{
+		{"duration_seconds", "1", 600.5},
+		{"completed", "1", 1},
+		// Running attempts last until now.
+		{"duration_seconds", "2", 3600},
+		{"completed", "2", 0},
+	}
+	for _, tt := range tests {
+		g := e.attemptGaugeMetrics[tt.metric].WithLabelValues(key.endpoint, key.id, tt.attemptID)
+		if got := testutil.ToFloat64(g); got != tt.want {
+			t.Errorf("got %s %v for attempt %s, want %v", tt.metric, got, tt.attemptID, tt.want)
+		}
+	}
+}
+
+func TestAttemptDurationScraped(t *testing.T) {
+	start := time.Now().Add(-time.Hour).UTC()
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications": `[{"id": "app-1", "attempts": [{"completed": false, "startTime": "` + start.Format("2006-01-02T15:04:05.000GMT") + `", "endTime": "1969-12-31T23:59:59.999GMT"}]}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{}))
+	got, ok := metricValue(families, "spark_application_duration_seconds", map[string]string{"app_id": "app-1"})
+	if !ok || got < 3599 || got > 3660 {
+		t.Errorf("got spark_application_duration_seconds %v (exported: %v), want about 3600", got, ok)
+	}
+	if got, _ := metricValue(families, "spark_application_completed", map[string]string{"app_id": "app-1"}); got != 0 {
+		t.Errorf("got spark_application_completed %v, want 0", got)
+	}
+}
+
+func TestAttemptIDs(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		// A YARN application restarted once, listed most recent attempt
+		// first, and a local application without attempt ids.
+		"applications": `[
+			{"id": "app-1", "name": "etl", "attempts": [
+				{"attemptId": "2", "completed": false, "startTime": "2023-01-02T16:00:00.000GMT"},
+				{"attemptId": "1", "completed": true, "startTime": "2023-01-02T15:00:00.000GMT", "endTime": "2023-01-02T15:30:00.000GMT"}]},
+			{"id": "local-1", "name": "shell", "attempts": [
+				{"completed": false, "startTime": "2023-01-02T18:00:00.000GMT"},
+				{"completed": true, "startTime": "2023-01-02T17:00:00.000GMT", "endTime": "2023-01-02T17:10:00.000GMT"}]}]`,
+		"applications/local-1/executors":   `[]`,
+		"applications/local-1/jobs":        `[]`,
+		"applications/local-1/stages":      `[]`,
+		"applications/local-1/storage/rdd": `[]`,
+		"applications/local-1/environment": `{}`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{}))
+	// Each attempt has its own series instead of overwriting the others.
+	checkSeries(t, families, []series{
+		{"spark_application_completed", map[string]string{"app_id": "app-1", "attempt_id": "1"}, 1},
+		{"spark_application_end_time_seconds", map[string]string{"app_id": "app-1", "attempt_id": "1"}, 1672673400},
+		{"spark_application_completed", map[string]string{"app_id": "app-1", "attempt_id": "2"}, 0},
+		{"spark_application_start_time_seconds", map[string]string{"app_id": "app-1", "attempt_id": "2"}, 1672675200},
+		{"spark_application_completed", map[string]string{"app_id": "local-1", "attempt_id": "1"}, 1},
+		{"spark_application_start_time_seconds", map[string]string{"app_id": "local-1", "attempt_id": "1"}, 1672678800},
+		{"spark_application_completed", map[string]string{"app_id": "local-1", "attempt_id": "2"}, 0},
+		{"spark_application_start_time_seconds", map[string]string{"app_id": "local-1", "attempt_id": "2"}, 1672682400},
+	})
+	if n := len(families["spark_application_completed"].GetMetric()); n != 4 {
+		t.Errorf("got %d spark_application_completed series, want one per attempt", n)
+	}
+}
+
+func TestApplicationUserInfo(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications": `[{"id": "app-1", "name": "etl", "attempts": [
+			{"attemptId": "2", "sparkUser": "etl-bot", "startTime": "2023-01-02T16:00:00.000GMT"},
+			{"attemptId": "1", "sparkUser": "alice", "completed": true, "startTime": "2023-01-02T15:00:00.000GMT"}]}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{}))
+	if mf := families["spark_application_user_info"]; mf != nil {
+		t.Errorf("got spark_application_user_info %v without --application.user-label", mf.Metric)
+	}
+
+	families = gather(t, newTestExporter(t, []string{s.URL}, Options{ApplicationUserLabel: true}))
+	checkSeries(t, families, []series{
+		{"spark_application_user_info", map[string]string{"endpoint": s.URL, "app_id": "app-1", "attempt_id": "2", "spark_user": "etl-bot"}, 1},
+		{"spark_application_user_info", map[string]string{"endpoint": s.URL, "app_id": "app-1", "attempt_id": "1", "spark_user": "alice"}, 1},
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// snapshotMetric is a metric as it was when collected, served by the
+// collections between background scrapes. The metrics of an Exporter can't
+// be served as is, as they are reset and set again by the next scrape.
+type snapshotMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+func (m snapshotMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m snapshotMetric) Write(out *dto.Metric) error {
+	out.Label = m.metric.Label
+	out.Gauge = m.metric.Gauge
+	out.Counter = m.metric.Counter
+	out.Summary = m.metric.Summary
+	out.Untyped = m.metric.Untyped
+	out.Histogram = m.metric.Histogram
+	out.TimestampMs = m.metric.TimestampMs
+	return nil
+}
+
+// newSnapshotMetric returns a copy of m holding its current value.
+func newSnapshotMetric(m prometheus.Metric) (prometheus.Metric, error) {
+	metric := &dto.Metric{}
+	if err := m.Write(metric); err != nil {
+		return nil, err
+	}
+	return snapshotMetric{m.Desc(), metric}, nil
+}
+
+// collectSnapshot sends the metrics of the last background scrape to ch.
+// Until a background scrape completes, Spark is scraped for the collection.
+func (e *Exporter) collectSnapshot(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.snapshotMutex.RLock()
+	snapshot := e.snapshot
+	e.snapshotMutex.RUnlock()
+	if snapshot == nil {
+		snapshot = e.refreshSnapshot(ctx)
+	}
+	for _, m := range snapshot {
+		ch <- m
+	}
+}
+
+// refreshSnapshot scrapes Spark and replaces the snapshot served by
+// collectSnapshot with copies of the collected metrics, which it returns.
+func (e *Exporter) refreshSnapshot(ctx context.Context) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		snapshot := []prometheus.Metric{}
+		for m := range ch {
+			copied, err := newSnapshotMetric(m)
+			if err != nil {
+				// Left out, as the registry would fail the whole
+				// collection on it.
+				continue
+			}
+			snapshot = append(snapshot, copied)
+		}
+		done <- snapshot
+	}()
+	e.scrapeAndCollect(ctx, ch)
+	close(ch)
+	snapshot := <-done
+
+	e.snapshotMutex.Lock()
+	e.snapshot = snapshot
+	e.snapshotMutex.Unlock()
+	return snapshot
+}
+
+// scrapeInBackground scrapes the active exporter of r in the background,
+// for /metrics to serve the result of the last scrape, until stop is closed.
+// The scrape interval is read again when the configuration is reloaded;
+// exporters without one are scraped by the collections instead.
+func scrapeInBackground(r *reloader, stop <-chan struct{}) {
+	// The exporter of the configuration the reloader started with is read
+	// below.
+	select {
+	case <-r.reloaded:
+	default:
+	}
+	for r.Exporter().scrapeEvery(r.reloaded, stop) {
+	}
+}
+
+// scrapeEvery scrapes e right away and then every e.scrapeInterval, until
+// reloaded receives a value or stop is closed. It reports whether it
+// returned because of a reload.
+func (e *Exporter) scrapeEvery(reloaded, stop <-chan struct{}) bool {
+	var tick <-chan time.Time
+	if e.scrapeInterval > 0 {
+		e.refreshSnapshot(context.Background())
+		ticker := time.NewTicker(e.scrapeInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			e.refreshSnapshot(context.Background())
+		case <-reloaded:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSnapshotBetweenTicks(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "driver", "isActive": true, "activeTasks": 1}]`,
+	}))
+	e := newTestExporter(t, []string{s.URL}, Options{ScrapeInterval: time.Hour})
+	driver := map[string]string{"executor_id": "driver"}
+
+	// Spark is scraped for the collections made before the first tick.
+	checkSeries(t, gather(t, e), []series{{"spark_executor_active_tasks", driver, 1}})
+
+	s.setRoute("applications/app-1/executors", `[{"id": "driver", "isActive": true, "activeTasks": 5}]`)
+	checkSeries(t, gather(t, e), []series{{"spark_executor_active_tasks", driver, 1}})
+	if n := s.requested("applications"); n != 1 {
+		t.Errorf("got %d requests to the applications endpoint between ticks, want 1", n)
+	}
+
+	// The snapshot holds copies of the metrics, which the scrapes made
+	// for other collections don't change, such as the error counters
+	// kept across scrapes.
+	s.setRoute("applications", `<html>`)
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for range ch {
+		}
+	}()
+	e.scrapeAndCollect(context.Background(), ch)
+	close(ch)
+	checkSeries(t, gather(t, e), []series{
+		{"spark_executor_active_tasks", driver, 1},
+		{"spark_exporter_scrape_errors_total", map[string]string{"reason": "decode"}, 0},
+	})
+
+	// The next tick replaces the snapshot.
+	s.setRoute("applications", testApplications)
+	e.refreshSnapshot(context.Background())
+	checkSeries(t, gather(t, e), []series{
+		{"spark_executor_active_tasks", driver, 5},
+		{"spark_exporter_scrape_errors_total", map[string]string{"reason": "decode"}, 1},
+	})
+}
+
+func TestScrapeInBackground(t *testing.T) {
+	s := newSparkServer(t, appRoutes(nil))
+	r := newTestReloader(t, fmt.Sprintf("targets: [%s]\nscrape_interval: 1h\n", s.URL))
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		scrapeInBackground(r, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// The first scrape is made right away, not after the interval.
+	deadline := time.Now().Add(5 * time.Second)
+	for s.requested("applications") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no background scrape before the first tick")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
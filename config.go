@@ -0,0 +1,525 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/version"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds the scrape settings of the exporter. It is loaded from the
+// file given with --config.file, and flags set on the command line override
+// the values of the file.
+type Config struct {
+	Targets           []string                 `yaml:"targets"`
+	MasterURI         string                   `yaml:"master_uri"`
+	YarnRMURI         string                   `yaml:"yarn_rm_uri"`
+	YarnATSURI        string                   `yaml:"yarn_ats_uri"`
+	Kubernetes        KubernetesConfig         `yaml:"kubernetes"`
+	Scheme            string                   `yaml:"scheme"`
+	APIPath           string                   `yaml:"api_path"`
+	Timeout           time.Duration            `yaml:"timeout"`
+	Timeouts          map[string]time.Duration `yaml:"timeouts"`
+	TargetConcurrency int                      `yaml:"target_concurrency"`
+	ScrapeTimeout     time.Duration            `yaml:"scrape_timeout"`
+	MaxConcurrency    int                      `yaml:"max_concurrency"`
+	CacheTTL          time.Duration            `yaml:"cache_ttl"`
+	ScrapeInterval    time.Duration            `yaml:"scrape_interval"`
+	Mode              string                   `yaml:"mode"`
+	History           HistoryConfig            `yaml:"history"`
+	Applications      ApplicationsConfig       `yaml:"applications"`
+	Executors         ExecutorsConfig          `yaml:"executors"`
+	Driver            DriverConfig             `yaml:"driver"`
+	Endpoints         []string                 `yaml:"endpoints"`
+
+	Auth               AuthConfig        `yaml:"auth"`
+	TLS                TLSConfig         `yaml:"tls"`
+	ProxyURL           string            `yaml:"proxy_url"`
+	UserAgent          string            `yaml:"user_agent"`
+	Headers            map[string]string `yaml:"headers"`
+	DisableCompression bool              `yaml:"disable_compression"`
+	Retries            int               `yaml:"retries"`
+	RetryBackoff       time.Duration     `yaml:"retry_backoff"`
+	MaxIdleConns       int               `yaml:"max_idle_conns"`
+
+	Labels      LabelsConfig      `yaml:"labels"`
+	ConstLabels map[string]string `yaml:"const_labels"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Stages      StagesConfig      `yaml:"stages"`
+	SQL         SQLConfig         `yaml:"sql"`
+}
+
+// KubernetesConfig holds the settings of the discovery of Spark driver pods.
+type KubernetesConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Namespace     string `yaml:"namespace"`
+	LabelSelector string `yaml:"label_selector"`
+}
+
+// ApplicationsConfig holds the filters of the applications scraped, by
+// name.
+type ApplicationsConfig struct {
+	NameInclude string `yaml:"name_include"`
+	NameExclude string `yaml:"name_exclude"`
+}
+
+// ExecutorsConfig holds the filters and settings of the executor metrics.
+type ExecutorsConfig struct {
+	IDInclude         string `yaml:"id_include"`
+	IDExclude         string `yaml:"id_exclude"`
+	KeepDriver        bool   `yaml:"keep_driver"`
+	Endpoint          string `yaml:"endpoint"`
+	PeakMemoryMetrics bool   `yaml:"peak_memory_metrics"`
+}
+
+// DriverConfig holds the settings of the driver metrics.
+type DriverConfig struct {
+	SeparateMetrics bool `yaml:"separate_metrics"`
+}
+
+// HistoryConfig holds the filters of the applications scraped from a
+// History Server.
+type HistoryConfig struct {
+	Status  string `yaml:"status"`
+	MinDate string `yaml:"min_date"`
+	MaxDate string `yaml:"max_date"`
+}
+
+// AuthConfig holds the credentials used to authenticate to Spark.
+type AuthConfig struct {
+	Type            string `yaml:"type"`
+	Krb5Config      string `yaml:"krb5_config"`
+	Keytab          string `yaml:"keytab"`
+	Principal       string `yaml:"principal"`
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	PasswordFile    string `yaml:"password_file"`
+	BearerToken     string `yaml:"bearer_token"`
+	BearerTokenFile string `yaml:"bearer_token_file"`
+}
+
+// TLSConfig holds the TLS settings used to connect to Spark.
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// LabelsConfig holds the optional labels added to metrics.
+type LabelsConfig struct {
+	ExecutorHostPort bool `yaml:"executor_host_port"`
+	RDDName          bool `yaml:"rdd_name"`
+	ApplicationUser  bool `yaml:"application_user"`
+	AppInName        bool `yaml:"app_in_name"`
+	ExecutorLogs     bool `yaml:"executor_logs"`
+}
+
+// MetricsConfig holds the settings of the names of the metrics.
+type MetricsConfig struct {
+	Namespace        string `yaml:"namespace"`
+	NativeHistograms bool   `yaml:"native_histograms"`
+}
+
+// StagesConfig holds the settings of the stage metrics.
+type StagesConfig struct {
+	IncludeCompleted  bool `yaml:"include_completed"`
+	TaskDistributions bool `yaml:"task_distributions"`
+	TaskDurations     bool `yaml:"task_durations"`
+}
+
+// SQLConfig holds the settings of the SQL execution metrics.
+type SQLConfig struct {
+	MaxExecutions int `yaml:"max_executions"`
+}
+
+// DefaultConfig returns the configuration used when no file is given.
+func DefaultConfig() *Config {
+	return &Config{
+		Targets:           []string{"http://localhost:4040"},
+		Scheme:            "http",
+		APIPath:           "/api/v1",
+		Timeout:           5 * time.Second,
+		TargetConcurrency: 4,
+		MaxConcurrency:    4,
+		Mode:              "live",
+		History: HistoryConfig{
+			Status: "running",
+		},
+		Kubernetes: KubernetesConfig{
+			LabelSelector: "spark-role=driver",
+		},
+		Executors: ExecutorsConfig{
+			KeepDriver: true,
+			Endpoint:   "active",
+		},
+		Endpoints: append([]string{}, defaultScrapeEndpoints...),
+		Auth: AuthConfig{
+			Krb5Config: "/etc/krb5.conf",
+		},
+		UserAgent:    "spark_exporter/" + version.Version,
+		RetryBackoff: 500 * time.Millisecond,
+		MaxIdleConns: 10,
+		Labels: LabelsConfig{
+			RDDName: true,
+		},
+		Metrics: MetricsConfig{
+			Namespace: defaultNamespace,
+		},
+		SQL: SQLConfig{
+			MaxExecutions: 100,
+		},
+	}
+}
+
+// LoadConfig reads the configuration file at path. Settings missing from the
+// file keep their default value.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := DefaultConfig()
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("can't parse config file %s: %v", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks the configuration for errors that can be detected without
+// reaching Spark.
+func (c *Config) Validate() error {
+	if len(c.Targets) == 0 && c.MasterURI == "" && c.YarnRMURI == "" && c.YarnATSURI == "" && !c.Kubernetes.Enabled {
+		return fmt.Errorf("no target to scrape")
+	}
+	if err := c.options().validateScheme(); err != nil {
+		return err
+	}
+	for _, uri := range []string{c.MasterURI, c.YarnRMURI, c.YarnATSURI} {
+		if uri == "" {
+			continue
+		}
+		if _, err := parseSparkURI(c.options().withScheme(uri)); err != nil {
+			return err
+		}
+	}
+	for _, t := range c.Targets {
+		_, uri, err := splitTargetAlias(t)
+		if err != nil {
+			return err
+		}
+		if _, err := parseSparkURI(c.options().withScheme(uri)); err != nil {
+			return err
+		}
+	}
+	if c.Auth.Password != "" && c.Auth.PasswordFile != "" {
+		return fmt.Errorf("only one of password and password file can be set")
+	}
+	if _, err := c.options().applicationsPath(); err != nil {
+		return err
+	}
+	if err := validateConstLabels(c.ConstLabels); err != nil {
+		return err
+	}
+	if _, err := c.options().applicationFilter(); err != nil {
+		return err
+	}
+	if _, err := c.options().executorFilter(); err != nil {
+		return err
+	}
+	if _, err := c.options().allExecutors(); err != nil {
+		return err
+	}
+	if _, err := c.options().namespace(); err != nil {
+		return err
+	}
+	if _, err := c.options().endpoints(); err != nil {
+		return err
+	}
+	opts, err := c.fetchOptions()
+	if err != nil {
+		return err
+	}
+	return opts.validate()
+}
+
+// fetchOptions returns the settings of the requests made to Spark, reading
+// the password file if any.
+func (c *Config) fetchOptions() (FetchOptions, error) {
+	opts := FetchOptions{
+		Timeout:  c.Timeout,
+		Timeouts: c.Timeouts,
+		APIPath:  c.APIPath,
+
+		AuthType:   c.Auth.Type,
+		Krb5Config: c.Auth.Krb5Config,
+		Keytab:     c.Auth.Keytab,
+		Principal:  c.Auth.Principal,
+
+		Username:        c.Auth.Username,
+		Password:        c.Auth.Password,
+		BearerToken:     c.Auth.BearerToken,
+		BearerTokenFile: c.Auth.BearerTokenFile,
+
+		CAFile:             c.TLS.CAFile,
+		CertFile:           c.TLS.CertFile,
+		KeyFile:            c.TLS.KeyFile,
+		InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+
+		ProxyURL:  c.ProxyURL,
+		UserAgent: c.UserAgent,
+		Headers:   c.Headers,
+
+		DisableCompression: c.DisableCompression,
+
+		Retries:      c.Retries,
+		RetryBackoff: c.RetryBackoff,
+		MaxIdleConns: c.MaxIdleConns,
+	}
+	if c.Auth.PasswordFile != "" {
+		password, err := readSecretFile(c.Auth.PasswordFile)
+		if err != nil {
+			return opts, fmt.Errorf("can't read password file: %v", err)
+		}
+		opts.Password = password
+	}
+	return opts, nil
+}
+
+// options returns the settings of the exported metrics.
+func (c *Config) options() Options {
+	return Options{
+		HostPortLabel:          c.Labels.ExecutorHostPort,
+		PeakMemoryMetrics:      c.Executors.PeakMemoryMetrics,
+		ConstLabels:            c.ConstLabels,
+		IncludeCompletedStages: c.Stages.IncludeCompleted,
+		TaskDistributions:      c.Stages.TaskDistributions,
+		TaskDurationHistogram:  c.Stages.TaskDurations,
+		NativeHistograms:       c.Metrics.NativeHistograms,
+		RDDNameLabel:           c.Labels.RDDName,
+		ApplicationUserLabel:   c.Labels.ApplicationUser,
+		ExecutorLogsLabel:      c.Labels.ExecutorLogs,
+		AppInName:              c.Labels.AppInName,
+		Namespace:              c.Metrics.Namespace,
+		SQLMaxExecutions:       c.SQL.MaxExecutions,
+		MasterURI:              c.MasterURI,
+		YarnRMURI:              c.YarnRMURI,
+		YarnATSURI:             c.YarnATSURI,
+		Scheme:                 c.Scheme,
+		K8sMode:                c.Kubernetes.Enabled,
+		K8sNamespace:           c.Kubernetes.Namespace,
+		K8sLabelSelector:       c.Kubernetes.LabelSelector,
+		TargetConcurrency:      c.TargetConcurrency,
+		ScrapeTimeout:          c.ScrapeTimeout,
+		MaxConcurrency:         c.MaxConcurrency,
+		CacheTTL:               c.CacheTTL,
+		ScrapeInterval:         c.ScrapeInterval,
+		Mode:                   c.Mode,
+		HistoryStatus:          c.History.Status,
+		MinDate:                c.History.MinDate,
+		MaxDate:                c.History.MaxDate,
+		AppNameInclude:         c.Applications.NameInclude,
+		AppNameExclude:         c.Applications.NameExclude,
+		ExecutorIDInclude:      c.Executors.IDInclude,
+		ExecutorIDExclude:      c.Executors.IDExclude,
+		KeepDriver:             c.Executors.KeepDriver,
+		SeparateDriverMetrics:  c.Driver.SeparateMetrics,
+		ExecutorEndpoint:       c.Executors.Endpoint,
+		Endpoints:              c.Endpoints,
+	}
+}
+
+// registerConfigFlags defines on fs the flags overriding the settings of c.
+// The current values of c are used as the flag defaults, so that parsing
+// flags after loading a file only overrides the settings given on the
+// command line.
+func registerConfigFlags(fs *flag.FlagSet, c *Config) {
+	fs.Var(newListFlag(&c.Targets), "spark.application-uri", "URI on which to scrape Spark application metrics, can be repeated or comma-separated")
+	fs.StringVar(&c.MasterURI, "spark.master-uri", c.MasterURI, "URI of the web UI of a Standalone Master to scrape cluster metrics from")
+	fs.StringVar(&c.Scheme, "spark.scheme", c.Scheme, "Scheme of the URIs given as bare host:port, including probe targets, one of http or https")
+	fs.StringVar(&c.YarnRMURI, "spark.yarn-rm-uri", c.YarnRMURI, "URI of a YARN ResourceManager whose running Spark applications are scraped through its proxy")
+	fs.StringVar(&c.YarnATSURI, "spark.yarn-ats-uri", c.YarnATSURI, "URI of a YARN Timeline Server to export the finished Spark applications from")
+	fs.StringVar(&c.APIPath, "spark.api-path", c.APIPath, "Path of the Spark REST API relative to the Spark URIs")
+	fs.BoolVar(&c.Kubernetes.Enabled, "spark.k8s-mode", c.Kubernetes.Enabled, "Discover and scrape the Spark driver pods of a namespace, when running in a Kubernetes cluster")
+	fs.StringVar(&c.Kubernetes.Namespace, "spark.k8s-namespace", c.Kubernetes.Namespace, "Namespace of the discovered Spark driver pods, the namespace of the exporter pod when empty")
+	fs.StringVar(&c.Kubernetes.LabelSelector, "spark.k8s-label-selector", c.Kubernetes.LabelSelector, "Label selector of the discovered Spark driver pods")
+	fs.DurationVar(&c.Timeout, "spark.timeout", c.Timeout, "Timeout for trying to get stats from Spark application")
+	for _, endpoint := range apiEndpoints {
+		fs.Var(newDurationMapFlag(&c.Timeouts, endpoint), "spark.timeout."+endpoint, "Timeout of the requests to the "+endpoint+" endpoint, --spark.timeout when unset")
+	}
+	fs.Var(newListFlag(&c.Endpoints), "scrape.endpoints", "Endpoint requested for each application among "+strings.Join(scrapeEndpoints, ", ")+", can be repeated or comma-separated")
+	fs.IntVar(&c.TargetConcurrency, "spark.target-concurrency", c.TargetConcurrency, "Number of Spark URIs scraped at the same time")
+	fs.DurationVar(&c.ScrapeTimeout, "spark.scrape-timeout", c.ScrapeTimeout, "Time a whole scrape of Spark can take, each request being bounded by --spark.timeout, 0 for no limit")
+	fs.IntVar(&c.MaxConcurrency, "spark.max-concurrency", c.MaxConcurrency, "Number of requests made to a Spark URI at the same time")
+	fs.DurationVar(&c.CacheTTL, "spark.cache-ttl", c.CacheTTL, "Time during which the result of a scrape is served again instead of scraping Spark, 0 to disable caching")
+	fs.DurationVar(&c.ScrapeInterval, "spark.scrape-interval", c.ScrapeInterval, "Interval of the scrapes of Spark made in the background, /metrics serving the result of the last one, 0 to scrape Spark on every request")
+	fs.StringVar(&c.Mode, "spark.mode", c.Mode, "Type of Spark endpoint scraped, either live for a driver UI or history for a History Server")
+	fs.StringVar(&c.History.Status, "spark.history.status", c.History.Status, "Only scrape History Server applications with this status (running or completed), empty for all")
+	fs.StringVar(&c.History.MinDate, "spark.history.min-date", c.History.MinDate, "Only scrape History Server applications started after this date (e.g. 2015-02-10)")
+	fs.StringVar(&c.History.MaxDate, "spark.history.max-date", c.History.MaxDate, "Only scrape History Server applications started before this date (e.g. 2015-02-10)")
+	fs.StringVar(&c.Applications.NameInclude, "spark.app-name-include", c.Applications.NameInclude, "Regex the names of the scraped applications must match")
+	fs.StringVar(&c.Applications.NameExclude, "spark.app-name-exclude", c.Applications.NameExclude, "Regex the names of the scraped applications must not match, taking precedence over --spark.app-name-include")
+
+	fs.StringVar(&c.Auth.Type, "spark.auth", c.Auth.Type, "Set to spnego to authenticate to Spark with Kerberos")
+	fs.StringVar(&c.Auth.Krb5Config, "spark.krb5-config", c.Auth.Krb5Config, "Kerberos configuration file for SPNEGO authentication")
+	fs.StringVar(&c.Auth.Keytab, "spark.keytab", c.Auth.Keytab, "Keytab file for SPNEGO authentication")
+	fs.StringVar(&c.Auth.Principal, "spark.principal", c.Auth.Principal, "Kerberos principal for SPNEGO authentication, e.g. spark_exporter@EXAMPLE.COM")
+	fs.StringVar(&c.Auth.Username, "spark.username", c.Auth.Username, "Username for HTTP basic authentication to Spark")
+	fs.StringVar(&c.Auth.Password, "spark.password", c.Auth.Password, "Password for HTTP basic authentication to Spark")
+	fs.StringVar(&c.Auth.PasswordFile, "spark.password-file", c.Auth.PasswordFile, "File containing the password for HTTP basic authentication to Spark")
+	fs.StringVar(&c.Auth.BearerToken, "spark.bearer-token", c.Auth.BearerToken, "Bearer token for authentication to Spark")
+	fs.StringVar(&c.Auth.BearerTokenFile, "spark.bearer-token-file", c.Auth.BearerTokenFile, "File containing the bearer token for authentication to Spark, read again on each request")
+	fs.StringVar(&c.TLS.CAFile, "spark.tls.ca-file", c.TLS.CAFile, "CA certificate file to verify the Spark server certificate")
+	fs.StringVar(&c.TLS.CertFile, "spark.tls.cert-file", c.TLS.CertFile, "Client certificate file for TLS authentication to Spark")
+	fs.StringVar(&c.TLS.KeyFile, "spark.tls.key-file", c.TLS.KeyFile, "Client key file for TLS authentication to Spark")
+	fs.BoolVar(&c.TLS.InsecureSkipVerify, "spark.tls.insecure-skip-verify", c.TLS.InsecureSkipVerify, "Disable verification of the Spark server certificate")
+	fs.StringVar(&c.ProxyURL, "spark.proxy-url", c.ProxyURL, "HTTP proxy for requests to Spark, taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables when empty")
+	fs.StringVar(&c.UserAgent, "spark.user-agent", c.UserAgent, "User-Agent header of requests to Spark")
+	fs.Var(newMapFlag(&c.Headers), "spark.header", "Header in key=value format added to requests to Spark, can be repeated")
+	fs.BoolVar(&c.DisableCompression, "spark.disable-compression", c.DisableCompression, "Don't request gzip-compressed responses from Spark, for proxies mangling them")
+	fs.IntVar(&c.Retries, "spark.retries", c.Retries, "Number of times failed requests to Spark are retried")
+	fs.DurationVar(&c.RetryBackoff, "spark.retry-backoff", c.RetryBackoff, "Time to wait before retrying a failed request to Spark, doubled on each retry")
+	fs.IntVar(&c.MaxIdleConns, "spark.max-idle-conns", c.MaxIdleConns, "Number of idle connections kept open to each Spark host between scrapes")
+
+	fs.StringVar(&c.Executors.IDInclude, "executor.id-include", c.Executors.IDInclude, "Regex the ids of the exported executors must match")
+	fs.StringVar(&c.Executors.IDExclude, "executor.id-exclude", c.Executors.IDExclude, "Regex the ids of the exported executors must not match, taking precedence over --executor.id-include")
+	fs.BoolVar(&c.Executors.KeepDriver, "executor.keep-driver", c.Executors.KeepDriver, "Export the driver whatever the executor id filters")
+	fs.BoolVar(&c.Driver.SeparateMetrics, "driver.separate-metrics", c.Driver.SeparateMetrics, "Export the driver in spark_driver_* metrics instead of the executor metrics")
+	fs.StringVar(&c.Executors.Endpoint, "executor.endpoint", c.Executors.Endpoint, "Executors to export, active ones or all the ones listed by /allexecutors, labeled by is_active")
+	fs.BoolVar(&c.Executors.PeakMemoryMetrics, "executor.peak-memory-metrics", c.Executors.PeakMemoryMetrics, "Export the peak memory metrics of executors, returned by Spark 3 and later")
+	fs.Var(newMapFlag(&c.ConstLabels), "label", "Label in name=value format added to all metrics, can be repeated")
+	fs.BoolVar(&c.Labels.ExecutorHostPort, "executor.host-port-label", c.Labels.ExecutorHostPort, "Add the executor host and port as a label to executor metrics")
+	fs.BoolVar(&c.Labels.ExecutorLogs, "executor.logs-label", c.Labels.ExecutorLogs, "Export the stdout and stderr log URLs of executors in spark_executor_logs_info")
+	fs.BoolVar(&c.Labels.RDDName, "rdd.name-label", c.Labels.RDDName, "Add the RDD name as a label to RDD metrics")
+	fs.BoolVar(&c.Labels.AppInName, "metrics.app-in-name", c.Labels.AppInName, "Put the application id in the metric names, e.g. spark_app_<id>_executor_active_tasks, instead of in an app_id label")
+	fs.BoolVar(&c.Metrics.NativeHistograms, "metrics.native-histograms", c.Metrics.NativeHistograms, "Export the task duration histogram as a native histogram instead of with fixed buckets")
+	fs.StringVar(&c.Metrics.Namespace, "metrics.namespace", c.Metrics.Namespace, "Prefix of the metric names, e.g. spark_prod to tell them apart from the metrics of other exporters")
+	fs.BoolVar(&c.Labels.ApplicationUser, "application.user-label", c.Labels.ApplicationUser, "Export the user running each application in spark_application_user_info")
+	fs.IntVar(&c.SQL.MaxExecutions, "sql.max-executions", c.SQL.MaxExecutions, "Maximum number of SQL executions exported per application, running ones first, 0 for no limit")
+	fs.BoolVar(&c.Stages.IncludeCompleted, "stages.include-completed", c.Stages.IncludeCompleted, "Export metrics of finished stages, not only active and pending ones")
+	fs.BoolVar(&c.Stages.TaskDistributions, "stages.task-distributions", c.Stages.TaskDistributions, "Export quantiles of the task metrics of active stages, requesting them for each stage")
+	fs.BoolVar(&c.Stages.TaskDurations, "stages.task-durations", c.Stages.TaskDurations, "Export a histogram of the durations of the tasks of the stages completing, requesting their tasks once")
+}
+
+// overrideConfig parses args again onto c, so that the flags set on the
+// command line take precedence over the values loaded from a file. The
+// other flags of the command line are accepted and left untouched.
+func overrideConfig(c *Config, args []string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	registerConfigFlags(fs, c)
+	flag.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) == nil {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	return fs.Parse(args)
+}
+
+// listFlag is a repeatable flag accepting comma-separated values. Values
+// given on the command line replace the default ones instead of adding to
+// them.
+type listFlag struct {
+	values *[]string
+	set    bool
+}
+
+func newListFlag(values *[]string) *listFlag {
+	return &listFlag{values: values}
+}
+
+func (f *listFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return strings.Join(*f.values, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	if !f.set {
+		*f.values = nil
+		f.set = true
+	}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*f.values = append(*f.values, v)
+		}
+	}
+	return nil
+}
+
+// durationMapFlag is a flag setting the duration of a single key of a map.
+type durationMapFlag struct {
+	values *map[string]time.Duration
+	key    string
+}
+
+func newDurationMapFlag(values *map[string]time.Duration, key string) *durationMapFlag {
+	return &durationMapFlag{values: values, key: key}
+}
+
+func (f *durationMapFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	if d, ok := (*f.values)[f.key]; ok {
+		return d.String()
+	}
+	return ""
+}
+
+func (f *durationMapFlag) Set(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if *f.values == nil {
+		*f.values = map[string]time.Duration{}
+	}
+	(*f.values)[f.key] = d
+	return nil
+}
+
+// mapFlag is a repeatable flag accepting key=value pairs. Pairs given on the
+// command line are added to the default ones.
+type mapFlag struct {
+	values *map[string]string
+}
+
+func newMapFlag(values *map[string]string) *mapFlag {
+	return &mapFlag{values: values}
+}
+
+func (f *mapFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	var pairs []string
+	for k, v := range *f.values {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f *mapFlag) Set(value string) error {
+	m, err := parseKeyValues([]string{value})
+	if err != nil {
+		return err
+	}
+	if *f.values == nil {
+		*f.values = map[string]string{}
+	}
+	for k, v := range m {
+		(*f.values)[k] = v
+	}
+	return nil
+}
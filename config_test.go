@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		check   func(t *testing.T, c *Config)
+		wantErr string
+	}{
+		{
+			name: "valid",
+			content: `
+targets:
+  - http://driver-1:4040
+  - http://driver-2:4040
+scrape_timeout: 30s
+timeout: 10s
+auth:
+  username: spark
+  password: secret
+tls:
+  insecure_skip_verify: true
+labels:
+  executor_host_port: true
+`,
+			check: func(t *testing.T, c *Config) {
+				if want := []string{"http://driver-1:4040", "http://driver-2:4040"}; !reflect.DeepEqual(c.Targets, want) {
+					t.Errorf("got targets %q, want %q", c.Targets, want)
+				}
+				if c.Timeout != 10*time.Second || c.ScrapeTimeout != 30*time.Second {
+					t.Errorf("got timeouts %v and %v, want 10s and 30s", c.Timeout, c.ScrapeTimeout)
+				}
+				if c.Auth.Username != "spark" || c.Auth.Password != "secret" {
+					t.Errorf("got credentials %q:%q, want spark:secret", c.Auth.Username, c.Auth.Password)
+				}
+				if !c.TLS.InsecureSkipVerify || !c.Labels.ExecutorHostPort {
+					t.Error("TLS or label settings not loaded")
+				}
+				// Settings missing from the file keep their default value.
+				if c.TargetConcurrency != DefaultConfig().TargetConcurrency {
+					t.Errorf("got target concurrency %d, want the default %d", c.TargetConcurrency, DefaultConfig().TargetConcurrency)
+				}
+			},
+		},
+		{
+			name:    "unknown key",
+			content: "targets: [http://driver:4040]\ntimeuot: 10s\n",
+			wantErr: "field timeuot not found",
+		},
+		{
+			name:    "invalid target",
+			content: "targets: [ftp://driver:4040]\n",
+			wantErr: `unsupported scheme "ftp"`,
+		},
+		{
+			name: "password and password file",
+			content: `
+auth:
+  username: spark
+  password: secret
+  password_file: /etc/spark/password
+`,
+			wantErr: "only one of password and password file can be set",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := LoadConfig(writeFile(t, "config.yml", tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestOverrideConfig(t *testing.T) {
+	c, err := LoadConfig(writeFile(t, "config.yml", `
+targets: [http://driver:4040]
+timeout: 10s
+retries: 3
+headers:
+  X-Tenant: etl
+const_labels:
+  cluster: staging
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"--spark.timeout=2s", "--spark.header=X-Requested-With=XMLHttpRequest", "--spark.application-uri=http://driver-1:4040,http://driver-2:4040",
+		"--label=cluster=prod", "--label=region=eu", "--spark.timeout.stages=15s", "--spark.max-idle-conns=20"}
+	if err := overrideConfig(c, args); err != nil {
+		t.Fatalf("overrideConfig: %v", err)
+	}
+	if c.Timeout != 2*time.Second {
+		t.Errorf("got timeout %v, want the 2s of the flag", c.Timeout)
+	}
+	if c.Retries != 3 {
+		t.Errorf("got %d retries, want the 3 of the file", c.Retries)
+	}
+	if want := (map[string]string{"X-Tenant": "etl", "X-Requested-With": "XMLHttpRequest"}); !reflect.DeepEqual(c.Headers, want) {
+		t.Errorf("got headers %v, want %v", c.Headers, want)
+	}
+	// The targets of the flag replace those of the file.
+	if want := []string{"http://driver-1:4040", "http://driver-2:4040"}; !reflect.DeepEqual(c.Targets, want) {
+		t.Errorf("got targets %q, want %q", c.Targets, want)
+	}
+	if want := (map[string]string{"cluster": "prod", "region": "eu"}); !reflect.DeepEqual(c.ConstLabels, want) {
+		t.Errorf("got const labels %v, want %v", c.ConstLabels, want)
+	}
+	if want := (map[string]time.Duration{"stages": 15 * time.Second}); !reflect.DeepEqual(c.Timeouts, want) {
+		t.Errorf("got timeouts %v, want %v", c.Timeouts, want)
+	}
+	if c.MaxIdleConns != 20 {
+		t.Errorf("got %d idle connections, want the 20 of the flag", c.MaxIdleConns)
+	}
+	if err := overrideConfig(c, []string{"--label=cluster"}); err == nil {
+		t.Error("got no error for a label without a value")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// discoverer lists the URIs of the Spark UIs to scrape from a cluster
+// manager, on every scrape.
+type discoverer struct {
+	// endpoint is the URI of the cluster manager, reported by spark_up.
+	endpoint string
+	discover func(ctx context.Context) ([]string, error)
+	// client, if set, is owned by the discoverer and released when its
+	// exporter is closed.
+	client httpDoer
+}
+
+// discoveredTargets returns the targets scraping uris. The targets of the
+// previous scrape are reused so that their connections are kept.
+func (e *Exporter) discoveredTargets(uris []string) []*target {
+	var targets []*target
+	known := map[string]*target{}
+	for _, uri := range uris {
+		if _, ok := known[uri]; ok {
+			continue
+		}
+		t, ok := e.discovered[uri]
+		if !ok {
+			t, ok = e.newDiscoveredTarget(uri)
+			if !ok {
+				continue
+			}
+		}
+		known[uri] = t
+		targets = append(targets, t)
+	}
+	for uri := range e.discovered {
+		if _, ok := known[uri]; !ok {
+			e.lastSuccess.DeleteLabelValues(uri)
+			e.endpointErrors.DeleteLabelValues(uri)
+		}
+	}
+	e.discovered = known
+	return targets
+}
+
+func (e *Exporter) newDiscoveredTarget(uri string) (*target, bool) {
+	if _, err := parseSparkURI(uri); err != nil {
+		log.Errorf("Ignoring discovered target %s: %v", uri, err)
+		return nil, false
+	}
+	fetch, err := fetchHTTPApi(uri, e.fetchOpts, e.client)
+	if err != nil {
+		log.Errorf("Ignoring discovered target %s: %v", uri, err)
+		return nil, false
+	}
+	return &target{endpoint: uri, fetch: fetch}, true
+}
+
+// close releases the clients owned by the discoverers of e. The client
+// shared with other exporters is left open.
+func (e *Exporter) close() {
+	for _, d := range e.discoverers {
+		if d.client != nil {
+			closeHTTPClient(d.client)
+		}
+	}
+}
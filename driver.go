@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const driverExecutorID = "driver"
+
+var driverLabelNames = []string{"endpoint", "app_id"}
+
+func newDriverMetrics(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "driver_" + metricName,
+			Help:        docString,
+			ConstLabels: constLabels,
+		},
+		driverLabelNames,
+	)
+}
+
+func newDriverGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"active_tasks":      newDriverMetrics(namespace, "active_tasks", "Current number of active tasks of the driver", constLabels),
+		"memory_used_bytes": newDriverMetrics(namespace, "memory_used_bytes", "Storage memory used by the driver in bytes", constLabels),
+		"max_memory_bytes":  newDriverMetrics(namespace, "max_memory_bytes", "Total storage memory available to the driver in bytes", constLabels),
+	}
+}
+
+// setDriverMetrics sets the driver metrics of the application from its
+// driver executor, exported instead of the executor metrics with
+// e.separateDriver.
+func (e *Exporter) setDriverMetrics(key appKey, ex ExecutorInfo) {
+	labels := key.labelValues()
+	e.driverGaugeMetrics["active_tasks"].WithLabelValues(labels...).Set(float64(ex.ActiveTasks))
+	e.driverGaugeMetrics["memory_used_bytes"].WithLabelValues(labels...).Set(float64(ex.MemoryUsed))
+	e.driverGaugeMetrics["max_memory_bytes"].WithLabelValues(labels...).Set(float64(ex.MaxMemory))
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestSeparateDriverMetrics(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[
+			{"id": "driver", "isActive": true, "activeTasks": 1, "memoryUsed": 1024, "maxMemory": 4096},
+			{"id": "1", "isActive": true, "activeTasks": 3, "memoryUsed": 2048, "maxMemory": 8192}]`,
+	}))
+	driver := map[string]string{"executor_id": "driver"}
+
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{KeepDriver: true, SeparateDriverMetrics: true}))
+	checkSeries(t, families, []series{
+		{"spark_driver_active_tasks", map[string]string{"app_id": "app-1"}, 1},
+		{"spark_driver_memory_used_bytes", map[string]string{"app_id": "app-1"}, 1024},
+		{"spark_driver_max_memory_bytes", map[string]string{"app_id": "app-1"}, 4096},
+		{"spark_executor_active_tasks", map[string]string{"executor_id": "1"}, 3},
+		// The driver still counts in the tasks of the application.
+		{"spark_application_active_tasks_total", map[string]string{"app_id": "app-1"}, 4},
+	})
+	for name, mf := range families {
+		for _, m := range mf.GetMetric() {
+			if hasLabel(m, "executor_id", "driver") {
+				t.Errorf("got the driver in %s with --driver.separate-metrics", name)
+			}
+		}
+	}
+
+	families = gather(t, newTestExporter(t, []string{s.URL}, Options{KeepDriver: true}))
+	checkSeries(t, families, []series{
+		{"spark_executor_active_tasks", driver, 1},
+	})
+	if mf := families["spark_driver_active_tasks"]; mf != nil {
+		t.Errorf("got spark_driver_active_tasks %v without --driver.separate-metrics", mf.Metric)
+	}
+}
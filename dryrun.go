@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// dryRun scrapes the targets of exporter once and writes the metrics to w in
+// the text exposition format, for --dry-run. It fails when an endpoint could
+// not be scraped.
+func dryRun(exporter *Exporter, w io.Writer) error {
+	mfs, scrapeErr := scrapeOnce(exporter)
+	if mfs == nil {
+		return scrapeErr
+	}
+	enc := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	return scrapeErr
+}
+
+// scrapeOnce scrapes the targets of exporter and returns the gathered
+// metrics. It fails when an endpoint could not be scraped, as reported by
+// spark_up, in which case the metrics are returned as well.
+func scrapeOnce(exporter *Exporter) ([]*dto.MetricFamily, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(exporter); err != nil {
+		return nil, err
+	}
+	mfs, err := exporter.gatherer(registry).Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	var down []string
+	for _, mf := range mfs {
+		if mf.GetName() != exporter.namespace+"_up" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			if m.GetGauge().GetValue() != 0 {
+				continue
+			}
+			for _, l := range m.Label {
+				if l.GetName() == "endpoint" {
+					down = append(down, l.GetValue())
+				}
+			}
+		}
+	}
+	if len(down) > 0 {
+		return mfs, fmt.Errorf("scrape failed for %v", down)
+	}
+	return mfs, nil
+}
+
+// checkStartup scrapes the targets of exporter once on startup, so that
+// misconfigured URIs are reported right away. A failed scrape is only logged
+// to logger unless failOnError.
+func checkStartup(exporter *Exporter, failOnError bool, logger log.FieldLogger) error {
+	if _, err := scrapeOnce(exporter); err != nil {
+		if failOnError {
+			return fmt.Errorf("startup scrape failed: %v", err)
+		}
+		logger.Warnf("Startup scrape failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDryRun(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "driver", "isActive": true, "activeTasks": 2}]`,
+	}))
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	tests := []struct {
+		name    string
+		uris    []string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "scraped",
+			uris: []string{s.URL},
+			want: []string{
+				"# TYPE spark_up gauge",
+				`spark_executor_active_tasks{app_id="app-1",endpoint="` + s.URL + `",executor_id="driver",target="` + strings.TrimPrefix(s.URL, "http://") + `"} 2`,
+			},
+		},
+		{
+			name:    "failed scrape",
+			uris:    []string{s.URL, down.URL},
+			want:    []string{`spark_up{endpoint="` + down.URL + `",target="` + strings.TrimPrefix(down.URL, "http://") + `"} 0`},
+			wantErr: "scrape failed for [" + down.URL + "]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestExporter(t, tt.uris, Options{})
+			var stdout bytes.Buffer
+			err := dryRun(e, &stdout)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("dryRun: %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			// The metrics are printed even when a scrape failed.
+			for _, want := range tt.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("the output doesn't contain %q:\n%s", want, stdout.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCheckStartup(t *testing.T) {
+	s := newSparkServer(t, map[string]string{"applications": `[]`})
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	tests := []struct {
+		name        string
+		uri         string
+		failOnError bool
+		wantErr     bool
+		wantWarning bool
+	}{
+		{name: "reachable", uri: s.URL},
+		{name: "reachable, failing on error", uri: s.URL, failOnError: true},
+		{name: "unreachable", uri: down.URL, wantWarning: true},
+		{name: "unreachable, failing on error", uri: down.URL, failOnError: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			logger := log.New()
+			logger.Out = &logged
+			e := newTestExporter(t, []string{tt.uri}, Options{})
+			err := checkStartup(e, tt.failOnError, logger)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want one: %v", err, tt.wantErr)
+			}
+			if got := strings.Contains(logged.String(), "level=warning msg=\"Startup scrape failed"); got != tt.wantWarning {
+				t.Errorf("got a startup warning: %v, want one: %v:\n%s", got, tt.wantWarning, logged.String())
+			}
+		})
+	}
+}
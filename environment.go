@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// environmentProperties maps the Spark properties exported as labels of
+// spark_application_info to their label name. Only these properties are
+// exported, so that the number of series stays bounded.
+var environmentProperties = map[string]string{
+	"spark.master":            "master",
+	"spark.submit.deployMode": "deploy_mode",
+	"spark.scheduler.mode":    "scheduler_mode",
+}
+
+var environmentLabelNames = []string{"endpoint", "app_id", "spark_version", "scala_version", "java_version", "master", "deploy_mode", "scheduler_mode"}
+
+// EnvironmentInfo holds the runtime and Spark properties of an application.
+// The system properties and classpath entries are not decoded.
+type EnvironmentInfo struct {
+	Runtime struct {
+		JavaVersion  string `json:"javaVersion"`
+		ScalaVersion string `json:"scalaVersion"`
+	} `json:"runtime"`
+	SparkProperties [][]string `json:"sparkProperties"`
+}
+
+// VersionInfo holds the version of Spark serving the REST API.
+type VersionInfo struct {
+	Spark string `json:"spark"`
+}
+
+func newApplicationInfoMetric(namespace string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "application_info",
+			Help:        "Runtime information of the application, with a constant value of 1",
+			ConstLabels: constLabels,
+		},
+		environmentLabelNames,
+	)
+}
+
+func (t *target) scrapeVersion(ctx context.Context) (string, error) {
+	body, err := t.fetch(ctx, "version")
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	var version VersionInfo
+	if err := json.NewDecoder(body).Decode(&version); err != nil {
+		return "", newDecodeError("version", err)
+	}
+	return version.Spark, nil
+}
+
+func (t *target) scrapeEnvironment(ctx context.Context, appID string) (EnvironmentInfo, error) {
+	body, err := t.fetch(ctx, "applications/"+url.PathEscape(appID)+"/environment")
+	if err != nil {
+		return EnvironmentInfo{}, err
+	}
+	defer body.Close()
+
+	return parseEnvironment(body)
+}
+
+func (e *Exporter) setApplicationInfo(key appKey, sparkVersion string, env EnvironmentInfo) {
+	properties := map[string]string{}
+	for _, p := range env.SparkProperties {
+		if len(p) == 2 {
+			if name, ok := environmentProperties[p[0]]; ok {
+				properties[name] = p[1]
+			}
+		}
+	}
+	labels := append(key.labelValues(),
+		sparkVersion,
+		strings.TrimPrefix(env.Runtime.ScalaVersion, "version "),
+		env.Runtime.JavaVersion,
+		properties["master"],
+		properties["deploy_mode"],
+		properties["scheduler_mode"],
+	)
+	e.applicationInfo.WithLabelValues(labels...).Set(1)
+}
+
+func parseEnvironment(r io.Reader) (EnvironmentInfo, error) {
+	var env EnvironmentInfo
+	if err := json.NewDecoder(r).Decode(&env); err != nil {
+		return env, newDecodeError("environment", err)
+	}
+	return env, nil
+}
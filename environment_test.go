@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// environmentSample is the environment of an application run by Spark 3.1.2
+// on YARN, trimmed of most system properties and classpath entries.
+const environmentSample = `{
+  "runtime": {
+    "javaVersion": "1.8.0_292 (Private Build)",
+    "javaHome": "/usr/lib/jvm/java-8-openjdk-amd64/jre",
+    "scalaVersion": "version 2.12.10"
+  },
+  "sparkProperties": [
+    ["spark.app.id", "application_1622547687443_0007"],
+    ["spark.app.name", "etl"],
+    ["spark.driver.host", "10.0.0.12"],
+    ["spark.executor.memory", "4g"],
+    ["spark.master", "yarn"],
+    ["spark.scheduler.mode", "FAIR"],
+    ["spark.submit.deployMode", "cluster"]
+  ],
+  "hadoopProperties": [
+    ["yarn.resourcemanager.address", "rm:8032"]
+  ],
+  "systemProperties": [
+    ["java.vm.name", "OpenJDK 64-Bit Server VM"],
+    ["user.name", "spark"]
+  ],
+  "classpathEntries": [
+    ["/opt/spark/jars/spark-core_2.12-3.1.2.jar", "System Classpath"]
+  ],
+  "resourceProfiles": []
+}`
+
+func TestParseEnvironment(t *testing.T) {
+	env, err := parseEnvironment(strings.NewReader(environmentSample))
+	if err != nil {
+		t.Fatalf("parseEnvironment: %v", err)
+	}
+	if env.Runtime.JavaVersion != "1.8.0_292 (Private Build)" || env.Runtime.ScalaVersion != "version 2.12.10" {
+		t.Errorf("got runtime %+v", env.Runtime)
+	}
+	if len(env.SparkProperties) != 7 || !reflect.DeepEqual(env.SparkProperties[4], []string{"spark.master", "yarn"}) {
+		t.Errorf("got Spark properties %q", env.SparkProperties)
+	}
+}
+
+func TestApplicationInfo(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"version":                        `{"spark": "3.1.2"}`,
+		"applications/app-1/environment": environmentSample,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{}))
+	labels := map[string]string{
+		"endpoint":       s.URL,
+		"target":         strings.TrimPrefix(s.URL, "http://"),
+		"app_id":         "app-1",
+		"spark_version":  "3.1.2",
+		"scala_version":  "2.12.10",
+		"java_version":   "1.8.0_292 (Private Build)",
+		"master":         "yarn",
+		"deploy_mode":    "cluster",
+		"scheduler_mode": "FAIR",
+	}
+	checkSeries(t, families, []series{
+		{"spark_application_info", labels, 1},
+	})
+	// Only the allowlisted properties are labels.
+	if m := findMetric(families["spark_application_info"], labels); m != nil && len(m.Label) != len(labels) {
+		t.Errorf("got labels %v, want %v", m.Label, labels)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var executorLogsLabelNames = []string{"stdout_url", "stderr_url"}
+
+func newExecutorLogsInfoMetric(namespace string, labelNames []string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return newGaugeExecutorMetrics(namespace, "logs_info", "Log URLs of the executor, with a constant value of 1", append(append([]string{}, labelNames...), executorLogsLabelNames...), constLabels)
+}
+
+func (e *Exporter) setExecutorLogsMetric(labels []string, ex ExecutorInfo) {
+	stdout, stderr := logURL(ex.ExecutorLogs.Stdout), logURL(ex.ExecutorLogs.Stderr)
+	if stdout == "" && stderr == "" {
+		return
+	}
+	e.executorGaugeMetrics["logs_info"].WithLabelValues(append(labels, stdout, stderr)...).Set(1)
+}
+
+// logURL returns the log URL s without credentials, or an empty string when
+// it isn't an http or https URL.
+func logURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	u.User = nil
+	return u.String()
+}
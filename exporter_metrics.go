@@ -0,0 +1,87 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+)
+
+// startTime is the time the exporter process started, kept by the metrics
+// built again when the namespace changes on a reload.
+var startTime = time.Now()
+
+// exporterMetrics are the metrics of the exporter process itself. They are
+// kept across configuration reloads, unlike the metrics of an Exporter, so
+// that latency quantiles can be computed over time, as long as the
+// namespace they are built for doesn't change.
+type exporterMetrics struct {
+	namespace string
+	registry  *prometheus.Registry
+
+	scrapeDurations     prometheus.Histogram
+	buildInfo           *prometheus.GaugeVec
+	startTime           prometheus.Gauge
+	configReloadSuccess prometheus.Gauge
+	configReloadSeconds prometheus.Gauge
+}
+
+func newExporterMetrics(namespace string) *exporterMetrics {
+	m := &exporterMetrics{
+		namespace: namespace,
+		registry:  prometheus.NewRegistry(),
+		scrapeDurations: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "scrape_duration_histogram_seconds",
+			Help:      "Histogram of the durations of the scrapes to Spark in seconds.",
+			Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+		}),
+		buildInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "build_info",
+			Help:      "Version, revision, branch and Go version the exporter was built from, with a constant value of 1.",
+		}, []string{"version", "revision", "branch", "goversion"}),
+		// Unlike process_start_time_seconds, also exported with
+		// --web.disable-exporter-metrics and on other systems than Linux.
+		startTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "start_time_seconds",
+			Help:      "Start time of the exporter since unix epoch in seconds.",
+		}),
+		configReloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "config_last_reload_success",
+			Help:      "Whether the last configuration reload attempt was successful.",
+		}),
+		configReloadSeconds: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration reload.",
+		}),
+	}
+	m.buildInfo.WithLabelValues(version.Version, version.Revision, version.Branch, version.GoVersion).Set(1)
+	m.startTime.Set(float64(startTime.UnixNano()) / 1e9)
+	m.registry.MustRegister(
+		m.scrapeDurations,
+		m.buildInfo,
+		m.startTime,
+		m.configReloadSuccess,
+		m.configReloadSeconds,
+	)
+	return m
+}
+
+// gatherer returns the gatherer of the metrics of the exporter process,
+// together with the Go runtime and process metrics of the default registry
+// unless disableRuntime is set.
+func (m *exporterMetrics) gatherer(disableRuntime bool) prometheus.Gatherer {
+	if disableRuntime {
+		return m.registry
+	}
+	return prometheus.Gatherers{m.registry, prometheus.DefaultGatherer}
+}
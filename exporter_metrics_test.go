@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+)
+
+func TestNamespace(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/allexecutors": `[{"id": "driver", "isActive": true}]`,
+	}))
+	tests := []struct {
+		namespace  string
+		wantPrefix string
+	}{
+		{namespace: "", wantPrefix: "spark_"},
+		{namespace: "spark_prod", wantPrefix: "spark_prod_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantPrefix, func(t *testing.T) {
+			e := newTestExporter(t, []string{s.URL}, Options{Namespace: tt.namespace})
+			families := gather(t, e)
+			metrics := newExporterMetrics(e.namespace)
+			metrics.scrapeDurations.Observe(1)
+			mfs, err := metrics.registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mf := range mfs {
+				families[mf.GetName()] = mf
+			}
+			for _, name := range []string{"up", "executor_active_tasks", "application_executors_total", "exporter_scrape_duration_seconds", "exporter_scrape_duration_histogram_seconds", "exporter_build_info", "exporter_config_last_reload_success"} {
+				if families[tt.wantPrefix+name] == nil {
+					t.Errorf("%s%s not exported", tt.wantPrefix, name)
+				}
+			}
+			for name := range families {
+				if !strings.HasPrefix(name, tt.wantPrefix) {
+					t.Errorf("%s exported without the %s prefix", name, tt.wantPrefix)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidNamespace(t *testing.T) {
+	if _, err := NewExporter([]string{"http://driver:4040"}, FetchOptions{}, Options{Namespace: "spark-prod"}); err == nil {
+		t.Error("NewExporter succeeded with the namespace spark-prod, want an error")
+	}
+}
+
+func TestDisableExporterMetrics(t *testing.T) {
+	tests := []struct {
+		disable       bool
+		wantGoRuntime bool
+	}{
+		{disable: false, wantGoRuntime: true},
+		{disable: true, wantGoRuntime: false},
+	}
+	for _, tt := range tests {
+		mfs, err := newExporterMetrics("spark").gatherer(tt.disable).Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := map[string]bool{}
+		for _, mf := range mfs {
+			names[mf.GetName()] = true
+		}
+		if names["go_goroutines"] != tt.wantGoRuntime {
+			t.Errorf("got go_goroutines: %v with exporter metrics disabled: %v, want it: %v", names["go_goroutines"], tt.disable, tt.wantGoRuntime)
+		}
+		// The version is exported either way.
+		if !names["spark_exporter_build_info"] {
+			t.Errorf("spark_exporter_build_info not exported with exporter metrics disabled: %v", tt.disable)
+		}
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	defer func(v, r, b string) {
+		version.Version, version.Revision, version.Branch = v, r, b
+	}(version.Version, version.Revision, version.Branch)
+	version.Version, version.Revision, version.Branch = "1.2.3", "abc123", "main"
+
+	mfs, err := newExporterMetrics("spark").registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	families := map[string]*dto.MetricFamily{}
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf
+	}
+	checkSeries(t, families, []series{
+		{"spark_exporter_build_info", map[string]string{"version": "1.2.3", "revision": "abc123", "branch": "main", "goversion": version.GoVersion}, 1},
+		{"spark_exporter_start_time_seconds", nil, float64(startTime.UnixNano()) / 1e9},
+	})
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FetchOptions holds the settings of the HTTP requests made to Spark.
+type FetchOptions struct {
+	// Timeout bounds the time taken by a request, unless Timeouts holds a
+	// timeout for its endpoint, keyed by one of apiEndpoints.
+	Timeout  time.Duration
+	Timeouts map[string]time.Duration
+
+	// APIPath is the path of the REST API relative to the Spark URIs,
+	// /api/v1 when empty.
+	APIPath string
+
+	// Username and Password enable HTTP basic authentication when Username
+	// is set.
+	Username string
+	Password string
+
+	// BearerToken is sent in the Authorization header of every request.
+	// BearerTokenFile is read again on every request instead, so that
+	// rotated tokens are picked up.
+	BearerToken     string
+	BearerTokenFile string
+
+	// AuthType is "spnego" to authenticate with Kerberos, using the keytab
+	// of Principal and the Krb5Config file. Other settings set basic or
+	// bearer token authentication.
+	AuthType   string
+	Krb5Config string
+	Keytab     string
+	Principal  string
+
+	// TLS settings for https URIs. CertFile and KeyFile enable client
+	// certificate authentication and must be set together.
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+
+	// ProxyURL is the HTTP proxy requests go through. When empty, the
+	// proxy is taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables.
+	ProxyURL string
+
+	// UserAgent and Headers are set on every request.
+	UserAgent string
+	Headers   map[string]string
+
+	// DisableCompression stops requesting gzip-compressed responses, for
+	// proxies mangling them.
+	DisableCompression bool
+
+	// Retries is the number of times a request failing with a connection
+	// error or a 5xx status is retried, waiting RetryBackoff before the
+	// first retry and twice as long before each following one.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// MaxIdleConns is the number of idle keep-alive connections kept open
+	// to each Spark host between scrapes.
+	MaxIdleConns int
+}
+
+func (o FetchOptions) validate() error {
+	for endpoint := range o.Timeouts {
+		if !isAPIEndpoint(endpoint) {
+			return fmt.Errorf("invalid timeout endpoint %q: must be one of %s", endpoint, strings.Join(apiEndpoints, ", "))
+		}
+	}
+	if o.BearerToken != "" && o.BearerTokenFile != "" {
+		return fmt.Errorf("only one of bearer token and bearer token file can be set")
+	}
+	if o.Username != "" && (o.BearerToken != "" || o.BearerTokenFile != "") {
+		return fmt.Errorf("only one of basic authentication and bearer token authentication can be set")
+	}
+	switch o.AuthType {
+	case "":
+	case "spnego":
+		if o.Username != "" || o.BearerToken != "" || o.BearerTokenFile != "" {
+			return fmt.Errorf("SPNEGO authentication can't be combined with basic or bearer token authentication")
+		}
+		if o.Keytab == "" || o.Principal == "" {
+			return fmt.Errorf("SPNEGO authentication requires a keytab and a principal")
+		}
+	default:
+		return fmt.Errorf("invalid spark auth %q: must be empty or spnego", o.AuthType)
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return fmt.Errorf("TLS client certificate and key files must be set together")
+	}
+	if _, err := o.proxy(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// proxy returns the function selecting the proxy of a request.
+func (o FetchOptions) proxy() (func(*http.Request) (*url.URL, error), error) {
+	if o.ProxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := url.Parse(o.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %s: missing scheme or host", o.ProxyURL)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// tlsConfig builds the TLS configuration used to connect to Spark.
+func (o FetchOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// newHTTPClient returns the client sending the requests of the exporters
+// built from opts, authenticating with SPNEGO when opts.AuthType is spnego.
+func newHTTPClient(opts FetchOptions) (httpDoer, error) {
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := opts.proxy()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	// Discovered drivers can be many, so only the connections per host are
+	// limited.
+	transport.MaxIdleConns = 0
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConns
+	// Compressed responses are requested and decoded by httpFetcher, also
+	// when the Accept-Encoding header is set by Headers.
+	transport.DisableCompression = true
+	httpClient := &http.Client{Transport: transport}
+	if opts.AuthType == "spnego" {
+		return newSPNEGOClient(opts, httpClient)
+	}
+	return httpClient, nil
+}
+
+// closeHTTPClient releases a client returned by newHTTPClient once no
+// exporter uses it anymore.
+func closeHTTPClient(c httpDoer) {
+	switch c := c.(type) {
+	case *spnegoClient:
+		c.close()
+	case *http.Client:
+		c.CloseIdleConnections()
+	}
+}
+
+// fetchHTTPApi returns a function fetching the given path relative to the
+// REST API root of the Spark application reachable at uri. Requests are
+// cancelled when the passed context is done.
+func fetchHTTPApi(uri string, opts FetchOptions, client httpDoer) (func(ctx context.Context, path string) (io.ReadCloser, error), error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	apiPath := opts.APIPath
+	if apiPath == "" {
+		apiPath = "/api/v1"
+	}
+	u.Path = path.Join("/", u.Path, apiPath)
+	return fetchHTTP(u.String(), opts, client), nil
+}
+
+// fetchHTTP returns a function fetching the given path relative to uri with
+// client.
+func fetchHTTP(uri string, opts FetchOptions, client httpDoer) func(ctx context.Context, path string) (io.ReadCloser, error) {
+	f := &httpFetcher{
+		uri:    strings.TrimRight(uri, "/"),
+		opts:   opts,
+		client: client,
+	}
+	return f.fetch
+}
+
+// httpFetcher fetches paths of the REST API of a Spark application.
+type httpFetcher struct {
+	uri    string
+	opts   FetchOptions
+	client httpDoer
+}
+
+// fetch fetches path, retrying up to opts.Retries times with an exponential
+// backoff when the request fails with a connection error or a 5xx status.
+func (f *httpFetcher) fetch(ctx context.Context, path string) (io.ReadCloser, error) {
+	backoff := f.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		body, retryable, err := f.fetchOnce(ctx, path)
+		if err == nil || !retryable {
+			return body, err
+		}
+		if attempt >= f.opts.Retries {
+			if attempt > 0 {
+				err = &retriesError{attempts: attempt + 1, err: err}
+			}
+			return nil, err
+		}
+		log.Debugf("Retrying request to %s in %v: %v", f.uri, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// fetchOnce fetches path and reports whether a failed request can be
+// retried.
+func (f *httpFetcher) fetchOnce(ctx context.Context, path string) (io.ReadCloser, bool, error) {
+	reqCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout := f.opts.timeout(path); timeout > 0 {
+		reqCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	req, err := f.newRequest(reqCtx, path)
+	if err != nil {
+		cancel()
+		return nil, false, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, ctx.Err() == nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, resp.StatusCode >= 500, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	body, err := decodeBody(resp)
+	if err != nil {
+		resp.Body.Close()
+		cancel()
+		return nil, false, err
+	}
+	return &cancelReadCloser{body, cancel}, false, nil
+}
+
+// decodeBody returns the body of resp decompressed according to its
+// Content-Encoding header.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+	switch encoding := strings.ToLower(resp.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip":
+		r, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		r, err = zlib.NewReader(resp.Body)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, newDecodeError(resp.Header.Get("Content-Encoding")+" response", err)
+	}
+	return &decodedReadCloser{r, resp.Body}, nil
+}
+
+// decodedReadCloser reads a decompressed body, closing both the decoder and
+// the body.
+type decodedReadCloser struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (r *decodedReadCloser) Close() error {
+	r.ReadCloser.Close()
+	return r.body.Close()
+}
+
+// cancelReadCloser releases the context of a request once its body is
+// closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReadCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// apiEndpoints are the REST API endpoints whose timeout can be set apart
+// from the others.
+var apiEndpoints = []string{"applications", "executors", "jobs", "stages", "storage", "environment", "sql", "streaming"}
+
+func isAPIEndpoint(name string) bool {
+	for _, e := range apiEndpoints {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// apiEndpoint returns the endpoint of path: the resource requested for an
+// application, such as stages for applications/<id>/stages/<stage>, or the
+// first segment of the path otherwise. The allexecutors endpoint shares the
+// timeout of executors.
+func apiEndpoint(path string) string {
+	path = strings.SplitN(path, "?", 2)[0]
+	parts := strings.Split(path, "/")
+	if len(parts) >= 3 && parts[0] == "applications" {
+		if parts[2] == "allexecutors" {
+			return "executors"
+		}
+		return parts[2]
+	}
+	return parts[0]
+}
+
+// timeout returns the timeout of the requests of path.
+func (o FetchOptions) timeout(path string) time.Duration {
+	if t, ok := o.Timeouts[apiEndpoint(path)]; ok {
+		return t
+	}
+	return o.Timeout
+}
+
+func (f *httpFetcher) newRequest(ctx context.Context, path string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", f.uri+"/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range f.opts.Headers {
+		req.Header.Set(name, value)
+	}
+	if f.opts.UserAgent != "" {
+		req.Header.Set("User-Agent", f.opts.UserAgent)
+	}
+	if !f.opts.DisableCompression && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	if f.opts.Username != "" {
+		req.SetBasicAuth(f.opts.Username, f.opts.Password)
+	}
+	token := f.opts.BearerToken
+	if f.opts.BearerTokenFile != "" {
+		token, err = readSecretFile(f.opts.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read bearer token file: %v", err)
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// readSecretFile returns the content of the file at path without its
+// trailing newline.
+func readSecretFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// parseKeyValues parses a list of key=value pairs into a map.
+func parseKeyValues(pairs []string) (map[string]string, error) {
+	m := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid %q: must be in key=value format", pair)
+		}
+		m[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return m, nil
+}
+
+// statusError is returned for responses without a 200 status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "HTTP status " + e.status
+}
+
+// retriesError is returned when a request still fails once retried
+// opts.Retries times.
+type retriesError struct {
+	attempts int
+	err      error
+}
+
+func (e *retriesError) Error() string {
+	return fmt.Sprintf("giving up after %d attempts: %v", e.attempts, e.err)
+}
+
+func (e *retriesError) Unwrap() error {
+	return e.err
+}
+
+// decodeError is returned for responses that can't be decoded.
+type decodeError struct {
+	what string
+	err  error
+}
+
+func newDecodeError(what string, err error) error {
+	return &decodeError{what: what, err: err}
+}
+
+func (e *decodeError) Error() string {
+	return "can't decode " + e.what + ": " + e.err.Error()
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+const scrapeErrorReasonLabelName = "reason"
+
+// scrapeErrorReasons are the values of the reason label of
+// spark_exporter_scrape_errors_total.
+var scrapeErrorReasons = []string{"timeout", "connection", "http_status", "decode", "retries_exhausted", "other"}
+
+// scrapeErrorReason classifies err, returned by a scrape, into one of
+// scrapeErrorReasons.
+func scrapeErrorReason(err error) string {
+	var (
+		re *retriesError
+		ne net.Error
+		ue *url.Error
+		se *statusError
+		de *decodeError
+	)
+	switch {
+	case errors.As(err, &re):
+		return "retries_exhausted"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	case errors.As(err, &se):
+		return "http_status"
+	case errors.As(err, &de):
+		return "decode"
+	case errors.As(err, &ue), errors.As(err, &ne):
+		return "connection"
+	}
+	return "other"
+}
+
+// isNotFound reports whether err is due to a 404 response, returned by
+// Spark for the endpoints not applying to an application.
+func isNotFound(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == http.StatusNotFound
+}
@@ -0,0 +1,694 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	stdlog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fetchBody fetches path from the REST API of uri with opts and returns the
+// body of the response.
+func fetchBody(ctx context.Context, t *testing.T, uri string, opts FetchOptions, path string) (string, error) {
+	t.Helper()
+	client, err := newHTTPClient(opts)
+	if err != nil {
+		t.Fatalf("newHTTPClient: %v", err)
+	}
+	fetch, err := fetchHTTPApi(uri, opts, client)
+	if err != nil {
+		t.Fatalf("fetchHTTPApi: %v", err)
+	}
+	body, err := fetch(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	return string(b), err
+}
+
+// writeFile writes content to the file name of a temporary directory and
+// returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBasicAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "spark" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		opts    FetchOptions
+		wantErr bool
+	}{
+		{name: "unauthenticated", wantErr: true},
+		{name: "password", opts: FetchOptions{Username: "spark", Password: "secret"}},
+		{name: "wrong password", opts: FetchOptions{Username: "spark", Password: "guess"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := fetchBody(context.Background(), t, server.URL, tt.opts, "applications")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want one: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadSecretFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{name: "trailing newline", path: writeFile(t, "password", "secret\n"), want: "secret"},
+		{name: "CRLF", path: writeFile(t, "password", "secret\r\n"), want: "secret"},
+		{name: "missing", path: filepath.Join(t.TempDir(), "missing"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readSecretFile(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want one: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	if _, err := fetchBody(context.Background(), t, server.URL, FetchOptions{BearerToken: "static"}, "applications"); err != nil {
+		t.Fatal(err)
+	}
+	// The token file is read again on each request.
+	tokenFile := writeFile(t, "token", "first\n")
+	opts := FetchOptions{BearerTokenFile: tokenFile}
+	client, err := newHTTPClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetch, err := fetchHTTPApi(server.URL, opts, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"first", "second"} {
+		if err := ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		body, err := fetch(context.Background(), "applications")
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		body.Close()
+	}
+	want := []string{"Bearer static", "Bearer first", "Bearer second"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got Authorization headers %q, want %q", got, want)
+	}
+}
+
+func TestFetchOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    FetchOptions
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "basic", opts: FetchOptions{Username: "spark", Password: "secret"}},
+		{name: "bearer", opts: FetchOptions{BearerToken: "token"}},
+		{name: "token and file", opts: FetchOptions{BearerToken: "token", BearerTokenFile: "/token"}, wantErr: true},
+		{name: "basic and bearer", opts: FetchOptions{Username: "spark", BearerTokenFile: "/token"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want one: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testCertificate is a self-signed certificate valid for 127.0.0.1, usable
+// both as a CA and as a server or client certificate.
+type testCertificate struct {
+	cert     tls.Certificate
+	pool     *x509.CertPool
+	certFile string
+	keyFile  string
+}
+
+func newTestCertificate(t *testing.T, name string) testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return testCertificate{
+		cert:     cert,
+		pool:     pool,
+		certFile: writeFile(t, name+".crt", string(certPEM)),
+		keyFile:  writeFile(t, name+".key", string(keyPEM)),
+	}
+}
+
+func TestTLS(t *testing.T) {
+	serverCert := newTestCertificate(t, "server")
+	clientCert := newTestCertificate(t, "client")
+	otherCA := newTestCertificate(t, "other")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert.cert}}
+	server.Config.ErrorLog = stdlog.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	mutualServer := httptest.NewUnstartedServer(server.Config.Handler)
+	mutualServer.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert.cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCert.pool,
+	}
+	mutualServer.Config.ErrorLog = server.Config.ErrorLog
+	mutualServer.StartTLS()
+	defer mutualServer.Close()
+
+	tests := []struct {
+		name    string
+		uri     string
+		opts    FetchOptions
+		wantErr bool
+	}{
+		{name: "unknown CA", uri: server.URL, wantErr: true},
+		{name: "other CA", uri: server.URL, opts: FetchOptions{CAFile: otherCA.certFile}, wantErr: true},
+		{name: "CA file", uri: server.URL, opts: FetchOptions{CAFile: serverCert.certFile}},
+		{name: "insecure skip verify", uri: server.URL, opts: FetchOptions{InsecureSkipVerify: true}},
+		{name: "missing client certificate", uri: mutualServer.URL, opts: FetchOptions{CAFile: serverCert.certFile}, wantErr: true},
+		{
+			name: "client certificate",
+			uri:  mutualServer.URL,
+			opts: FetchOptions{CAFile: serverCert.certFile, CertFile: clientCert.certFile, KeyFile: clientCert.keyFile},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := fetchBody(context.Background(), t, tt.uri, tt.opts, "applications")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want one: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSCertificateWithoutKey(t *testing.T) {
+	cert := newTestCertificate(t, "client")
+	for _, opts := range []FetchOptions{{CertFile: cert.certFile}, {KeyFile: cert.keyFile}} {
+		if err := opts.validate(); err == nil {
+			t.Errorf("validate succeeded with certificate file %q and key file %q, want an error", opts.CertFile, opts.KeyFile)
+		}
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	opts := FetchOptions{
+		UserAgent: "probe/1.0",
+		Headers:   map[string]string{"X-Requested-With": "XMLHttpRequest", "User-Agent": "overridden"},
+	}
+	if _, err := fetchBody(context.Background(), t, server.URL, opts, "applications"); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"User-Agent": "probe/1.0", "X-Requested-With": "XMLHttpRequest"} {
+		if got := got.Get(name); got != want {
+			t.Errorf("got %s %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	got, err := parseKeyValues([]string{"X-Requested-With=XMLHttpRequest", "X-Tenant = etl", "X-Empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"X-Requested-With": "XMLHttpRequest", "X-Tenant": "etl", "X-Empty": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, pair := range []string{"X-Requested-With", "=value"} {
+		if _, err := parseKeyValues([]string{pair}); err == nil {
+			t.Errorf("parsing %q succeeded, want an error", pair)
+		}
+	}
+}
+
+func TestFetchCancelled(t *testing.T) {
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+			w.Write([]byte("[]"))
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := fetchBody(ctx, t, server.URL, FetchOptions{}, "applications"); err == nil {
+		t.Fatal("fetch succeeded, want it cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("fetch returned after %v, want it cancelled after 100ms", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("the request was not cancelled on the server")
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		retries      int
+		wantRequests int
+		wantErr      bool
+	}{
+		{name: "succeeds once retried", status: http.StatusServiceUnavailable, retries: 2, wantRequests: 3},
+		{name: "retries exhausted", status: http.StatusServiceUnavailable, retries: 1, wantRequests: 2, wantErr: true},
+		{name: "no retries", status: http.StatusServiceUnavailable, wantRequests: 1, wantErr: true},
+		{name: "client error not retried", status: http.StatusNotFound, retries: 2, wantRequests: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The server fails the first two requests.
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= 2 {
+					http.Error(w, http.StatusText(tt.status), tt.status)
+					return
+				}
+				w.Write([]byte("[]"))
+			}))
+			defer server.Close()
+
+			opts := FetchOptions{Retries: tt.retries, RetryBackoff: time.Millisecond}
+			body, err := fetchBody(context.Background(), t, server.URL, opts, "applications")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %q, want an error", body)
+				}
+			} else if err != nil || body != "[]" {
+				t.Errorf("got %q, %v, want [] once retried", body, err)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestFetchRetryCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// The backoff is not waited for once the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	opts := FetchOptions{Retries: 3, RetryBackoff: time.Minute}
+	if _, err := fetchBody(ctx, t, server.URL, opts, "applications"); err == nil {
+		t.Fatal("fetch succeeded, want an error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("fetch returned after %v, want it cancelled during the backoff", elapsed)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Write([]byte("[]"))
+	}))
+	defer proxy.Close()
+
+	// The driver host doesn't resolve, so that only a request through the
+	// proxy succeeds.
+	const driver = "http://spark-driver.invalid:4040"
+	if _, err := fetchBody(context.Background(), t, driver, FetchOptions{ProxyURL: proxy.URL}, "applications"); err != nil {
+		t.Fatalf("fetch through the proxy: %v", err)
+	}
+	if want := []string{driver + "/api/v1/applications"}; !reflect.DeepEqual(proxied, want) {
+		t.Errorf("got proxied requests %q, want %q", proxied, want)
+	}
+
+	for _, proxyURL := range []string{"proxy:3128", "http://", "://proxy"} {
+		if err := (FetchOptions{ProxyURL: proxyURL}).validate(); err == nil {
+			t.Errorf("validate succeeded with proxy URL %q, want an error", proxyURL)
+		}
+	}
+}
+
+func TestAPIPath(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Path
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		base    string
+		apiPath string
+		want    string
+	}{
+		{base: server.URL, want: "/api/v1/applications"},
+		{base: server.URL + "/", want: "/api/v1/applications"},
+		{base: server.URL + "/proxy/app", apiPath: "/api/v1", want: "/proxy/app/api/v1/applications"},
+		{base: server.URL + "/proxy/app/", apiPath: "/spark/api/v1/", want: "/proxy/app/spark/api/v1/applications"},
+		{base: server.URL, apiPath: "spark/api/v1", want: "/spark/api/v1/applications"},
+	}
+	for _, tt := range tests {
+		if _, err := fetchBody(context.Background(), t, tt.base, FetchOptions{APIPath: tt.apiPath}, "applications"); err != nil {
+			t.Errorf("%s with API path %q: %v", tt.base, tt.apiPath, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s with API path %q: got request of %s, want %s", tt.base, tt.apiPath, got, tt.want)
+		}
+	}
+}
+
+func TestEndpointTimeouts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		opts          FetchOptions
+		wantStagesErr bool
+		wantOtherErr  bool
+	}{
+		{
+			name:          "shorter stages timeout",
+			opts:          FetchOptions{Timeout: time.Second, Timeouts: map[string]time.Duration{"stages": 20 * time.Millisecond}},
+			wantStagesErr: true,
+		},
+		{
+			name:         "longer stages timeout",
+			opts:         FetchOptions{Timeout: 20 * time.Millisecond, Timeouts: map[string]time.Duration{"stages": time.Second}},
+			wantOtherErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"applications/app-1/stages", "applications/app-1/stages/3/0/taskSummary"} {
+				if _, err := fetchBody(context.Background(), t, server.URL, tt.opts, path); (err != nil) != tt.wantStagesErr {
+					t.Errorf("%s: got error %v, want one: %v", path, err, tt.wantStagesErr)
+				}
+			}
+			for _, path := range []string{"applications/app-1/executors", "applications"} {
+				if _, err := fetchBody(context.Background(), t, server.URL, tt.opts, path); (err != nil) != tt.wantOtherErr {
+					t.Errorf("%s: got error %v, want one: %v", path, err, tt.wantOtherErr)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIEndpoint(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"applications", "applications"},
+		{"applications?status=running", "applications"},
+		{"applications/app-1/executors", "executors"},
+		{"applications/app-1/stages/3/0/taskSummary?quantiles=0.5", "stages"},
+		{"applications/app-1/storage/rdd", "storage"},
+		{"applications/app-1/sql?details=false", "sql"},
+		{"applications/app-1/streaming/statistics", "streaming"},
+		{"version", "version"},
+	}
+	for _, tt := range tests {
+		if got := apiEndpoint(tt.path); got != tt.want {
+			t.Errorf("apiEndpoint(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if err := (FetchOptions{Timeouts: map[string]time.Duration{"stage": time.Second}}).validate(); err == nil {
+		t.Error("got no error for the timeout of an unknown endpoint")
+	}
+}
+
+// countDials makes the transport of client count the connections it opens.
+func countDials(t *testing.T, client httpDoer) *int32 {
+	t.Helper()
+	c, ok := client.(*http.Client)
+	if !ok {
+		t.Fatalf("got a %T client, want an *http.Client", client)
+	}
+	transport := c.Transport.(*http.Transport)
+	dial := transport.DialContext
+	var dials int32
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return dial(ctx, network, addr)
+	}
+	return &dials
+}
+
+func TestConnectionReuse(t *testing.T) {
+	s := newSparkServer(t, testCluster(2))
+	configFile := writeFile(t, "config.yml", fmt.Sprintf("targets: [%s]\nmax_concurrency: 1\n", s.URL))
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newReloader(cfg, configFile, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeHTTPClient(r.client)
+	dials := countDials(t, r.client)
+
+	steps := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"scrape", func(t *testing.T) { gather(t, r.Exporter()) }},
+		{"scrape again", func(t *testing.T) { gather(t, r.Exporter()) }},
+		{"reload", func(t *testing.T) {
+			if err := r.reload(); err != nil {
+				t.Fatal(err)
+			}
+			gather(t, r.Exporter())
+		}},
+		{"probe", func(t *testing.T) {
+			if rec := probe(t, probeHandler(r.options, 0), s.URL); rec.Code != http.StatusOK {
+				t.Fatalf("got status %d", rec.Code)
+			}
+		}},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			step.run(t)
+			if n := atomic.LoadInt32(dials); n != 1 {
+				t.Errorf("got %d connections opened, want the first one reused", n)
+			}
+		})
+	}
+	if n := s.requested("applications"); n != len(steps) {
+		t.Errorf("got %d scrapes, want %d", n, len(steps))
+	}
+}
+
+func TestMaxIdleConns(t *testing.T) {
+	client, err := newHTTPClient(FetchOptions{MaxIdleConns: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeHTTPClient(client)
+	transport := client.(*http.Client).Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 20 {
+		t.Errorf("got %d idle connections per host, want 20", transport.MaxIdleConnsPerHost)
+	}
+	if !transport.DisableKeepAlives && transport.IdleConnTimeout == 0 {
+		t.Error("got idle connections kept open forever")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	const body = `[{"id": "app-1", "name": "etl"}]`
+	encode := func(encoding string) []byte {
+		var b bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&b)
+		case "deflate":
+			w = zlib.NewWriter(&b)
+		default:
+			return []byte(body)
+		}
+		w.Write([]byte(body))
+		w.Close()
+		return b.Bytes()
+	}
+	tests := []struct {
+		name               string
+		encoding           string
+		response           []byte
+		disableCompression bool
+		wantAccept         string
+		wantErr            bool
+	}{
+		{name: "gzip", encoding: "gzip", response: encode("gzip"), wantAccept: "gzip"},
+		{name: "deflate", encoding: "deflate", response: encode("deflate"), wantAccept: "gzip"},
+		{name: "identity", response: encode(""), wantAccept: "gzip"},
+		{name: "compression disabled", response: encode(""), disableCompression: true},
+		{name: "corrupt gzip", encoding: "gzip", response: []byte(body), wantAccept: "gzip", wantErr: true},
+		{name: "unsupported encoding", encoding: "br", response: []byte(body), wantAccept: "gzip", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var accept string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				accept = r.Header.Get("Accept-Encoding")
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Write(tt.response)
+			}))
+			defer server.Close()
+
+			got, err := fetchBody(context.Background(), t, server.URL, FetchOptions{DisableCompression: tt.disableCompression}, "applications")
+			if accept != tt.wantAccept {
+				t.Errorf("got Accept-Encoding %q, want %q", accept, tt.wantAccept)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("fetch succeeded with %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != body {
+				t.Errorf("got %q, %v, want %q", got, err, body)
+			}
+		})
+	}
+}
+
+// closeRecorder records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestDecodedBodyClosed(t *testing.T) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte("[]"))
+	w.Close()
+	body := &closeRecorder{Reader: &b}
+	resp := &http.Response{Header: http.Header{"Content-Encoding": {"gzip"}}, Body: body}
+	r, err := decodeBody(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !body.closed {
+		t.Error("closing the decoded body left the response body open")
+	}
+}
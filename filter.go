@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// nameFilter selects names matching an include and not matching an exclude
+// regular expression. The expressions must match the whole name, and the
+// exclude expression wins when a name matches both.
+type nameFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+// newNameFilter compiles the include and exclude expressions, an empty
+// expression disabling the corresponding filter.
+func newNameFilter(include, exclude string) (*nameFilter, error) {
+	f := &nameFilter{}
+	var err error
+	if f.include, err = compileAnchored(include); err != nil {
+		return nil, fmt.Errorf("invalid include regex %q: %v", include, err)
+	}
+	if f.exclude, err = compileAnchored(exclude); err != nil {
+		return nil, fmt.Errorf("invalid exclude regex %q: %v", exclude, err)
+	}
+	return f, nil
+}
+
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func (f *nameFilter) match(name string) bool {
+	if f.include != nil && !f.include.MatchString(name) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(name)
+}
@@ -0,0 +1,155 @@
+package main
+
+import "testing"
+
+func TestNameFilter(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude string
+		match            []string
+		noMatch          []string
+	}{
+		{name: "no filter", match: []string{"team-a-etl", ""}},
+		{name: "include", include: "team-a-.*", match: []string{"team-a-etl"}, noMatch: []string{"team-b-etl"}},
+		// The expressions match whole names.
+		{name: "anchored", include: "etl", match: []string{"etl"}, noMatch: []string{"team-a-etl", "etl-test"}},
+		{name: "alternation anchored", include: "etl|report", match: []string{"etl", "report"}, noMatch: []string{"etl-report", "my-report"}},
+		{name: "exclude", exclude: ".*-test", match: []string{"team-a-etl"}, noMatch: []string{"team-a-test"}},
+		{name: "exclude wins", include: "team-a-.*", exclude: ".*-test", match: []string{"team-a-etl"}, noMatch: []string{"team-a-test", "team-b-etl"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newNameFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.match {
+				if !f.match(name) {
+					t.Errorf("%q filtered out, want it kept", name)
+				}
+			}
+			for _, name := range tt.noMatch {
+				if f.match(name) {
+					t.Errorf("%q kept, want it filtered out", name)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidNameFilter(t *testing.T) {
+	for _, opts := range []Options{{AppNameInclude: "team-("}, {AppNameExclude: "[a-"}} {
+		if _, err := NewExporter([]string{"http://driver:4040"}, FetchOptions{}, opts); err == nil {
+			t.Errorf("NewExporter with %+v succeeded, want an error", opts)
+		}
+	}
+}
+
+func TestApplicationFilter(t *testing.T) {
+	s := newSparkServer(t, map[string]string{
+		"applications": `[
+			{"id": "app-1", "name": "team-a-etl", "attempts": []},
+			{"id": "app-2", "name": "team-a-test", "attempts": []},
+			{"id": "app-3", "name": "team-b-etl", "attempts": []}]`,
+		"applications/app-1/executors":   `[{"id": "driver", "isActive": true}]`,
+		"applications/app-1/jobs":        `[]`,
+		"applications/app-1/stages":      `[]`,
+		"applications/app-1/storage/rdd": `[]`,
+	})
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{AppNameInclude: "team-a-.*", AppNameExclude: ".*-test"}))
+	checkSeries(t, families, []series{
+		{"spark_application_executors_total", map[string]string{"app_id": "app-1"}, 1},
+	})
+	// The filtered out applications are not requested nor exported.
+	for _, id := range []string{"app-2", "app-3"} {
+		if n := s.requested("applications/" + id + "/executors"); n != 0 {
+			t.Errorf("got %d requests for the executors of %s, want 0", n, id)
+		}
+		if _, ok := metricValue(families, "spark_application_executors_total", map[string]string{"app_id": id}); ok {
+			t.Errorf("metrics of %s exported", id)
+		}
+	}
+}
+
+func TestApplicationsDiscovered(t *testing.T) {
+	s := newSparkServer(t, map[string]string{
+		"applications": `[
+			{"id": "app-1", "name": "team-a-etl", "attempts": []},
+			{"id": "app-2", "name": "team-a-report", "attempts": []},
+			{"id": "app-3", "name": "team-a-test", "attempts": []},
+			{"id": "app-4", "name": "team-b-etl", "attempts": []},
+			{"id": "app-5", "name": "team-b-report", "attempts": []}]`,
+		"applications/app-1/executors": `[{"id": "driver", "isActive": true}]`,
+		"applications/app-2/executors": `[{"id": "driver", "isActive": true}]`,
+	})
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"executors"}, AppNameInclude: "team-a-.*", AppNameExclude: ".*-test"}))
+	endpoint := map[string]string{"endpoint": s.URL}
+	checkSeries(t, families, []series{
+		{"spark_exporter_applications_discovered", endpoint, 5},
+		{"spark_exporter_applications_scraped", endpoint, 2},
+	})
+}
+
+func TestExecutorFilter(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[
+			{"id": "driver", "isActive": true},
+			{"id": "1", "isActive": true},
+			{"id": "2", "isActive": true},
+			{"id": "12", "isActive": true},
+			{"id": "21", "isActive": false}]`,
+	}))
+	tests := []struct {
+		name         string
+		opts         Options
+		want         []string
+		wantFiltered float64
+	}{
+		{name: "no filter", opts: Options{KeepDriver: true}, want: []string{"driver", "1", "2", "12", "21"}},
+		// The ids are matched whole, 1 not selecting 12 nor 21.
+		{name: "numeric ids", opts: Options{ExecutorIDInclude: "1|2", KeepDriver: true}, want: []string{"driver", "1", "2"}, wantFiltered: 2},
+		{name: "driver not kept", opts: Options{ExecutorIDInclude: "[0-9]+"}, want: []string{"1", "2", "12", "21"}, wantFiltered: 1},
+		{name: "driver kept despite the exclude", opts: Options{ExecutorIDExclude: "driver|2.*", KeepDriver: true}, want: []string{"driver", "1", "12"}, wantFiltered: 2},
+		{name: "exclude wins", opts: Options{ExecutorIDInclude: "[0-9]+", ExecutorIDExclude: "2|21"}, want: []string{"1", "12"}, wantFiltered: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families := gather(t, newTestExporter(t, []string{s.URL}, tt.opts))
+			var got []string
+			for _, m := range families["spark_executor_active_tasks"].GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "executor_id" {
+						got = append(got, l.GetValue())
+					}
+				}
+			}
+			if !sameStrings(got, tt.want) {
+				t.Errorf("got executors %q, want %q", got, tt.want)
+			}
+			if got, _ := metricValue(families, "spark_exporter_executors_filtered_total", nil); got != tt.wantFiltered {
+				t.Errorf("got %v executors filtered, want %v", got, tt.wantFiltered)
+			}
+			// The application metrics still count all the executors.
+			if got, _ := metricValue(families, "spark_application_executors_total", nil); got != 5 {
+				t.Errorf("got spark_application_executors_total %v, want 5", got)
+			}
+		})
+	}
+}
+
+// sameStrings reports whether a and b hold the same strings in any order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := map[string]int{}
+	for _, s := range a {
+		count[s]++
+	}
+	for _, s := range b {
+		if count[s]--; count[s] < 0 {
+			return false
+		}
+	}
+	return true
+}
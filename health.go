@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// readiness tracks the outcome of the scrapes to report whether the exporter
+// is ready to serve metrics.
+type readiness struct {
+	mutex sync.Mutex
+	// failureThreshold is the number of consecutive failed scrapes after
+	// which the exporter is not ready anymore.
+	failureThreshold int
+	scraped          bool
+	failures         int
+}
+
+func newReadiness(failureThreshold int) *readiness {
+	return &readiness{failureThreshold: failureThreshold}
+}
+
+// record records the outcome of a scrape.
+func (r *readiness) record(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil {
+		r.failures++
+		return
+	}
+	r.scraped = true
+	r.failures = 0
+}
+
+// ready reports whether a scrape has succeeded and the last scrapes did not
+// all fail.
+func (r *readiness) ready() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.scraped {
+		return false
+	}
+	return r.failureThreshold <= 0 || r.failures < r.failureThreshold
+}
+
+// healthyHandler reports that the process is up.
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Healthy.\n"))
+}
+
+// readyHandler returns a handler reporting whether the exporter is ready.
+func readyHandler(readiness *readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !readiness.ready() {
+			http.Error(w, "Not ready.", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Ready.\n"))
+	}
+}
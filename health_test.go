@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthyHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	healthyHandler(rec, httptest.NewRequest("GET", "/-/healthy", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want 200", rec.Code)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	failed := errors.New("connection refused")
+	tests := []struct {
+		name       string
+		scrapes    []error
+		wantStatus int
+	}{
+		{name: "never scraped", wantStatus: http.StatusServiceUnavailable},
+		{name: "only failed scrapes", scrapes: []error{failed}, wantStatus: http.StatusServiceUnavailable},
+		{name: "scraped", scrapes: []error{nil}, wantStatus: http.StatusOK},
+		{name: "failing below the threshold", scrapes: []error{nil, failed, failed}, wantStatus: http.StatusOK},
+		{name: "failing at the threshold", scrapes: []error{nil, failed, failed, failed}, wantStatus: http.StatusServiceUnavailable},
+		{name: "recovered", scrapes: []error{nil, failed, failed, failed, nil}, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readiness := newReadiness(3)
+			for _, err := range tt.scrapes {
+				readiness.record(err)
+			}
+			rec := httptest.NewRecorder()
+			readyHandler(readiness).ServeHTTP(rec, httptest.NewRequest("GET", "/-/ready", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReadyAfterScrape(t *testing.T) {
+	s := newSparkServer(t, map[string]string{"applications": `[]`})
+	e := newTestExporter(t, []string{s.URL}, Options{})
+	e.readiness = newReadiness(3)
+	handler := readyHandler(e.readiness)
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusOK} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/-/ready", nil))
+		if rec.Code != want {
+			t.Errorf("got status %d, want %d", rec.Code, want)
+		}
+		gather(t, e)
+	}
+}
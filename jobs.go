@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	jobLabelNames       = []string{"endpoint", "app_id", "job_id"}
+	jobStatusLabelNames = []string{"endpoint", "app_id", "job_id", "status"}
+	jobStatuses         = []string{"RUNNING", "SUCCEEDED", "FAILED", "UNKNOWN"}
+)
+
+// JobInfo holds the metrics of a single job of an application
+type JobInfo struct {
+	JobID              int    `json:"jobId"`
+	Name               string `json:"name"`
+	Status             string `json:"status"`
+	NumTasks           int    `json:"numTasks"`
+	NumActiveTasks     int    `json:"numActiveTasks"`
+	NumCompletedTasks  int    `json:"numCompletedTasks"`
+	NumFailedTasks     int    `json:"numFailedTasks"`
+	NumActiveStages    int    `json:"numActiveStages"`
+	NumCompletedStages int    `json:"numCompletedStages"`
+	NumFailedStages    int    `json:"numFailedStages"`
+	NumSkippedStages   int    `json:"numSkippedStages"`
+	SubmissionTime     string `json:"submissionTime"`
+	CompletionTime     string `json:"completionTime"`
+}
+
+func newJobMetrics(namespace string, metricName string, docString string, labelNames []string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "job_" + metricName,
+			Help:        docString,
+			ConstLabels: constLabels,
+		},
+		labelNames,
+	)
+}
+
+func newJobGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"tasks":            newJobMetrics(namespace, "tasks", "Number of tasks of the job", jobLabelNames, constLabels),
+		"active_tasks":     newJobMetrics(namespace, "active_tasks", "Number of active tasks of the job", jobLabelNames, constLabels),
+		"completed_tasks":  newJobMetrics(namespace, "completed_tasks", "Number of completed tasks of the job", jobLabelNames, constLabels),
+		"failed_tasks":     newJobMetrics(namespace, "failed_tasks", "Number of failed tasks of the job", jobLabelNames, constLabels),
+		"active_stages":    newJobMetrics(namespace, "active_stages", "Number of active stages of the job", jobLabelNames, constLabels),
+		"completed_stages": newJobMetrics(namespace, "completed_stages", "Number of completed stages of the job", jobLabelNames, constLabels),
+		"failed_stages":    newJobMetrics(namespace, "failed_stages", "Number of failed stages of the job", jobLabelNames, constLabels),
+		"skipped_stages":   newJobMetrics(namespace, "skipped_stages", "Number of stages of the job skipped as their output was already available, not a failure", jobLabelNames, constLabels),
+		"status":           newJobMetrics(namespace, "status", "Status of the job, 1 for the current status", jobStatusLabelNames, constLabels),
+
+		"submission_time_seconds": newJobMetrics(namespace, "submission_time_seconds", "Time the job was submitted since unix epoch in seconds", jobLabelNames, constLabels),
+		"completion_time_seconds": newJobMetrics(namespace, "completion_time_seconds", "Time the job completed since unix epoch in seconds", jobLabelNames, constLabels),
+		"duration_seconds":        newJobMetrics(namespace, "duration_seconds", "Duration of the job in seconds, until now for running jobs", jobLabelNames, constLabels),
+	}
+}
+
+func (t *target) scrapeJobs(ctx context.Context, appID string) ([]JobInfo, error) {
+	body, err := t.fetch(ctx, "applications/"+url.PathEscape(appID)+"/jobs")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return parseJobs(body)
+}
+
+// setJobMetrics sets the metrics of job, computing the duration of running
+// jobs until now.
+func (e *Exporter) setJobMetrics(key appKey, job JobInfo, now time.Time) {
+	jobID := fmt.Sprint(job.JobID)
+	labels := append(key.labelValues(), jobID)
+	e.jobGaugeMetrics["tasks"].WithLabelValues(labels...).Set(float64(job.NumTasks))
+	e.jobGaugeMetrics["active_tasks"].WithLabelValues(labels...).Set(float64(job.NumActiveTasks))
+	e.jobGaugeMetrics["completed_tasks"].WithLabelValues(labels...).Set(float64(job.NumCompletedTasks))
+	e.jobGaugeMetrics["failed_tasks"].WithLabelValues(labels...).Set(float64(job.NumFailedTasks))
+	e.jobGaugeMetrics["active_stages"].WithLabelValues(labels...).Set(float64(job.NumActiveStages))
+	e.jobGaugeMetrics["completed_stages"].WithLabelValues(labels...).Set(float64(job.NumCompletedStages))
+	e.jobGaugeMetrics["failed_stages"].WithLabelValues(labels...).Set(float64(job.NumFailedStages))
+	e.jobGaugeMetrics["skipped_stages"].WithLabelValues(labels...).Set(float64(job.NumSkippedStages))
+	for _, status := range jobStatuses {
+		v := 0.0
+		if job.Status == status {
+			v = 1
+		}
+		e.jobGaugeMetrics["status"].WithLabelValues(append(key.labelValues(), jobID, status)...).Set(v)
+	}
+
+	submission, err := parseSparkTime(job.SubmissionTime)
+	if err != nil {
+		return
+	}
+	e.jobGaugeMetrics["submission_time_seconds"].WithLabelValues(labels...).Set(timeSeconds(submission))
+	end := now
+	if job.CompletionTime != "" {
+		if end, err = parseSparkTime(job.CompletionTime); err != nil {
+			return
+		}
+		e.jobGaugeMetrics["completion_time_seconds"].WithLabelValues(labels...).Set(timeSeconds(end))
+	}
+	e.jobGaugeMetrics["duration_seconds"].WithLabelValues(labels...).Set(end.Sub(submission).Seconds())
+}
+
+func parseJobs(r io.Reader) ([]JobInfo, error) {
+	var jobs []JobInfo
+	if err := json.NewDecoder(r).Decode(&jobs); err != nil {
+		return nil, newDecodeError("jobs", err)
+	}
+	return jobs, nil
+}
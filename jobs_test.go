@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobMetrics(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/jobs": `[
+			{"jobId": 2, "name": "save", "status": "RUNNING", "numTasks": 20, "numActiveTasks": 4, "numCompletedTasks": 15, "numFailedTasks": 1},
+			{"jobId": 1, "name": "count", "status": "SUCCEEDED", "numTasks": 8, "numCompletedTasks": 8},
+			{"jobId": 0, "name": "load", "status": "FAILED", "numTasks": 3, "numFailedTasks": 3}]`,
+	}))
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"jobs"}})), []series{
+		{"spark_job_tasks", map[string]string{"app_id": "app-1", "job_id": "2"}, 20},
+		{"spark_job_active_tasks", map[string]string{"app_id": "app-1", "job_id": "2"}, 4},
+		{"spark_job_completed_tasks", map[string]string{"app_id": "app-1", "job_id": "2"}, 15},
+		{"spark_job_failed_tasks", map[string]string{"app_id": "app-1", "job_id": "2"}, 1},
+		{"spark_job_status", map[string]string{"app_id": "app-1", "job_id": "2", "status": "RUNNING"}, 1},
+		{"spark_job_status", map[string]string{"app_id": "app-1", "job_id": "2", "status": "SUCCEEDED"}, 0},
+		{"spark_job_completed_tasks", map[string]string{"app_id": "app-1", "job_id": "1"}, 8},
+		{"spark_job_status", map[string]string{"app_id": "app-1", "job_id": "1", "status": "SUCCEEDED"}, 1},
+		{"spark_job_status", map[string]string{"app_id": "app-1", "job_id": "1", "status": "RUNNING"}, 0},
+		{"spark_job_failed_tasks", map[string]string{"app_id": "app-1", "job_id": "0"}, 3},
+		{"spark_job_status", map[string]string{"app_id": "app-1", "job_id": "0", "status": "FAILED"}, 1},
+	})
+}
+
+func TestJobDurations(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/jobs": `[
+			{"jobId": 1, "status": "RUNNING", "submissionTime": "2021-01-01T00:10:00.000GMT"},
+			{"jobId": 0, "status": "SUCCEEDED", "submissionTime": "2021-01-01T00:00:00.000GMT", "completionTime": "2021-01-01T00:01:30.500GMT"}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"jobs"}}))
+	finished := map[string]string{"app_id": "app-1", "job_id": "0"}
+	checkSeries(t, families, []series{
+		{"spark_job_submission_time_seconds", finished, 1609459200},
+		{"spark_job_completion_time_seconds", finished, 1609459290.5},
+		{"spark_job_duration_seconds", finished, 90.5},
+		{"spark_job_submission_time_seconds", map[string]string{"app_id": "app-1", "job_id": "1"}, 1609459800},
+	})
+
+	// The running job has no completion time, and lasts until now.
+	running := map[string]string{"app_id": "app-1", "job_id": "1"}
+	if _, ok := metricValue(families, "spark_job_completion_time_seconds", running); ok {
+		t.Error("spark_job_completion_time_seconds exported for a running job")
+	}
+	want := time.Since(time.Date(2021, 1, 1, 0, 10, 0, 0, time.UTC)).Seconds()
+	if got, ok := metricValue(families, "spark_job_duration_seconds", running); !ok || got > want || got < want-60 {
+		t.Errorf("got spark_job_duration_seconds %v for the running job, want about %v", got, want)
+	}
+}
+
+func TestJobStages(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/jobs": `[
+			{"jobId": 3, "status": "SUCCEEDED", "numTasks": 8, "numActiveStages": 0, "numCompletedStages": 2, "numSkippedStages": 3, "numFailedStages": 0},
+			{"jobId": 4, "status": "RUNNING", "numTasks": 8, "numActiveStages": 1, "numCompletedStages": 1, "numSkippedStages": 0, "numFailedStages": 2}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"jobs"}}))
+	skipped := map[string]string{"app_id": "app-1", "job_id": "3"}
+	failed := map[string]string{"app_id": "app-1", "job_id": "4"}
+	checkSeries(t, families, []series{
+		// The skipped stages are not counted as failed.
+		{"spark_job_skipped_stages", skipped, 3},
+		{"spark_job_failed_stages", skipped, 0},
+		{"spark_job_completed_stages", skipped, 2},
+		{"spark_job_active_stages", skipped, 0},
+		{"spark_job_skipped_stages", failed, 0},
+		{"spark_job_failed_stages", failed, 2},
+		{"spark_job_completed_stages", failed, 1},
+		{"spark_job_active_stages", failed, 1},
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// serviceAccountDir holds the credentials of the pod service account. It is
+// only changed by tests.
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// driverUIPort is the port of the Spark UI of the discovered drivers.
+const driverUIPort = "4040"
+
+// errNotInCluster is returned when the exporter doesn't run in a Kubernetes
+// pod.
+var errNotInCluster = errors.New("not running in a Kubernetes cluster")
+
+// PodList holds the pods listed by the Kubernetes API.
+type PodList struct {
+	Items []Pod `json:"items"`
+}
+
+// Pod holds the fields of a Kubernetes pod needed to scrape its Spark UI.
+type Pod struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Status struct {
+		Phase string `json:"phase"`
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+// newKubernetesDiscoverer returns a discoverer listing the UIs of the
+// running Spark driver pods of namespace matching labelSelector, using the
+// service account of the exporter pod. The namespace of the exporter pod is
+// used when namespace is empty.
+func newKubernetesDiscoverer(namespace, labelSelector string, fetchOpts FetchOptions) (*discoverer, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errNotInCluster
+	}
+	if namespace == "" {
+		b, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("can't read pod namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+
+	uri := "https://" + net.JoinHostPort(host, port)
+	apiOpts := FetchOptions{
+		Timeout:         fetchOpts.Timeout,
+		BearerTokenFile: serviceAccountDir + "/token",
+		CAFile:          serviceAccountDir + "/ca.crt",
+		UserAgent:       fetchOpts.UserAgent,
+	}
+	client, err := newHTTPClient(apiOpts)
+	if err != nil {
+		return nil, err
+	}
+	fetch := fetchHTTP(uri, apiOpts, client)
+	params := url.Values{}
+	params.Set("labelSelector", labelSelector)
+	params.Set("fieldSelector", "status.phase=Running")
+	path := "api/v1/namespaces/" + url.PathEscape(namespace) + "/pods?" + params.Encode()
+
+	return &discoverer{
+		endpoint: uri,
+		client:   client,
+		discover: func(ctx context.Context) ([]string, error) {
+			body, err := fetch(ctx, path)
+			if err != nil {
+				return nil, err
+			}
+			defer body.Close()
+
+			pods, err := parsePodList(body)
+			if err != nil {
+				return nil, err
+			}
+			var uris []string
+			for _, pod := range pods.Items {
+				if pod.Status.Phase == "Running" && pod.Status.PodIP != "" {
+					uris = append(uris, "http://"+net.JoinHostPort(pod.Status.PodIP, driverUIPort))
+				}
+			}
+			return uris, nil
+		},
+	}, nil
+}
+
+func parsePodList(r io.Reader) (PodList, error) {
+	var pods PodList
+	if err := json.NewDecoder(r).Decode(&pods); err != nil {
+		return pods, newDecodeError("pods", err)
+	}
+	return pods, nil
+}
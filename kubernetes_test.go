@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newKubernetesAPI starts a fake Kubernetes API server listing pods, and
+// sets up the environment and service account of a pod reaching it.
+func newKubernetesAPI(t *testing.T, pods string) *httptest.Server {
+	t.Helper()
+	api := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer service-account-token" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v1/namespaces/spark/pods" || q.Get("labelSelector") != "spark-role=driver" || q.Get("fieldSelector") != "status.phase=Running" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(pods))
+	}))
+	t.Cleanup(api.Close)
+
+	host, port, err := net.SplitHostPort(api.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBERNETES_SERVICE_HOST", host)
+	t.Setenv("KUBERNETES_SERVICE_PORT", port)
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"ca.crt":    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: api.Certificate().Raw})),
+		"token":     "service-account-token\n",
+		"namespace": "spark\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := serviceAccountDir
+	serviceAccountDir = dir
+	t.Cleanup(func() { serviceAccountDir = saved })
+	return api
+}
+
+func TestKubernetesDiscovery(t *testing.T) {
+	api := newKubernetesAPI(t, `{"kind": "PodList", "items": [
+		{"metadata": {"name": "etl-driver", "namespace": "spark"}, "status": {"phase": "Running", "podIP": "10.1.2.3"}},
+		{"metadata": {"name": "report-driver", "namespace": "spark"}, "status": {"phase": "Running", "podIP": "fd00::4"}},
+		{"metadata": {"name": "new-driver", "namespace": "spark"}, "status": {"phase": "Running"}}]}`)
+
+	// The namespace of the exporter pod is used.
+	d, err := newKubernetesDiscoverer("", "spark-role=driver", FetchOptions{UserAgent: "spark_exporter"})
+	if err != nil {
+		t.Fatalf("newKubernetesDiscoverer: %v", err)
+	}
+	defer closeHTTPClient(d.client)
+	if d.endpoint != api.URL {
+		t.Errorf("got endpoint %s, want %s", d.endpoint, api.URL)
+	}
+	uris, err := d.discover(context.Background())
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	// The pod without an IP yet is skipped.
+	if want := []string{"http://10.1.2.3:4040", "http://[fd00::4]:4040"}; !reflect.DeepEqual(uris, want) {
+		t.Errorf("got URIs %q, want %q", uris, want)
+	}
+}
+
+func TestKubernetesNotInCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+	if _, err := newKubernetesDiscoverer("spark", "spark-role=driver", FetchOptions{}); err != errNotInCluster {
+		t.Fatalf("got error %v, want %v", err, errNotInCluster)
+	}
+	// The exporter still scrapes its other targets.
+	s := newSparkServer(t, appRoutes(nil))
+	e := newTestExporter(t, []string{s.URL}, Options{K8sMode: true, K8sLabelSelector: "spark-role=driver"})
+	if len(e.discoverers) != 0 {
+		t.Errorf("got %d discoverers, want none out of a cluster", len(e.discoverers))
+	}
+	checkSeries(t, gather(t, e), []series{
+		{"spark_up", map[string]string{"endpoint": s.URL}, 1},
+	})
+}
+
+func TestKubernetesDown(t *testing.T) {
+	api := newKubernetesAPI(t, `not json`)
+	e := newTestExporter(t, nil, Options{K8sMode: true, K8sNamespace: "spark", K8sLabelSelector: "spark-role=driver"})
+	defer e.close()
+	checkSeries(t, gather(t, e), []series{
+		{"spark_up", map[string]string{"endpoint": api.URL}, 0},
+	})
+}
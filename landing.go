@@ -0,0 +1,90 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<html>
+<head><title>Spark Exporter</title></head>
+<body>
+<h1>Spark Exporter</h1>
+<p><a href="{{.MetricsPath}}">Metrics</a></p>
+<p>Probe a Spark application at <code>/probe?target=http://driver:4040</code></p>
+<p><a href="/-/healthy">Health</a> and <a href="/-/ready">readiness</a></p>
+<h2>Applications</h2>
+{{if .LastScrape.IsZero}}<p>Not scraped yet.</p>{{else}}<p>Last scraped at {{.LastScrape.Format "2006-01-02 15:04:05 MST"}}.</p>
+<table border="1" cellpadding="4">
+<tr><th>Endpoint</th><th>Id</th><th>Name</th><th>Up</th></tr>
+{{range .Applications}}<tr><td>{{.Endpoint}}</td><td>{{.ID}}</td><td>{{.Name}}</td><td>{{if .Up}}yes{{else}}no{{end}}</td></tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+// applicationStatus is a row of the landing page: an application found by
+// the last scrape, or a target that could not be scraped.
+type applicationStatus struct {
+	Endpoint string
+	ID       string
+	Name     string
+	Up       bool
+}
+
+// scrapeStatus is the status of the last scrape shown by the landing page.
+// It is kept apart from the metrics of the Exporter, so that the page doesn't
+// wait for the scrape in progress.
+type scrapeStatus struct {
+	mutex        sync.RWMutex
+	applications []applicationStatus
+	lastScrape   time.Time
+}
+
+// recordStatus records the applications found by the last scrape followed
+// by the targets whose scrape failed. e.mutex must be held.
+func (e *Exporter) recordStatus() {
+	var apps []applicationStatus
+	for _, app := range e.applications {
+		apps = append(apps, applicationStatus{Endpoint: app.Endpoint, ID: app.ID, Name: app.Name, Up: true})
+	}
+	for _, endpoint := range e.failedTargets {
+		apps = append(apps, applicationStatus{Endpoint: endpoint})
+	}
+	e.lastStatus.mutex.Lock()
+	defer e.lastStatus.mutex.Unlock()
+	e.lastStatus.applications = apps
+	e.lastStatus.lastScrape = e.lastScrape
+}
+
+// status returns the applications recorded by recordStatus and the time of
+// their scrape.
+func (e *Exporter) status() ([]applicationStatus, time.Time) {
+	e.lastStatus.mutex.RLock()
+	defer e.lastStatus.mutex.RUnlock()
+	return e.lastStatus.applications, e.lastStatus.lastScrape
+}
+
+// landingHandler returns the handler of the landing page, listing the
+// applications of the last scrape of the active exporter.
+func landingHandler(metricsPath string, exporter func() *Exporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		apps, lastScrape := exporter().status()
+		data := struct {
+			MetricsPath  string
+			Applications []applicationStatus
+			LastScrape   time.Time
+		}{metricsPath, apps, lastScrape}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := landingTemplate.Execute(w, data); err != nil {
+			log.Errorf("Can't render landing page: %v", err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// landingPage renders the landing page of e.
+func landingPage(e *Exporter) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	landingHandler("/metrics", func() *Exporter { return e }).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	return rec
+}
+
+func TestLandingPage(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications": `[
+			{"id": "app-1", "name": "etl", "attempts": []},
+			{"id": "app-2", "name": "<script>alert('report')</script>", "attempts": []}]`,
+		"applications/app-2/executors":   `[]`,
+		"applications/app-2/jobs":        `[]`,
+		"applications/app-2/stages":      `[]`,
+		"applications/app-2/storage/rdd": `[]`,
+		"applications/app-2/environment": `{}`,
+	}))
+	e := newTestExporter(t, []string{s.URL}, Options{})
+
+	tests := []struct {
+		name      string
+		scrape    bool
+		want      []string
+		wantNotIn []string
+	}{
+		{
+			name: "not scraped",
+			want: []string{`<a href="/metrics">`, "Not scraped yet."},
+		},
+		{
+			name:      "scraped",
+			scrape:    true,
+			want:      []string{"<td>app-1</td><td>etl</td><td>yes</td>", "<td>app-2</td><td>&lt;script&gt;alert(&#39;report&#39;)&lt;/script&gt;</td>"},
+			wantNotIn: []string{"<script>", "Not scraped yet."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.scrape {
+				gather(t, e)
+			}
+			rec := landingPage(e)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200", rec.Code)
+			}
+			body := rec.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("the page doesn't contain %q:\n%s", want, body)
+				}
+			}
+			for _, unwanted := range tt.wantNotIn {
+				if strings.Contains(body, unwanted) {
+					t.Errorf("the page contains %q:\n%s", unwanted, body)
+				}
+			}
+		})
+	}
+}
+
+func TestLandingPageFailedTarget(t *testing.T) {
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+	e := newTestExporter(t, []string{failing.URL}, Options{})
+	gather(t, e)
+	if body := landingPage(e).Body.String(); !strings.Contains(body, "<td>"+failing.URL+"</td><td></td><td></td><td>no</td>") {
+		t.Errorf("the page doesn't list the failed target as down:\n%s", body)
+	}
+}
+
+func TestLandingPageDuringScrape(t *testing.T) {
+	requested, release := make(chan struct{}, 1), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	defer close(release)
+	e := newTestExporter(t, []string{server.URL}, Options{})
+	go e.Collect(make(chan prometheus.Metric, 1000))
+	<-requested
+
+	done := make(chan int, 1)
+	go func() { done <- landingPage(e).Code }()
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Errorf("got status %d, want 200", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("the landing page waited for the scrape in progress")
+	}
+}
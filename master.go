@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	masterLabelNames            = []string{"endpoint"}
+	masterWorkerLabelNames      = []string{"endpoint", "state"}
+	masterApplicationLabelNames = []string{"endpoint", "app_id", "app_name"}
+	masterAppStateLabelNames    = []string{"endpoint", "app_id", "app_name", "state"}
+	masterAppStates             = []string{"WAITING", "RUNNING", "FINISHED", "FAILED", "KILLED", "UNKNOWN"}
+	nodeLabelNames              = []string{"endpoint", "node"}
+)
+
+// MasterState holds the state of a Standalone Master, as returned by its
+// /json/ endpoint. Memory sizes are in MiB.
+type MasterState struct {
+	URL           string              `json:"url"`
+	Status        string              `json:"status"`
+	Workers       []MasterWorker      `json:"workers"`
+	AliveWorkers  int                 `json:"aliveworkers"`
+	Cores         int                 `json:"cores"`
+	CoresUsed     int                 `json:"coresused"`
+	Memory        int64               `json:"memory"`
+	MemoryUsed    int64               `json:"memoryused"`
+	ActiveApps    []MasterApplication `json:"activeapps"`
+	CompletedApps []MasterApplication `json:"completedapps"`
+}
+
+// MasterWorker holds the state of a worker registered to a Standalone
+// Master.
+type MasterWorker struct {
+	ID         string `json:"id"`
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	Cores      int    `json:"cores"`
+	CoresUsed  int    `json:"coresused"`
+	Memory     int64  `json:"memory"`
+	MemoryUsed int64  `json:"memoryused"`
+	State      string `json:"state"`
+}
+
+// MasterApplication holds the state of an application submitted to a
+// Standalone Master.
+type MasterApplication struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	User           string `json:"user"`
+	Cores          int    `json:"cores"`
+	MemoryPerSlave int64  `json:"memoryperslave"`
+	State          string `json:"state"`
+	Duration       int64  `json:"duration"`
+}
+
+func newMasterMetrics(namespace string, metricName string, docString string, labelNames []string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "master",
+			Name:        metricName,
+			Help:        docString,
+			ConstLabels: constLabels,
+		},
+		labelNames,
+	)
+}
+
+func newMasterGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"workers":            newMasterMetrics(namespace, "workers", "Number of workers registered to the master by state", masterWorkerLabelNames, constLabels),
+		"cores_total":        newMasterMetrics(namespace, "cores_total", "Number of cores of the alive workers", masterLabelNames, constLabels),
+		"cores_used":         newMasterMetrics(namespace, "cores_used", "Number of cores of the alive workers used by applications", masterLabelNames, constLabels),
+		"memory_total_bytes": newMasterMetrics(namespace, "memory_total_bytes", "Memory of the alive workers in bytes", masterLabelNames, constLabels),
+		"memory_used_bytes":  newMasterMetrics(namespace, "memory_used_bytes", "Memory of the alive workers used by applications in bytes", masterLabelNames, constLabels),
+		"application_cores":  newMasterMetrics(namespace, "application_cores", "Number of cores granted to the active application", masterApplicationLabelNames, constLabels),
+		"application_state":  newMasterMetrics(namespace, "application_state", "State of the active application, 1 for the current state", masterAppStateLabelNames, constLabels),
+		"excluded_nodes":     newMasterMetrics(namespace, "excluded_nodes", "Number of worker nodes the master excludes from scheduling executors", masterLabelNames, constLabels),
+		"node_excluded": prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   namespace,
+				Subsystem:   "node",
+				Name:        "excluded",
+				Help:        "Whether the master excludes the worker node from scheduling executors",
+				ConstLabels: constLabels,
+			},
+			nodeLabelNames,
+		),
+	}
+}
+
+func (e *Exporter) scrapeMaster(ctx context.Context, t *target) error {
+	body, err := t.fetch(ctx, "json/")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	state, err := parseMasterState(body)
+	if err != nil {
+		return err
+	}
+	e.setMasterMetrics(t.endpoint, state)
+	return nil
+}
+
+func (e *Exporter) setMasterMetrics(endpoint string, state MasterState) {
+	workers := map[string]int{}
+	for _, w := range state.Workers {
+		workers[w.State]++
+	}
+	for s, n := range workers {
+		e.masterGaugeMetrics["workers"].WithLabelValues(endpoint, s).Set(float64(n))
+	}
+	e.masterGaugeMetrics["cores_total"].WithLabelValues(endpoint).Set(float64(state.Cores))
+	e.masterGaugeMetrics["cores_used"].WithLabelValues(endpoint).Set(float64(state.CoresUsed))
+	e.masterGaugeMetrics["memory_total_bytes"].WithLabelValues(endpoint).Set(float64(state.Memory) * 1024 * 1024)
+	e.masterGaugeMetrics["memory_used_bytes"].WithLabelValues(endpoint).Set(float64(state.MemoryUsed) * 1024 * 1024)
+	excluded := 0
+	for node, ok := range excludedNodes(state.Workers) {
+		v := 0.0
+		if ok {
+			v = 1
+			excluded++
+		}
+		e.masterGaugeMetrics["node_excluded"].WithLabelValues(endpoint, node).Set(v)
+	}
+	e.masterGaugeMetrics["excluded_nodes"].WithLabelValues(endpoint).Set(float64(excluded))
+
+	for _, app := range state.ActiveApps {
+		e.masterGaugeMetrics["application_cores"].WithLabelValues(endpoint, app.ID, app.Name).Set(float64(app.Cores))
+		for _, s := range masterAppStates {
+			v := 0.0
+			if app.State == s {
+				v = 1
+			}
+			e.masterGaugeMetrics["application_state"].WithLabelValues(endpoint, app.ID, app.Name, s).Set(v)
+		}
+	}
+}
+
+// excludedNodes returns the hosts of the workers with whether the master
+// excludes them from scheduling executors: a node is excluded once all its
+// workers are decommissioned. Dead workers are left out, as they are no
+// longer part of the cluster, and are counted by spark_master_workers.
+func excludedNodes(workers []MasterWorker) map[string]bool {
+	nodes := map[string]bool{}
+	for _, w := range workers {
+		if w.State == "DEAD" || w.Host == "" {
+			continue
+		}
+		excluded, seen := nodes[w.Host]
+		nodes[w.Host] = (excluded || !seen) && w.State == "DECOMMISSIONED"
+	}
+	return nodes
+}
+
+func parseMasterState(r io.Reader) (MasterState, error) {
+	var state MasterState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return state, newDecodeError("master state", err)
+	}
+	return state, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// masterStateSample is a response of the /json/ endpoint of a Spark 3.1
+// Standalone Master.
+const masterStateSample = `{
+  "url" : "spark://master:7077",
+  "workers" : [ {
+    "id" : "worker-20210105100000-10.0.0.1-35000",
+    "host" : "10.0.0.1",
+    "port" : 35000,
+    "webuiaddress" : "http://10.0.0.1:8081",
+    "cores" : 8,
+    "coresused" : 6,
+    "coresfree" : 2,
+    "memory" : 15360,
+    "memoryused" : 12288,
+    "memoryfree" : 3072,
+    "state" : "ALIVE",
+    "lastheartbeat" : 1609841410123
+  }, {
+    "id" : "worker-20210105100000-10.0.0.2-35000",
+    "host" : "10.0.0.2",
+    "port" : 35000,
+    "webuiaddress" : "http://10.0.0.2:8081",
+    "cores" : 8,
+    "coresused" : 2,
+    "coresfree" : 6,
+    "memory" : 15360,
+    "memoryused" : 4096,
+    "memoryfree" : 11264,
+    "state" : "ALIVE",
+    "lastheartbeat" : 1609841410123
+  }, {
+    "id" : "worker-20210104100000-10.0.0.3-35000",
+    "host" : "10.0.0.3",
+    "port" : 35000,
+    "webuiaddress" : "http://10.0.0.3:8081",
+    "cores" : 8,
+    "coresused" : 0,
+    "coresfree" : 8,
+    "memory" : 15360,
+    "memoryused" : 0,
+    "memoryfree" : 15360,
+    "state" : "DEAD",
+    "lastheartbeat" : 1609755010123
+  } ],
+  "aliveworkers" : 2,
+  "cores" : 16,
+  "coresused" : 8,
+  "memory" : 30720,
+  "memoryused" : 16384,
+  "resources" : [ ],
+  "resourcesused" : [ ],
+  "activeapps" : [ {
+    "id" : "app-20210105101010-0001",
+    "starttime" : 1609841410123,
+    "name" : "etl",
+    "cores" : 8,
+    "user" : "spark",
+    "memoryperexecutor" : 4096,
+    "memoryperslave" : 4096,
+    "resourcesperexecutor" : [ ],
+    "resourcesperslave" : [ ],
+    "submitdate" : "Tue Jan 05 10:10:10 UTC 2021",
+    "state" : "RUNNING",
+    "duration" : 600000
+  }, {
+    "id" : "app-20210105102000-0002",
+    "starttime" : 1609842000000,
+    "name" : "report",
+    "cores" : 0,
+    "user" : "analyst",
+    "memoryperexecutor" : 2048,
+    "memoryperslave" : 2048,
+    "resourcesperexecutor" : [ ],
+    "resourcesperslave" : [ ],
+    "submitdate" : "Tue Jan 05 10:20:00 UTC 2021",
+    "state" : "WAITING",
+    "duration" : 10000
+  } ],
+  "completedapps" : [ {
+    "id" : "app-20210105090000-0000",
+    "starttime" : 1609837200000,
+    "name" : "load",
+    "cores" : 4,
+    "user" : "spark",
+    "memoryperexecutor" : 1024,
+    "memoryperslave" : 1024,
+    "resourcesperexecutor" : [ ],
+    "resourcesperslave" : [ ],
+    "submitdate" : "Tue Jan 05 09:00:00 UTC 2021",
+    "state" : "FINISHED",
+    "duration" : 1800000
+  } ],
+  "activedrivers" : [ ],
+  "completeddrivers" : [ ],
+  "status" : "ALIVE"
+}`
+
+func TestMasterMetrics(t *testing.T) {
+	master := newSparkServer(t, map[string]string{"/json/": masterStateSample})
+	families := gather(t, newTestExporter(t, nil, Options{MasterURI: master.URL}))
+	endpoint := map[string]string{"endpoint": master.URL}
+	checkSeries(t, families, []series{
+		{"spark_up", endpoint, 1},
+		{"spark_master_workers", map[string]string{"state": "ALIVE"}, 2},
+		{"spark_master_workers", map[string]string{"state": "DEAD"}, 1},
+		{"spark_master_cores_total", endpoint, 16},
+		{"spark_master_cores_used", endpoint, 8},
+		{"spark_master_memory_total_bytes", endpoint, 30720 * 1024 * 1024},
+		{"spark_master_memory_used_bytes", endpoint, 16384 * 1024 * 1024},
+		{"spark_master_application_cores", map[string]string{"app_id": "app-20210105101010-0001", "app_name": "etl"}, 8},
+		{"spark_master_application_state", map[string]string{"app_id": "app-20210105101010-0001", "state": "RUNNING"}, 1},
+		{"spark_master_application_state", map[string]string{"app_id": "app-20210105101010-0001", "state": "WAITING"}, 0},
+		{"spark_master_application_state", map[string]string{"app_id": "app-20210105102000-0002", "state": "WAITING"}, 1},
+		{"spark_master_application_state", map[string]string{"app_id": "app-20210105102000-0002", "state": "RUNNING"}, 0},
+	})
+	// Completed applications are not exported.
+	if _, ok := metricValue(families, "spark_master_application_cores", map[string]string{"app_id": "app-20210105090000-0000"}); ok {
+		t.Error("spark_master_application_cores exported for a completed application")
+	}
+}
+
+func TestMasterExcludedNodes(t *testing.T) {
+	// The second worker is being decommissioned, the third one is dead.
+	sample := strings.Replace(masterStateSample, `"memoryfree" : 11264,
+    "state" : "ALIVE"`, `"memoryfree" : 11264,
+    "state" : "DECOMMISSIONED"`, 1)
+	master := newSparkServer(t, map[string]string{"/json/": sample})
+	families := gather(t, newTestExporter(t, nil, Options{MasterURI: master.URL}))
+	checkSeries(t, families, []series{
+		{"spark_master_excluded_nodes", map[string]string{"endpoint": master.URL}, 1},
+		{"spark_node_excluded", map[string]string{"node": "10.0.0.1"}, 0},
+		{"spark_node_excluded", map[string]string{"node": "10.0.0.2"}, 1},
+	})
+	if _, ok := metricValue(families, "spark_node_excluded", map[string]string{"node": "10.0.0.3"}); ok {
+		t.Error("spark_node_excluded exported for the node of a dead worker")
+	}
+
+	families = gather(t, newTestExporter(t, nil, Options{MasterURI: newSparkServer(t, map[string]string{"/json/": masterStateSample}).URL}))
+	if v, ok := metricValue(families, "spark_master_excluded_nodes", nil); !ok || v != 0 {
+		t.Errorf("got spark_master_excluded_nodes %v without decommissioned workers, want 0", v)
+	}
+}
+
+func TestExcludedNodes(t *testing.T) {
+	// A node is only excluded once all its workers are.
+	nodes := excludedNodes([]MasterWorker{
+		{Host: "node-1", State: "DECOMMISSIONED"},
+		{Host: "node-1", State: "ALIVE"},
+		{Host: "node-2", State: "DECOMMISSIONED"},
+		{Host: "node-2", State: "DECOMMISSIONED"},
+		{Host: "node-3", State: "DEAD"},
+	})
+	want := map[string]bool{"node-1": false, "node-2": true}
+	if len(nodes) != len(want) {
+		t.Fatalf("got nodes %v, want %v", nodes, want)
+	}
+	for node, excluded := range want {
+		if nodes[node] != excluded {
+			t.Errorf("got node %s excluded %v, want %v", node, nodes[node], excluded)
+		}
+	}
+}
+
+func TestMasterDown(t *testing.T) {
+	master := newSparkServer(t, nil)
+	app := newSparkServer(t, appRoutes(nil))
+	families := gather(t, newTestExporter(t, []string{app.URL}, Options{MasterURI: master.URL}))
+	checkSeries(t, families, []series{
+		{"spark_up", map[string]string{"endpoint": master.URL}, 0},
+		{"spark_up", map[string]string{"endpoint": app.URL}, 1},
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const poolLabelName = "pool"
+
+var (
+	stagePoolLabelNames = append(append([]string{}, stageLabelNames...), poolLabelName)
+	poolLabelNames      = []string{"endpoint", "app_id", poolLabelName}
+)
+
+func newPoolGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"stage_info": prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   namespace,
+				Name:        "stage_scheduling_pool_info",
+				Help:        "Scheduling pool of the stage, with a constant value of 1",
+				ConstLabels: constLabels,
+			},
+			stagePoolLabelNames,
+		),
+		"running_tasks": prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   namespace,
+				Subsystem:   "pool",
+				Name:        "running_tasks",
+				Help:        "Current number of running tasks of the active stages in the scheduling pool",
+				ConstLabels: constLabels,
+			},
+			poolLabelNames,
+		),
+	}
+}
+
+// poolRunningTasks returns the pools of the stages of an application with
+// the number of tasks running in them. The pools of finished stages are
+// kept at zero, so that a pool going idle is still reported.
+func poolRunningTasks(stages []StageInfo) map[string]int {
+	pools := map[string]int{}
+	for _, stage := range stages {
+		if stage.SchedulingPool == "" {
+			continue
+		}
+		tasks := 0
+		if stage.Status == "ACTIVE" {
+			tasks = stage.NumActiveTasks
+		}
+		pools[stage.SchedulingPool] += tasks
+	}
+	return pools
+}
+
+func (e *Exporter) setPoolMetrics(key appKey, stages []StageInfo) {
+	for pool, tasks := range poolRunningTasks(stages) {
+		e.poolGaugeMetrics["running_tasks"].WithLabelValues(append(key.labelValues(), pool)...).Set(float64(tasks))
+	}
+}
+
+func (e *Exporter) setStagePoolMetrics(key appKey, stage StageInfo) {
+	if stage.SchedulingPool == "" {
+		return
+	}
+	e.poolGaugeMetrics["stage_info"].WithLabelValues(append(stageLabelValues(key, stage), stage.SchedulingPool)...).Set(1)
+}
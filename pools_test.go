@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPoolMetrics(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/stages": `[
+			{"stageId": 3, "attemptId": 0, "status": "ACTIVE", "numActiveTasks": 4, "schedulingPool": "etl"},
+			{"stageId": 4, "attemptId": 0, "status": "ACTIVE", "numActiveTasks": 2, "schedulingPool": "etl"},
+			{"stageId": 5, "attemptId": 0, "status": "ACTIVE", "numActiveTasks": 1, "schedulingPool": "adhoc"},
+			{"stageId": 2, "attemptId": 0, "status": "COMPLETE", "schedulingPool": "reports"}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"stages"}}))
+	checkSeries(t, families, []series{
+		{"spark_stage_scheduling_pool_info", map[string]string{"stage_id": "3", "pool": "etl"}, 1},
+		{"spark_stage_scheduling_pool_info", map[string]string{"stage_id": "5", "pool": "adhoc"}, 1},
+		{"spark_pool_running_tasks", map[string]string{"app_id": "app-1", "pool": "etl"}, 6},
+		{"spark_pool_running_tasks", map[string]string{"app_id": "app-1", "pool": "adhoc"}, 1},
+		{"spark_pool_running_tasks", map[string]string{"app_id": "app-1", "pool": "reports"}, 0},
+	})
+	// The info of finished stages follows the other stage metrics.
+	if _, ok := metricValue(families, "spark_stage_scheduling_pool_info", map[string]string{"stage_id": "2"}); ok {
+		t.Error("got the scheduling pool of the completed stage, want it missing")
+	}
+}
+
+func TestPoolRunningTasks(t *testing.T) {
+	tests := []struct {
+		name   string
+		stages []StageInfo
+		want   map[string]int
+	}{
+		{
+			name: "active and finished stages",
+			stages: []StageInfo{
+				{Status: "ACTIVE", NumActiveTasks: 3, SchedulingPool: "etl"},
+				{Status: "COMPLETE", NumActiveTasks: 5, SchedulingPool: "etl"},
+				{Status: "FAILED", NumActiveTasks: 1, SchedulingPool: "adhoc"},
+			},
+			want: map[string]int{"etl": 3, "adhoc": 0},
+		},
+		{
+			name: "pending stage",
+			stages: []StageInfo{
+				{Status: "PENDING", SchedulingPool: "etl"},
+			},
+			want: map[string]int{"etl": 0},
+		},
+		{
+			name: "missing pool",
+			stages: []StageInfo{
+				{Status: "ACTIVE", NumActiveTasks: 2},
+			},
+			want: map[string]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := poolRunningTasks(tt.stages); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
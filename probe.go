@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeOptions are what the exporters built for probes share with the active
+// configuration: its settings, its HTTP client, so that probes reuse its
+// connections, and the histogram of the scrape durations.
+type probeOptions struct {
+	fetchOpts       FetchOptions
+	opts            Options
+	client          httpDoer
+	scrapeDurations prometheus.Observer
+}
+
+// probeHandler returns a handler scraping the Spark URI given in the target
+// query parameter and serving the metrics of that target only. The options
+// of the probe are taken from options on every request.
+func probeHandler(options func() probeOptions, timeoutOffset time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "'target' parameter must be specified", http.StatusBadRequest)
+			return
+		}
+		probe := options()
+		opts := probe.opts
+		u, err := parseSparkURI(opts.withScheme(target))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid 'target' parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+			http.Error(w, "invalid 'target' parameter: must not contain credentials, a query or a fragment", http.StatusBadRequest)
+			return
+		}
+
+		// Only the probed target is scraped.
+		opts.MasterURI = ""
+		opts.YarnRMURI = ""
+		opts.YarnATSURI = ""
+		// Probes are scraped for each request.
+		opts.ScrapeInterval = 0
+		opts.K8sMode = false
+		// Nothing is kept across probes to tell which stages completed.
+		opts.TaskDurationHistogram = false
+		exporter, err := newExporterWithClient([]string{u.String()}, probe.fetchOpts, opts, probe.client)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		exporter.scrapeDurations = probe.scrapeDurations
+		ctx, cancel := scrapeContext(r, timeoutOffset)
+		defer cancel()
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(exporter.WithContext(ctx))
+		promhttp.HandlerFor(exporter.gatherer(registry), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// reloader holds the exporter built from the active configuration and
+// replaces it when the configuration file is reloaded.
+type reloader struct {
+	configFile string
+	// args are the command line arguments, whose flags override the
+	// configuration file.
+	args []string
+	// readiness is shared by the exporters of successive configurations.
+	readiness *readiness
+	// reloaded receives a value when the exporter is replaced.
+	reloaded chan struct{}
+
+	mutex     sync.RWMutex
+	exporter  *Exporter
+	fetchOpts FetchOptions
+	opts      Options
+	// client is shared by the exporters of successive configurations as
+	// long as their fetch options are the same, and by the probes.
+	client httpDoer
+	// metrics are the metrics of the exporter process, built again when
+	// the namespace changes.
+	metrics *exporterMetrics
+}
+
+// newReloader returns a reloader using the configuration cfg until the
+// first reload.
+func newReloader(cfg *Config, configFile string, args []string, readiness *readiness) (*reloader, error) {
+	r := &reloader{configFile: configFile, args: args, readiness: readiness, reloaded: make(chan struct{}, 1)}
+	if err := r.apply(cfg); err != nil {
+		return nil, err
+	}
+	r.metrics.configReloadSuccess.Set(1)
+	r.metrics.configReloadSeconds.SetToCurrentTime()
+	return r, nil
+}
+
+// reload reads the configuration file again. When it fails, the previous
+// configuration stays active.
+func (r *reloader) reload() error {
+	err := r.load()
+	metrics := r.exporterMetrics()
+	if err != nil {
+		metrics.configReloadSuccess.Set(0)
+		return err
+	}
+	metrics.configReloadSuccess.Set(1)
+	metrics.configReloadSeconds.SetToCurrentTime()
+	return nil
+}
+
+func (r *reloader) load() error {
+	if r.configFile == "" {
+		return fmt.Errorf("no configuration file to reload")
+	}
+	cfg, err := LoadConfig(r.configFile)
+	if err != nil {
+		return err
+	}
+	if err := overrideConfig(cfg, r.args); err != nil {
+		return err
+	}
+	return r.apply(cfg)
+}
+
+func (r *reloader) apply(cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	fetchOpts, err := cfg.fetchOptions()
+	if err != nil {
+		return err
+	}
+	opts := cfg.options()
+	client, err := r.httpClient(fetchOpts)
+	if err != nil {
+		return err
+	}
+	exporter, err := newExporterWithClient(cfg.Targets, fetchOpts, opts, client)
+	if err != nil {
+		if client != r.client {
+			closeHTTPClient(client)
+		}
+		return err
+	}
+	exporter.readiness = r.readiness
+	metrics := r.metrics
+	if metrics == nil || metrics.namespace != exporter.namespace {
+		metrics = newExporterMetrics(exporter.namespace)
+	}
+	exporter.scrapeDurations = metrics.scrapeDurations
+
+	r.mutex.Lock()
+	previous, previousClient := r.exporter, r.client
+	r.exporter = exporter
+	r.fetchOpts = fetchOpts
+	r.opts = opts
+	r.client = client
+	r.metrics = metrics
+	r.mutex.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+	if previousClient != nil && previousClient != client {
+		closeHTTPClient(previousClient)
+	}
+	select {
+	case r.reloaded <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// httpClient returns the client of the exporters built from fetchOpts: the
+// active one when fetchOpts didn't change, so that a reload neither drops
+// the open connections nor logs in to the KDC again, or a new one.
+func (r *reloader) httpClient(fetchOpts FetchOptions) (httpDoer, error) {
+	if r.client != nil && reflect.DeepEqual(fetchOpts, r.fetchOpts) {
+		return r.client, nil
+	}
+	if err := fetchOpts.validate(); err != nil {
+		return nil, err
+	}
+	return newHTTPClient(fetchOpts)
+}
+
+// Exporter returns the exporter of the active configuration.
+func (r *reloader) Exporter() *Exporter {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.exporter
+}
+
+// options returns what exporters built for probes share with the active
+// configuration.
+func (r *reloader) options() probeOptions {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return probeOptions{r.fetchOpts, r.opts, r.client, r.metrics.scrapeDurations}
+}
+
+// exporterMetrics returns the metrics of the exporter process.
+func (r *reloader) exporterMetrics() *exporterMetrics {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.metrics
+}
+
+// gatherer returns the gatherer of the metrics of the exporter process,
+// following the reloads. With disableRuntime, the Go runtime and process
+// metrics are left out.
+func (r *reloader) gatherer(disableRuntime bool) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return r.exporterMetrics().gatherer(disableRuntime).Gather()
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReload(t *testing.T) {
+	const (
+		valid   = "targets: [http://driver-1:4040]\n"
+		changed = "targets: [http://driver-2:4040]\n"
+	)
+	configFile := writeFile(t, "config.yml", valid)
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The flags of the command line still override the reloaded file.
+	r, err := newReloader(cfg, configFile, []string{"--spark.retries=2"}, nil)
+	if err != nil {
+		t.Fatalf("newReloader: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		content     string
+		wantErr     bool
+		wantTarget  string
+		wantSuccess float64
+		// keepClient is set when the fetch options are the same as the
+		// ones of the previous configuration.
+		keepClient bool
+	}{
+		{name: "successful reload", content: changed, wantTarget: "http://driver-2:4040", wantSuccess: 1},
+		{name: "unparsable file", content: "targets: [", wantErr: true, wantTarget: "http://driver-2:4040", keepClient: true},
+		{name: "invalid target", content: "targets: [ftp://driver-3:4040]\n", wantErr: true, wantTarget: "http://driver-2:4040", keepClient: true},
+		{name: "successful reload after failures", content: valid, wantTarget: "http://driver-1:4040", wantSuccess: 1, keepClient: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := r.client
+			before := testutil.ToFloat64(r.metrics.configReloadSeconds)
+			// Tell the timestamps of successive reloads apart.
+			time.Sleep(10 * time.Millisecond)
+			if err := ioutil.WriteFile(configFile, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			err := r.reload()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want one: %v", err, tt.wantErr)
+			}
+			if got := r.Exporter().targets[0].endpoint; got != tt.wantTarget {
+				t.Errorf("got target %s, want %s", got, tt.wantTarget)
+			}
+			if tt.keepClient && r.client != client {
+				t.Error("the reload replaced the HTTP client")
+			}
+			if probe := r.options(); probe.fetchOpts.Retries != 2 {
+				t.Errorf("got %d retries, want the 2 of the command line", probe.fetchOpts.Retries)
+			}
+			if got := testutil.ToFloat64(r.metrics.configReloadSuccess); got != tt.wantSuccess {
+				t.Errorf("got config_last_reload_success %v, want %v", got, tt.wantSuccess)
+			}
+			after := testutil.ToFloat64(r.metrics.configReloadSeconds)
+			if tt.wantErr && after != before {
+				t.Errorf("the failed reload changed the reload timestamp from %v to %v", before, after)
+			}
+			if !tt.wantErr && after <= before {
+				t.Errorf("the successful reload left the reload timestamp at %v, previously %v", after, before)
+			}
+		})
+	}
+}
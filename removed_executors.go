@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const removeReasonLabelName = "remove_reason"
+
+var removedExecutorLabelNames = append(append([]string{}, executorLabelNames...), removeReasonLabelName)
+
+func newRemovedExecutorGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"removed":             newGaugeExecutorMetrics(namespace, "removed", "Executor removed from the application, with a constant value of 1", removedExecutorLabelNames, constLabels),
+		"remove_time_seconds": newGaugeExecutorMetrics(namespace, "remove_time_seconds", "Time the executor was removed since unix epoch in seconds", executorLabelNames, constLabels),
+	}
+}
+
+// scrapeAllExecutors returns the active and removed executors of the
+// application, listed by a single request to allexecutors. Spark versions
+// without that endpoint are asked for the active executors only.
+func (t *target) scrapeAllExecutors(ctx context.Context, appID string) ([]ExecutorInfo, error) {
+	executors, err := t.scrapeExecutors(ctx, appID, "allexecutors")
+	if !isNotFound(err) {
+		return executors, err
+	}
+	executors, err = t.scrapeExecutors(ctx, appID, "executors")
+	// The executors endpoint only lists the active executors.
+	for i := range executors {
+		executors[i].IsActive = true
+	}
+	return executors, err
+}
+
+func (e *Exporter) setRemovedExecutorMetrics(key appKey, ex ExecutorInfo) {
+	labels := append(key.labelValues(), ex.ID)
+	e.removedExecutorGaugeMetrics["removed"].WithLabelValues(append(labels, ex.RemoveReason)...).Set(1)
+	if t, err := parseSparkTime(ex.RemoveTime); err == nil {
+		e.removedExecutorGaugeMetrics["remove_time_seconds"].WithLabelValues(labels...).Set(timeSeconds(t))
+	}
+}
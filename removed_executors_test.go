@@ -0,0 +1,135 @@
+package main
+
+import "testing"
+
+const allExecutorsFixture = `[
+	{"id": "1", "hostPort": "h1:1", "isActive": true, "addTime": "2023-01-02T15:04:07.000GMT", "totalTasks": 3},
+	{"id": "2", "hostPort": "h2:1", "isActive": false, "addTime": "2023-01-02T15:04:07.000GMT", "removeTime": "2023-01-02T16:04:07.000GMT", "removeReason": "Executor killed", "totalTasks": 5},
+	{"id": "3", "hostPort": "h3:1", "isActive": false, "addTime": "2023-01-02T15:04:07.000GMT", "removeReason": "Lost worker"}]`
+
+func TestRemovedExecutors(t *testing.T) {
+	const (
+		addTime    = 1672671847
+		removeTime = 1672675447
+	)
+	tests := []struct {
+		name          string
+		routes        map[string]string
+		want          []series
+		wantMissing   []series
+		wantRequested string
+	}{
+		{
+			name:   "active and removed executors",
+			routes: map[string]string{"applications/app-1/allexecutors": allExecutorsFixture},
+			want: []series{
+				{"spark_executor_add_time_seconds", map[string]string{"executor_id": "1"}, addTime},
+				{"spark_executor_removed", map[string]string{"executor_id": "2", "remove_reason": "Executor killed"}, 1},
+				{"spark_executor_remove_time_seconds", map[string]string{"executor_id": "2"}, removeTime},
+				{"spark_executor_removed", map[string]string{"executor_id": "3", "remove_reason": "Lost worker"}, 1},
+				{"spark_application_executors_total", map[string]string{"app_id": "app-1"}, 3},
+				{"spark_application_active_executors", map[string]string{"app_id": "app-1"}, 1},
+				{"spark_executor_total_tasks", map[string]string{"executor_id": "1"}, 3},
+			},
+			wantMissing: []series{
+				{"spark_executor_add_time_seconds", map[string]string{"executor_id": "2"}, 0},
+				{"spark_executor_total_tasks", map[string]string{"executor_id": "2"}, 0},
+				{"spark_executor_remove_time_seconds", map[string]string{"executor_id": "3"}, 0},
+				{"spark_executor_removed", map[string]string{"executor_id": "1"}, 0},
+			},
+			wantRequested: "applications/app-1/allexecutors",
+		},
+		{
+			name:   "without allexecutors",
+			routes: map[string]string{"applications/app-1/executors": `[{"id": "1", "isActive": true, "addTime": "2023-01-02T15:04:07.000GMT"}]`},
+			want: []series{
+				{"spark_executor_add_time_seconds", map[string]string{"executor_id": "1"}, addTime},
+			},
+			wantMissing: []series{
+				{"spark_executor_removed", map[string]string{}, 0},
+			},
+			wantRequested: "applications/app-1/executors",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSparkServer(t, appRoutes(tt.routes))
+			families := gather(t, newTestExporter(t, []string{s.URL}, Options{}))
+			checkSeries(t, families, tt.want)
+			for _, m := range tt.wantMissing {
+				if got, ok := metricValue(families, m.name, m.labels); ok {
+					t.Errorf("got %s%v %v, want it missing", m.name, m.labels, got)
+				}
+			}
+			// The executors are listed by a single request.
+			if n := s.requested(tt.wantRequested); n != 1 {
+				t.Errorf("got %d requests to %s, want 1", n, tt.wantRequested)
+			}
+			if tt.wantRequested == "applications/app-1/allexecutors" && s.requested("applications/app-1/executors") != 0 {
+				t.Error("the active executors were requested apart from allexecutors")
+			}
+		})
+	}
+}
+
+func TestExecutorEndpoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		endpoint    string
+		executors   string
+		want        []series
+		wantMissing []series
+	}{
+		{
+			name:     "active",
+			endpoint: "active",
+			executors: `[
+				{"id": "1", "isActive": true, "completedTasks": 7},
+				{"id": "4", "isActive": false, "removeReason": "Executor killed", "completedTasks": 12}]`,
+			want: []series{
+				{"spark_executor_completed_tasks", map[string]string{"executor_id": "1"}, 7},
+				{"spark_executor_removed", map[string]string{"executor_id": "4"}, 1},
+			},
+			wantMissing: []series{
+				{"spark_executor_completed_tasks", map[string]string{"executor_id": "4"}, 0},
+				{"spark_executor_completed_tasks", map[string]string{"is_active": "true"}, 0},
+			},
+		},
+		{
+			name:     "all",
+			endpoint: "all",
+			executors: `[
+				{"id": "driver", "isActive": true, "completedTasks": 0},
+				{"id": "5", "isActive": false, "removeReason": "Lost worker", "completedTasks": 30, "failedTasks": 2, "totalGCTime": 4000}]`,
+			want: []series{
+				{"spark_executor_completed_tasks", map[string]string{"executor_id": "driver", "is_active": "true"}, 0},
+				{"spark_executor_completed_tasks", map[string]string{"executor_id": "5", "is_active": "false"}, 30},
+				{"spark_executor_failed_tasks", map[string]string{"executor_id": "5", "is_active": "false"}, 2},
+				{"spark_executor_removed", map[string]string{"executor_id": "5", "remove_reason": "Lost worker"}, 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSparkServer(t, appRoutes(map[string]string{"applications/app-1/allexecutors": tt.executors}))
+			e := newTestExporter(t, []string{s.URL}, Options{ExecutorEndpoint: tt.endpoint})
+			// The counters of the removed executors keep their final
+			// values across scrapes.
+			for i := 0; i < 2; i++ {
+				families := gather(t, e)
+				checkSeries(t, families, tt.want)
+				for _, m := range tt.wantMissing {
+					if got, ok := metricValue(families, m.name, m.labels); ok {
+						t.Errorf("got %s%v %v, want it missing", m.name, m.labels, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidExecutorEndpoint(t *testing.T) {
+	if _, err := NewExporter([]string{"http://driver:4040"}, FetchOptions{}, Options{ExecutorEndpoint: "removed"}); err == nil {
+		t.Error("got no error for an invalid executor endpoint")
+	}
+}
@@ -1,29 +1,43 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
 	"net/http"
-	_ "net/http/pprof"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
-	namespace = "spark"
+	// defaultNamespace prefixes the metric names, unless overridden by
+	// Options.Namespace.
+	defaultNamespace = "spark"
 )
 
 var (
-	executorLabelNames    = []string{"executor_id"}
-	applicationLabelNames = []string{"app_id"}
+	executorLabelNames    = []string{"endpoint", "app_id", "executor_id"}
+	applicationLabelNames = []string{"endpoint", "app_id", "app_name"}
+	hostPortLabelName     = "host_port"
+	isActiveLabelName     = "is_active"
 )
 
-func newGaugeExecutorMetrics(metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+func newGaugeExecutorMetrics(namespace string, metricName string, docString string, labelNames []string, constLabels prometheus.Labels) *prometheus.GaugeVec {
 	return prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace:   namespace,
@@ -31,23 +45,39 @@ func newGaugeExecutorMetrics(metricName string, docString string, constLabels pr
 			Help:        docString,
 			ConstLabels: constLabels,
 		},
-		executorLabelNames,
+		labelNames,
 	)
 }
 
-func newCounterExecutorMetrics(metricName string, docString string, constLabels prometheus.Labels) *prometheus.CounterVec {
-	return prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace:   namespace,
-			Name:        "executor_" + metricName,
-			Help:        docString,
-			ConstLabels: constLabels,
-		},
-		executorLabelNames,
+// newCounterExecutorDesc describes an executor counter. Spark reports these
+// as absolute values, which a CounterVec can't be set to, so they are exposed
+// as constant metrics on every collect.
+func newCounterExecutorDesc(namespace string, metricName string, docString string, labelNames []string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "executor", metricName),
+		docString,
+		labelNames,
+		constLabels,
 	)
 }
 
-func newApplicationMetrics(metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+// executorCounter is an executor counter together with the function
+// extracting its value from the executor stats.
+type executorCounter struct {
+	desc  *prometheus.Desc
+	value func(ExecutorInfo) float64
+}
+
+// nonNegative converts v to a float64, reporting values Spark left unset as
+// negative numbers as 0.
+func nonNegative(v int64) float64 {
+	if v < 0 {
+		return 0
+	}
+	return float64(v)
+}
+
+func newApplicationMetrics(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
 	return prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace:   namespace,
@@ -59,119 +89,1686 @@ func newApplicationMetrics(metricName string, docString string, constLabels prom
 	)
 }
 
-var (
-	executorGaugeMetrics = []*prometheus.GaugeVec{
-		newGaugeExecutorMetrics("active_tasks", "Current number of active tasks", nil),
+func newApplicationGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"executors_total":       newApplicationMetrics(namespace, "executors_total", "Number of executors of the application, removed ones included", constLabels),
+		"active_executors":      newApplicationMetrics(namespace, "active_executors", "Number of active executors of the application", constLabels),
+		"active_tasks_total":    newApplicationMetrics(namespace, "active_tasks_total", "Number of active tasks of the executors of the application, driver included", constLabels),
+		"completed_tasks_total": newApplicationMetrics(namespace, "completed_tasks_total", "Number of tasks completed by the executors of the application, driver and removed executors included", constLabels),
 	}
-	executorCounterMetrics = []*prometheus.CounterVec{
-		newCounterExecutorMetrics("completedTasks", "Current number of active tasks", nil),
+}
+
+func newExecutorGaugeMetrics(namespace string, labelNames []string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"active_tasks":      newGaugeExecutorMetrics(namespace, "active_tasks", "Current number of active tasks", labelNames, constLabels),
+		"memory_used_bytes": newGaugeExecutorMetrics(namespace, "memory_used_bytes", "Storage memory used by the executor in bytes", labelNames, constLabels),
+		"max_memory_bytes":  newGaugeExecutorMetrics(namespace, "max_memory_bytes", "Total storage memory available to the executor in bytes", labelNames, constLabels),
+		"disk_used_bytes":   newGaugeExecutorMetrics(namespace, "disk_used_bytes", "Disk space used by the executor for storage in bytes", labelNames, constLabels),
+		"rdd_blocks":        newGaugeExecutorMetrics(namespace, "rdd_blocks", "Number of RDD blocks cached by the executor", labelNames, constLabels),
+		"total_cores":       newGaugeExecutorMetrics(namespace, "total_cores", "Number of cores available to the executor", labelNames, constLabels),
+		"max_tasks":         newGaugeExecutorMetrics(namespace, "max_tasks", "Maximum number of tasks the executor can run at the same time", labelNames, constLabels),
+		"excluded":          newGaugeExecutorMetrics(namespace, "excluded", "Whether Spark excluded the executor from scheduling tasks, for the application or some of its stages", labelNames, constLabels),
+		"add_time_seconds":  newGaugeExecutorMetrics(namespace, "add_time_seconds", "Time the executor was added since unix epoch in seconds", labelNames, constLabels),
+
+		"on_heap_storage_memory_used_bytes":   newGaugeExecutorMetrics(namespace, "on_heap_storage_memory_used_bytes", "On-heap storage memory used by the executor in bytes", labelNames, constLabels),
+		"off_heap_storage_memory_used_bytes":  newGaugeExecutorMetrics(namespace, "off_heap_storage_memory_used_bytes", "Off-heap storage memory used by the executor in bytes", labelNames, constLabels),
+		"on_heap_storage_memory_total_bytes":  newGaugeExecutorMetrics(namespace, "on_heap_storage_memory_total_bytes", "On-heap storage memory available to the executor in bytes", labelNames, constLabels),
+		"off_heap_storage_memory_total_bytes": newGaugeExecutorMetrics(namespace, "off_heap_storage_memory_total_bytes", "Off-heap storage memory available to the executor in bytes", labelNames, constLabels),
 	}
-)
+}
 
-// Exporter collects Spark stats from the given URI and exports them using
+func newExecutorCounterMetrics(namespace string, labelNames []string, constLabels prometheus.Labels) []executorCounter {
+	return []executorCounter{
+		{
+			newCounterExecutorDesc(namespace, "completed_tasks", "Number of tasks completed by the executor", labelNames, constLabels),
+			func(ex ExecutorInfo) float64 { return float64(ex.CompletedTasks) },
+		},
+		{
+			newCounterExecutorDesc(namespace, "failed_tasks", "Number of tasks that failed in the executor", labelNames, constLabels),
+			func(ex ExecutorInfo) float64 { return float64(ex.FailedTasks) },
+		},
+		{
+			newCounterExecutorDesc(namespace, "total_tasks", "Number of tasks run by the executor", labelNames, constLabels),
+			func(ex ExecutorInfo) float64 { return float64(ex.TotalTasks) },
+		},
+		{
+			newCounterExecutorDesc(namespace, "shuffle_read_bytes", "Total shuffle bytes read by the executor", labelNames, constLabels),
+			func(ex ExecutorInfo) float64 { return nonNegative(ex.TotalShuffleRead) },
+		},
+		{
+			newCounterExecutorDesc(namespace, "shuffle_write_bytes", "Total shuffle bytes written by the executor", labelNames, constLabels),
+			func(ex ExecutorInfo) float64 { return nonNegative(ex.TotalShuffleWrite) },
+		},
+		{
+			newCounterExecutorDesc(namespace, "total_input_bytes", "Total input bytes read by the executor", labelNames, constLabels),
+			func(ex ExecutorInfo) float64 { return nonNegative(ex.TotalInputBytes) },
+		},
+		{
+			newCounterExecutorDesc(namespace, "total_duration_seconds", "Total time spent by the executor running tasks in seconds", labelNames, constLabels),
+			func(ex ExecutorInfo) float64 { return nonNegative(ex.TotalDuration) / 1000 },
+		},
+		{
+			newCounterExecutorDesc(namespace, "gc_time_seconds_total", "Total time spent by the executor in garbage collection in seconds", labelNames, constLabels),
+			func(ex ExecutorInfo) float64 { return nonNegative(ex.TotalGCTime) / 1000 },
+		},
+	}
+}
+
+// Exporter collects Spark stats from the given URIs and exports them using
 // the prometheus metrics package.
 type Exporter struct {
-	URI   string
-	mutex sync.RWMutex
-	fetch func() (io.ReadCloser, error)
+	mutex   sync.RWMutex
+	targets []*target
+	// master, if set, is the Standalone Master scraped for cluster metrics.
+	master *target
+	// yarnATS, if set, is the YARN Timeline Server listing the finished
+	// applications.
+	yarnATS *target
+	// discoverers list additional targets on every scrape, and discovered
+	// holds the targets they returned on the last scrape, by URI.
+	discoverers []*discoverer
+	discovered  map[string]*target
+	// fetchOpts and client are used to build the targets discovered at
+	// scrape time.
+	fetchOpts FetchOptions
+	client    httpDoer
+	// concurrency is the number of targets scraped at the same time.
+	concurrency int
+	// maxConcurrency is the number of requests made to a target at the
+	// same time.
+	maxConcurrency int
+	// timeout bounds the time taken by a whole scrape, unlike the timeout
+	// of fetchOpts bounding each request.
+	timeout time.Duration
+	// readiness, if set, records the outcome of every scrape.
+	readiness *readiness
+	// scrapeDurations, if set, observes the duration of every scrape.
+	scrapeDurations prometheus.Observer
+	// cacheTTL is the time during which the result of a scrape is served
+	// again instead of scraping Spark, 0 to disable caching.
+	cacheTTL time.Duration
+	// lastScrape is the time of the last scrape to Spark.
+	lastScrape time.Time
+	// lastStatus is the status of the last scrape, for the landing page.
+	lastStatus scrapeStatus
+	// scrapeInterval, if set, is the interval of the background scrapes,
+	// whose last result is held by snapshot.
+	scrapeInterval time.Duration
+	snapshotMutex  sync.RWMutex
+	snapshot       []prometheus.Metric
+
+	// hostPortLabel adds the executor host and port as a label.
+	hostPortLabel bool
+	// peakMemoryMetrics exports the peak memory metrics of executors.
+	peakMemoryMetrics bool
+	// includeCompletedStages exports the metrics of finished stages too.
+	includeCompletedStages bool
+	// taskDistributions exports the quantiles of the task metrics of active
+	// stages.
+	taskDistributions bool
+	// rddNameLabel adds the RDD name as a label.
+	rddNameLabel bool
+	// applicationUserLabel exports the user running each application.
+	applicationUserLabel bool
+	// executorLogsLabel exports the log URLs of executors.
+	executorLogsLabel bool
+	// appInName moves the application id from the app_id label to the
+	// metric names, when serving the metrics.
+	appInName bool
+	// namespace prefixes the names of the metrics.
+	namespace string
+	// targetAliases holds the aliases of the aliased targets by endpoint,
+	// exported in the target label.
+	targetAliases map[string]string
+	// sqlMaxExecutions limits the number of SQL executions exported per
+	// application.
+	sqlMaxExecutions int
+	// applicationsPath is the path listing the applications to scrape.
+	applicationsPath string
+	// applicationFilter selects the applications to scrape by name.
+	applicationFilter *nameFilter
+	// executorFilter selects the executors to export by id.
+	executorFilter *nameFilter
+	// keepDriver exports the driver whatever executorFilter.
+	keepDriver bool
+	// separateDriver exports the driver in the driver metrics instead of
+	// the executor metrics.
+	separateDriver bool
+	// allExecutors exports the removed executors along with the active
+	// ones, labeled by is_active.
+	allExecutors bool
+	// endpoints are the endpoints requested for each application, among
+	// scrapeEndpoints.
+	endpoints map[string]bool
 
-	up prometheus.Gauge
+	up                          *prometheus.GaugeVec
+	lastSuccess                 *prometheus.GaugeVec
+	endpointErrors              *prometheus.CounterVec
+	scrapeDuration              prometheus.Gauge
+	scrapeErrors                *prometheus.CounterVec
+	cacheHits                   prometheus.Counter
+	executorsFiltered           prometheus.Counter
+	applicationsDiscovered      *prometheus.GaugeVec
+	applicationsScraped         *prometheus.GaugeVec
+	executorGaugeMetrics        map[string]*prometheus.GaugeVec
+	executorCounterMetrics      []executorCounter
+	removedExecutorGaugeMetrics map[string]*prometheus.GaugeVec
+	driverGaugeMetrics          map[string]*prometheus.GaugeVec
+	poolGaugeMetrics            map[string]*prometheus.GaugeVec
+	applicationGaugeMetrics     map[string]*prometheus.GaugeVec
+	attemptGaugeMetrics         map[string]*prometheus.GaugeVec
+	applicationInfo             *prometheus.GaugeVec
+	jobGaugeMetrics             map[string]*prometheus.GaugeVec
+	stageGaugeMetrics           map[string]*prometheus.GaugeVec
+	stageCounterMetrics         []stageCounter
+	// taskDurations, if set, observes the durations of the tasks of the
+	// stages in completedStages, kept across scrapes.
+	taskDurations         *prometheus.HistogramVec
+	completedStages       *completedStages
+	stageQuantileMetrics  map[string]*prometheus.GaugeVec
+	rddGaugeMetrics       map[string]*prometheus.GaugeVec
+	streamingGaugeMetrics map[string]*prometheus.GaugeVec
+	sqlGaugeMetrics       map[string]*prometheus.GaugeVec
+	masterGaugeMetrics    map[string]*prometheus.GaugeVec
+	yarnATSGaugeMetrics   map[string]*prometheus.GaugeVec
+	applications          []ApplicationInfo
+	stages                []appStage
+	failedTargets         []string
 }
 
-func NewExporter(uri string, timeout time.Duration) (*Exporter, error) {
-	u, err := url.Parse(uri)
+// Options holds the settings controlling which metrics an Exporter exports
+// and how they are labeled.
+type Options struct {
+	HostPortLabel     bool
+	PeakMemoryMetrics bool
+
+	// ConstLabels are added to all the metrics of the exporter.
+	ConstLabels            map[string]string
+	IncludeCompletedStages bool
+	TaskDistributions      bool
+	// TaskDurationHistogram exports the histogram of the durations of the
+	// tasks of the completed stages, as a native histogram with
+	// NativeHistograms.
+	TaskDurationHistogram bool
+	NativeHistograms      bool
+	RDDNameLabel          bool
+	ApplicationUserLabel  bool
+	ExecutorLogsLabel     bool
+
+	// AppInName serves the metrics of each application under names
+	// prefixed with its id instead of with an app_id label.
+	AppInName bool
+
+	// Namespace prefixes the names of the metrics, spark when empty.
+	Namespace string
+
+	// SQLMaxExecutions is the maximum number of SQL executions exported per
+	// application, running ones first. 0 exports them all.
+	SQLMaxExecutions int
+
+	// MasterURI is the URI of a Standalone Master to scrape cluster
+	// metrics from.
+	MasterURI string
+
+	// YarnRMURI is the URI of a YARN ResourceManager whose running Spark
+	// applications are scraped through its proxy.
+	YarnRMURI string
+
+	// YarnATSURI is the URI of a YARN Timeline Server to export the
+	// finished Spark applications from.
+	YarnATSURI string
+
+	// Scheme is the scheme of the URIs given without one, such as
+	// driver:4040, http when empty.
+	Scheme string
+
+	// K8sMode discovers the Spark driver pods matching K8sLabelSelector in
+	// K8sNamespace, when running in a Kubernetes cluster.
+	K8sMode          bool
+	K8sNamespace     string
+	K8sLabelSelector string
+
+	// TargetConcurrency is the number of URIs scraped at the same time.
+	TargetConcurrency int
+
+	// ScrapeTimeout, if set, bounds the time taken by a whole scrape, the
+	// requests of which are bounded by the timeout of FetchOptions.
+	ScrapeTimeout time.Duration
+
+	// MaxConcurrency is the number of requests made to a URI at the same
+	// time.
+	MaxConcurrency int
+
+	// CacheTTL is the time during which the result of a scrape is served
+	// again instead of scraping Spark, 0 to disable caching.
+	CacheTTL time.Duration
+
+	// ScrapeInterval, if set, scrapes Spark in the background at this
+	// interval, the collections serving the result of the last scrape.
+	ScrapeInterval time.Duration
+
+	// Mode is either "live", to scrape a driver UI, or "history", to scrape
+	// a History Server. The filters below only apply to history mode.
+	Mode          string
+	HistoryStatus string
+	MinDate       string
+	MaxDate       string
+
+	// AppNameInclude and AppNameExclude are regular expressions selecting
+	// the applications to scrape by name.
+	AppNameInclude string
+	AppNameExclude string
+
+	// ExecutorIDInclude and ExecutorIDExclude are regular expressions
+	// selecting the executors to export by id. KeepDriver exports the
+	// driver even when the expressions don't select it.
+	ExecutorIDInclude string
+	ExecutorIDExclude string
+	KeepDriver        bool
+
+	// SeparateDriverMetrics exports the driver in spark_driver_* metrics
+	// instead of the executor metrics.
+	SeparateDriverMetrics bool
+
+	// ExecutorEndpoint is active to export the active executors, or all
+	// to export the removed executors as well.
+	ExecutorEndpoint string
+
+	// Endpoints are the endpoints requested for each application, among
+	// scrapeEndpoints. The defaultScrapeEndpoints are requested when empty.
+	Endpoints []string
+}
+
+// scrapeEndpoints are the endpoints of an application that can be left out
+// of the scrapes. The applications are always listed; application stands
+// for the environment endpoint giving the application info.
+var scrapeEndpoints = []string{"executors", "application", "jobs", "stages", "storage", "sql", "streaming"}
+
+// defaultScrapeEndpoints are the endpoints requested by default, keeping
+// scrapes light.
+var defaultScrapeEndpoints = []string{"executors", "application"}
+
+func isScrapeEndpoint(name string) bool {
+	for _, e := range scrapeEndpoints {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// endpoints returns the set of the endpoints requested for each
+// application.
+func (o Options) endpoints() (map[string]bool, error) {
+	names := o.Endpoints
+	if len(names) == 0 {
+		names = defaultScrapeEndpoints
+	}
+	endpoints := map[string]bool{}
+	for _, name := range names {
+		if !isScrapeEndpoint(name) {
+			return nil, fmt.Errorf("invalid scrape endpoint %q: must be one of %s", name, strings.Join(scrapeEndpoints, ", "))
+		}
+		endpoints[name] = true
+	}
+	return endpoints, nil
+}
+
+// allExecutors reports whether the removed executors listed by the
+// allexecutors endpoint have executor metrics too.
+func (o Options) allExecutors() (bool, error) {
+	switch o.ExecutorEndpoint {
+	case "", "active":
+		return false, nil
+	case "all":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid executor endpoint %q: must be active or all", o.ExecutorEndpoint)
+}
+
+// withScheme returns uri prefixed with o.Scheme when it has no scheme. Empty
+// URIs are returned as is.
+func (o Options) withScheme(uri string) string {
+	if uri == "" || strings.Contains(uri, "://") {
+		return uri
+	}
+	scheme := o.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return scheme + "://" + uri
+}
+
+func (o Options) validateScheme() error {
+	switch o.Scheme {
+	case "", "http", "https":
+		return nil
+	}
+	return fmt.Errorf("invalid spark scheme %q: must be http or https", o.Scheme)
+}
+
+// namespace returns the prefix of the metric names.
+func (o Options) namespace() (string, error) {
+	if o.Namespace == "" {
+		return defaultNamespace, nil
+	}
+	if !model.IsValidMetricName(model.LabelValue(o.Namespace)) {
+		return "", fmt.Errorf("invalid metrics namespace %q", o.Namespace)
+	}
+	return o.Namespace, nil
+}
+
+// applicationsPath returns the path listing the applications to scrape in
+// the configured mode.
+func (o Options) applicationsPath() (string, error) {
+	switch o.Mode {
+	case "", "live":
+		return "applications", nil
+	case "history":
+		params := url.Values{}
+		if o.HistoryStatus != "" {
+			params.Set("status", o.HistoryStatus)
+		}
+		if o.MinDate != "" {
+			params.Set("minDate", o.MinDate)
+		}
+		if o.MaxDate != "" {
+			params.Set("maxDate", o.MaxDate)
+		}
+		if len(params) == 0 {
+			return "applications", nil
+		}
+		return "applications?" + params.Encode(), nil
+	}
+	return "", fmt.Errorf("invalid spark mode %q: must be live or history", o.Mode)
+}
+
+// validateConstLabels checks that labels are valid label names not used by
+// the metrics of the exporter.
+func validateConstLabels(labels map[string]string) error {
+	used := map[string]bool{targetLabelName: true, scrapeErrorReasonLabelName: true, hostPortLabelName: true, isActiveLabelName: true, rddNameLabelName: true, sparkUserLabelName: true, removeReasonLabelName: true}
+	for _, names := range [][]string{
+		executorLabelNames,
+		executorLogsLabelNames,
+		applicationLabelNames,
+		attemptLabelNames,
+		environmentLabelNames,
+		jobStatusLabelNames,
+		stageQuantileLabelNames,
+		rddLabelNames,
+		sqlLabelNames,
+		streamingLabelNames,
+		masterWorkerLabelNames,
+		masterAppStateLabelNames,
+		nodeLabelNames,
+		stagePoolLabelNames,
+		poolLabelNames,
+		yarnATSStatusLabelNames,
+	} {
+		for _, name := range names {
+			used[name] = true
+		}
+	}
+	for name := range labels {
+		switch {
+		case !model.LabelName(name).IsValid():
+			return fmt.Errorf("invalid label name %q", name)
+		case strings.HasPrefix(name, "__"):
+			return fmt.Errorf("invalid label name %q: names starting with __ are reserved", name)
+		case used[name]:
+			return fmt.Errorf("invalid label name %q: already used by the exporter", name)
+		}
+	}
+	return nil
+}
+
+// applicationFilter returns the filter of the applications to scrape.
+func (o Options) applicationFilter() (*nameFilter, error) {
+	f, err := newNameFilter(o.AppNameInclude, o.AppNameExclude)
+	if err != nil {
+		return nil, fmt.Errorf("application name filter: %v", err)
+	}
+	return f, nil
+}
+
+// executorFilter returns the filter of the executors to export.
+func (o Options) executorFilter() (*nameFilter, error) {
+	f, err := newNameFilter(o.ExecutorIDInclude, o.ExecutorIDExclude)
+	if err != nil {
+		return nil, fmt.Errorf("executor id filter: %v", err)
+	}
+	return f, nil
+}
+
+// target is a Spark REST API scraped by an Exporter.
+type target struct {
+	// endpoint identifies the target in the endpoint label.
+	endpoint string
+	fetch    func(ctx context.Context, path string) (io.ReadCloser, error)
+	// version is the Spark version of the target once detected.
+	version *sparkVersion
+}
+
+// appKey identifies an application among the applications of all targets.
+type appKey struct {
+	endpoint string
+	id       string
+}
+
+func (k appKey) labelValues() []string {
+	return []string{k.endpoint, k.id}
+}
+
+// NewExporter returns an initialized Exporter scraping the given URIs.
+func NewExporter(uris []string, fetchOpts FetchOptions, opts Options) (*Exporter, error) {
+	if err := fetchOpts.validate(); err != nil {
+		return nil, err
+	}
+	client, err := newHTTPClient(fetchOpts)
 	if err != nil {
 		return nil, err
 	}
+	e, err := newExporterWithClient(uris, fetchOpts, opts, client)
+	if err != nil {
+		closeHTTPClient(client)
+		return nil, err
+	}
+	return e, nil
+}
 
-	var fetch func() (io.ReadCloser, error)
-	fetch = fetchHTTPApi(uri, timeout)
+// newExporterWithClient returns an initialized Exporter scraping the given
+// URIs with client, built from fetchOpts by newHTTPClient and shared with
+// other exporters, so that its connections and Kerberos tickets outlive the
+// exporter.
+func newExporterWithClient(uris []string, fetchOpts FetchOptions, opts Options, client httpDoer) (*Exporter, error) {
+	if len(uris) == 0 && opts.MasterURI == "" && opts.YarnRMURI == "" && opts.YarnATSURI == "" && !opts.K8sMode {
+		return nil, fmt.Errorf("no spark URI to scrape")
+	}
+	if err := opts.validateScheme(); err != nil {
+		return nil, err
+	}
+	schemed := make([]string, len(uris))
+	targetAliases := map[string]string{}
+	aliased := map[string]bool{}
+	for i, spec := range uris {
+		alias, uri, err := splitTargetAlias(spec)
+		if err != nil {
+			return nil, err
+		}
+		schemed[i] = opts.withScheme(uri)
+		if alias != "" {
+			if aliased[alias] {
+				return nil, fmt.Errorf("duplicate target alias %q", alias)
+			}
+			aliased[alias] = true
+			targetAliases[schemed[i]] = alias
+		}
+	}
+	uris = schemed
+	opts.MasterURI = opts.withScheme(opts.MasterURI)
+	opts.YarnRMURI = opts.withScheme(opts.YarnRMURI)
+	opts.YarnATSURI = opts.withScheme(opts.YarnATSURI)
+	applicationsPath, err := opts.applicationsPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateConstLabels(opts.ConstLabels); err != nil {
+		return nil, err
+	}
+	applicationFilter, err := opts.applicationFilter()
+	if err != nil {
+		return nil, err
+	}
+	executorFilter, err := opts.executorFilter()
+	if err != nil {
+		return nil, err
+	}
+	allExecutors, err := opts.allExecutors()
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := opts.namespace()
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := opts.endpoints()
+	if err != nil {
+		return nil, err
+	}
+	concurrency := opts.TargetConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	maxConcurrency := opts.MaxConcurrency
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+
+	var targets []*target
+	for _, uri := range uris {
+		if _, err := parseSparkURI(uri); err != nil {
+			return nil, err
+		}
+		fetch, err := fetchHTTPApi(uri, fetchOpts, client)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, &target{endpoint: uri, fetch: fetch})
+	}
+	var master *target
+	if opts.MasterURI != "" {
+		if _, err := parseSparkURI(opts.MasterURI); err != nil {
+			return nil, err
+		}
+		fetch := fetchHTTP(opts.MasterURI, fetchOpts, client)
+		master = &target{endpoint: opts.MasterURI, fetch: fetch}
+	}
+	var yarnATS *target
+	if opts.YarnATSURI != "" {
+		if _, err := parseSparkURI(opts.YarnATSURI); err != nil {
+			return nil, err
+		}
+		yarnATS = &target{endpoint: opts.YarnATSURI, fetch: fetchHTTP(opts.YarnATSURI, fetchOpts, client)}
+	}
+	var discoverers []*discoverer
+	if opts.YarnRMURI != "" {
+		d, err := newYarnDiscoverer(opts.YarnRMURI, fetchOpts, client)
+		if err != nil {
+			return nil, err
+		}
+		discoverers = append(discoverers, d)
+	}
+	if opts.K8sMode {
+		d, err := newKubernetesDiscoverer(opts.K8sNamespace, opts.K8sLabelSelector, fetchOpts)
+		switch {
+		case err == errNotInCluster:
+			log.Warnln("Kubernetes discovery disabled:", err)
+		case err != nil:
+			return nil, err
+		default:
+			discoverers = append(discoverers, d)
+		}
+	}
+
+	labelNames := append([]string{}, executorLabelNames...)
+	if opts.HostPortLabel {
+		labelNames = append(labelNames, hostPortLabelName)
+	}
+	if allExecutors {
+		labelNames = append(labelNames, isActiveLabelName)
+	}
+	rddLabels := append([]string{}, rddLabelNames...)
+	if opts.RDDNameLabel {
+		rddLabels = append(rddLabels, rddNameLabelName)
+	}
+
+	constLabels := prometheus.Labels(opts.ConstLabels)
+	var taskDurations *prometheus.HistogramVec
+	if opts.TaskDurationHistogram {
+		taskDurations = newTaskDurationHistogram(namespace, opts.NativeHistograms, constLabels)
+	}
+	executorGaugeMetrics := newExecutorGaugeMetrics(namespace, labelNames, constLabels)
+	if opts.PeakMemoryMetrics {
+		for name, m := range newExecutorPeakMemoryMetrics(namespace, labelNames, constLabels) {
+			executorGaugeMetrics[name] = m
+		}
+	}
+	if opts.ExecutorLogsLabel {
+		executorGaugeMetrics["logs_info"] = newExecutorLogsInfoMetric(namespace, labelNames, constLabels)
+	}
+	attemptGaugeMetrics := newAttemptGaugeMetrics(namespace, constLabels)
+	if opts.ApplicationUserLabel {
+		attemptGaugeMetrics["user_info"] = newAttemptUserInfoMetric(namespace, constLabels)
+	}
+
+	scrapeErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "exporter",
+		Name:        "scrape_errors_total",
+		Help:        "Number of scrapes of Spark endpoints that failed, by reason.",
+		ConstLabels: constLabels,
+	}, []string{scrapeErrorReasonLabelName})
+	for _, reason := range scrapeErrorReasons {
+		scrapeErrors.WithLabelValues(reason)
+	}
 
 	return &Exporter{
-		URI:   uri,
-		fetch: fetch,
-		up: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Name:      "up",
-			Help:      "Was the last scrape to Spark successful.",
+		targets:                targets,
+		master:                 master,
+		yarnATS:                yarnATS,
+		discoverers:            discoverers,
+		fetchOpts:              fetchOpts,
+		client:                 client,
+		concurrency:            concurrency,
+		maxConcurrency:         maxConcurrency,
+		timeout:                opts.ScrapeTimeout,
+		cacheTTL:               opts.CacheTTL,
+		scrapeInterval:         opts.ScrapeInterval,
+		hostPortLabel:          opts.HostPortLabel,
+		peakMemoryMetrics:      opts.PeakMemoryMetrics,
+		includeCompletedStages: opts.IncludeCompletedStages,
+		taskDistributions:      opts.TaskDistributions,
+		rddNameLabel:           opts.RDDNameLabel,
+		applicationUserLabel:   opts.ApplicationUserLabel,
+		executorLogsLabel:      opts.ExecutorLogsLabel,
+		appInName:              opts.AppInName,
+		namespace:              namespace,
+		targetAliases:          targetAliases,
+		sqlMaxExecutions:       opts.SQLMaxExecutions,
+		applicationsPath:       applicationsPath,
+		applicationFilter:      applicationFilter,
+		executorFilter:         executorFilter,
+		keepDriver:             opts.KeepDriver,
+		separateDriver:         opts.SeparateDriverMetrics,
+		allExecutors:           allExecutors,
+		endpoints:              endpoints,
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "up",
+			Help:        "Was the last scrape of the Spark endpoint successful.",
+			ConstLabels: constLabels,
+		}, []string{"endpoint"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "last_scrape_timestamp_seconds",
+			Help:        "Time of the last successful scrape of the Spark endpoint since unix epoch in seconds.",
+			ConstLabels: constLabels,
+		}, []string{"endpoint"}),
+		endpointErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "endpoint_scrape_errors_total",
+			Help:        "Number of failed scrapes of the Spark endpoint and of failed requests for its applications.",
+			ConstLabels: constLabels,
+		}, []string{"endpoint"}),
+		scrapeDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "scrape_duration_seconds",
+			Help:        "Duration of the last scrape to Spark in seconds.",
+			ConstLabels: constLabels,
 		}),
+		scrapeErrors: scrapeErrors,
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "cache_hit_total",
+			Help:        "Number of collections served from the result of a previous scrape.",
+			ConstLabels: constLabels,
+		}),
+		executorsFiltered: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "executors_filtered_total",
+			Help:        "Number of scraped executors not exported because of the executor id filters.",
+			ConstLabels: constLabels,
+		}),
+		applicationsDiscovered: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "applications_discovered",
+			Help:        "Number of applications listed by the Spark endpoint in the last scrape.",
+			ConstLabels: constLabels,
+		}, []string{"endpoint"}),
+		applicationsScraped: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "applications_scraped",
+			Help:        "Number of applications of the Spark endpoint scraped in the last scrape, once filtered by name.",
+			ConstLabels: constLabels,
+		}, []string{"endpoint"}),
+		executorGaugeMetrics:        executorGaugeMetrics,
+		executorCounterMetrics:      newExecutorCounterMetrics(namespace, labelNames, constLabels),
+		removedExecutorGaugeMetrics: newRemovedExecutorGaugeMetrics(namespace, constLabels),
+		driverGaugeMetrics:          newDriverGaugeMetrics(namespace, constLabels),
+		poolGaugeMetrics:            newPoolGaugeMetrics(namespace, constLabels),
+		applicationGaugeMetrics:     newApplicationGaugeMetrics(namespace, constLabels),
+		attemptGaugeMetrics:         attemptGaugeMetrics,
+		applicationInfo:             newApplicationInfoMetric(namespace, constLabels),
+		jobGaugeMetrics:             newJobGaugeMetrics(namespace, constLabels),
+		stageGaugeMetrics:           newStageGaugeMetrics(namespace, constLabels),
+		stageCounterMetrics:         newStageCounterMetrics(namespace, constLabels),
+		taskDurations:               taskDurations,
+		completedStages:             &completedStages{stages: map[appKey]map[stageAttempt]bool{}},
+		stageQuantileMetrics:        newStageQuantileGaugeMetrics(namespace, constLabels),
+		rddGaugeMetrics:             newRDDGaugeMetrics(namespace, rddLabels, constLabels),
+		streamingGaugeMetrics:       newStreamingGaugeMetrics(namespace, constLabels),
+		sqlGaugeMetrics:             newSQLGaugeMetrics(namespace, constLabels),
+		masterGaugeMetrics:          newMasterGaugeMetrics(namespace, constLabels),
+		yarnATSGaugeMetrics:         newYarnATSGaugeMetrics(namespace, constLabels),
 	}, nil
 }
 
-func fetchHTTPApi(uri string, timeout time.Duration) {
+// parseSparkURI parses uri and checks that it can be used to reach a Spark
+// REST API.
+func parseSparkURI(uri string) (*url.URL, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spark URI: %v", err)
+	}
+	switch {
+	case u.Scheme == "" || u.Opaque != "":
+		return nil, fmt.Errorf("invalid spark URI: missing scheme")
+	case u.Scheme != "http" && u.Scheme != "https":
+		return nil, fmt.Errorf("invalid spark URI: unsupported scheme %q", u.Scheme)
+	case u.Host == "":
+		return nil, fmt.Errorf("invalid spark URI: missing host")
+	}
+	return u, nil
+}
+
+// Describe describes all the metrics ever exported by the Spark exporter. It
+// implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range e.gaugeMetrics() {
+		m.Describe(ch)
+	}
+	for _, m := range e.executorCounterMetrics {
+		ch <- m.desc
+	}
+	for _, m := range e.stageCounterMetrics {
+		ch <- m.desc
+	}
+	if e.taskDurations != nil {
+		e.taskDurations.Describe(ch)
+	}
+	e.up.Describe(ch)
+	e.lastSuccess.Describe(ch)
+	e.endpointErrors.Describe(ch)
+	ch <- e.scrapeDuration.Desc()
+	e.scrapeErrors.Describe(ch)
+	ch <- e.cacheHits.Desc()
+	ch <- e.executorsFiltered.Desc()
+}
+
+// Collect fetches the stats from configured Spark location and delivers them
+// as Prometheus metrics. It implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.collect(context.Background(), ch)
+}
+
+// WithContext returns a collector of the exporter metrics whose scrapes are
+// cancelled when ctx is done, e.g. when the client scraping the metrics
+// disconnects.
+func (e *Exporter) WithContext(ctx context.Context) prometheus.Collector {
+	return contextCollector{e, ctx}
+}
+
+type contextCollector struct {
+	*Exporter
+	ctx context.Context
+}
+
+func (c contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.ctx, ch)
+}
+
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	if e.scrapeInterval > 0 {
+		e.collectSnapshot(ctx, ch)
+		return
+	}
+	e.scrapeAndCollect(ctx, ch)
+}
+
+// scrapeAndCollect scrapes Spark, unless the result of the last scrape is
+// cached, and sends the metrics to ch.
+func (e *Exporter) scrapeAndCollect(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.mutex.Lock() // To protect metrics from concurrent collects.
+	defer e.mutex.Unlock()
+
+	if e.cacheTTL > 0 && !e.lastScrape.IsZero() && time.Since(e.lastScrape) < e.cacheTTL {
+		e.cacheHits.Inc()
+	} else {
+		e.resetMetrics()
+		start := time.Now()
+		err := e.scrape(ctx)
+		e.lastScrape = time.Now()
+		e.recordStatus()
+		duration := time.Since(start).Seconds()
+		e.scrapeDuration.Set(duration)
+		if e.scrapeDurations != nil {
+			e.scrapeDurations.Observe(duration)
+		}
+		if e.readiness != nil {
+			e.readiness.record(err)
+		}
+		if err != nil {
+			log.Errorf("Can't scrape Spark: %v", err)
+		}
+	}
+
+	e.up.Collect(ch)
+	e.lastSuccess.Collect(ch)
+	e.endpointErrors.Collect(ch)
+	ch <- e.scrapeDuration
+	e.scrapeErrors.Collect(ch)
+	ch <- e.cacheHits
+	ch <- e.executorsFiltered
+	e.collectMetrics(ch)
+}
+
+// targetResult holds what scraping a target collected, other than the
+// gauges set while scraping.
+type targetResult struct {
+	applications []ApplicationInfo
+	stages       []appStage
+	// failures is the number of failed requests to the endpoints of the
+	// applications.
+	failures int
+}
+
+// setUp records whether the scrape of endpoint succeeded, err being the
+// error of a failed scrape. The time of the last success is kept across
+// scrapes, so that it tells for how long a failing endpoint has been down. A
+// failing endpoint doesn't prevent the others from being scraped and
+// exported.
+func (e *Exporter) setUp(endpoint string, err error) {
+	if err != nil {
+		e.up.WithLabelValues(endpoint).Set(0)
+		e.endpointErrors.WithLabelValues(endpoint).Inc()
+		e.scrapeErrors.WithLabelValues(scrapeErrorReason(err)).Inc()
+		return
+	}
+	e.up.WithLabelValues(endpoint).Set(1)
+	e.lastSuccess.WithLabelValues(endpoint).SetToCurrentTime()
+}
+
+// scrape scrapes all targets, at most e.concurrency at the same time.
+func (e *Exporter) scrape(ctx context.Context) error {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	var failed []string
+	targets := e.targets
+	if len(e.discoverers) > 0 {
+		var uris []string
+		for _, d := range e.discoverers {
+			discovered, err := d.discover(ctx)
+			if err != nil {
+				e.setUp(d.endpoint, err)
+				failed = append(failed, fmt.Sprintf("%s: %v", d.endpoint, err))
+				continue
+			}
+			e.setUp(d.endpoint, nil)
+			uris = append(uris, discovered...)
+		}
+		targets = append(append([]*target{}, e.targets...), e.discoveredTargets(uris)...)
+	}
+
+	results := make([]targetResult, len(targets))
+	errs := make([]error, len(targets))
+	sem := make(chan struct{}, e.concurrency)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t *target) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = e.scrapeTarget(ctx, t)
+		}(i, t)
+	}
+	var masterErr error
+	if e.master != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			masterErr = e.scrapeMaster(ctx, e.master)
+		}()
+	}
+	var yarnATSErr error
+	if e.yarnATS != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			yarnATSErr = e.scrapeYarnATS(ctx, e.yarnATS)
+		}()
+	}
+	wg.Wait()
+
+	if e.master != nil {
+		e.setUp(e.master.endpoint, masterErr)
+		if masterErr != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", e.master.endpoint, masterErr))
+		}
+	}
+	if e.yarnATS != nil {
+		e.setUp(e.yarnATS.endpoint, yarnATSErr)
+		if yarnATSErr != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", e.yarnATS.endpoint, yarnATSErr))
+		}
+	}
+	for i, t := range targets {
+		if errs[i] != nil {
+			e.setUp(t.endpoint, errs[i])
+			failed = append(failed, fmt.Sprintf("%s: %v", t.endpoint, errs[i]))
+			e.failedTargets = append(e.failedTargets, t.endpoint)
+			continue
+		}
+		e.setUp(t.endpoint, nil)
+		e.endpointErrors.WithLabelValues(t.endpoint).Add(float64(results[i].failures))
+		e.applications = append(e.applications, results[i].applications...)
+		e.stages = append(e.stages, results[i].stages...)
+	}
+	if e.taskDurations != nil {
+		e.pruneTaskDurations()
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (e *Exporter) scrapeTarget(ctx context.Context, t *target) (targetResult, error) {
+	var result targetResult
+
+	body, err := t.fetch(ctx, e.applicationsPath)
+	if err != nil {
+		return result, err
+	}
+	apps, err := parseApplications(body, func(app ApplicationMetrics) bool {
+		return e.applicationFilter.match(app.Name)
+	})
+	body.Close()
+	if err != nil {
+		return result, err
+	}
+	selected := apps.Applications
+	e.applicationsDiscovered.WithLabelValues(t.endpoint).Set(float64(apps.Listed))
+	e.applicationsScraped.WithLabelValues(t.endpoint).Set(float64(len(selected)))
+
+	// The version is only needed by the application, sql and streaming
+	// endpoints.
+	var apiVersion sparkVersion
+	if len(selected) > 0 && (e.endpoints["application"] || e.endpoints["sql"] || e.endpoints["streaming"]) {
+		apiVersion = t.detectVersion(ctx)
+	}
+
+	// The endpoints of the applications are requested concurrently, at most
+	// e.maxConcurrency at the same time. Each request stores its results in
+	// the slots of its application, so that they keep the order of the
+	// listing. A failed request is counted in the errors of the endpoint
+	// without stopping the others, so that the rest of the target is still
+	// exported, unless the scrape timed out.
+	var wg sync.WaitGroup
+	var failures int32
+	sem := make(chan struct{}, e.maxConcurrency)
+	run := func(appID string, f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			if err := f(); err != nil && ctx.Err() == nil {
+				log.Warnf("Can't scrape application %s of %s: %v", appID, t.endpoint, err)
+				atomic.AddInt32(&failures, 1)
+				e.scrapeErrors.WithLabelValues(scrapeErrorReason(err)).Inc()
+			}
+		}()
+	}
+	applications := make([]ApplicationInfo, len(selected))
+	stages := make([][]appStage, len(selected))
+	for i, app := range selected {
+		i, app := i, app
+		key := appKey{t.endpoint, app.ID}
+		run(app.ID, func() error {
+			info, err := e.scrapeApplication(ctx, t, key, app)
+			applications[i] = info
+			return err
+		})
+		if e.endpoints["application"] {
+			run(app.ID, func() error {
+				env, err := t.scrapeEnvironment(ctx, app.ID)
+				if err != nil {
+					return err
+				}
+				e.setApplicationInfo(key, apiVersion.name, env)
+				return nil
+			})
+		}
+		if e.endpoints["jobs"] {
+			run(app.ID, func() error {
+				jobs, err := t.scrapeJobs(ctx, app.ID)
+				if err != nil {
+					return err
+				}
+				now := time.Now()
+				for _, job := range jobs {
+					e.setJobMetrics(key, job, now)
+				}
+				return nil
+			})
+		}
+		if e.endpoints["stages"] {
+			run(app.ID, func() error {
+				var err error
+				stages[i], err = e.scrapeApplicationStages(ctx, t, key)
+				return err
+			})
+		}
+		if e.endpoints["storage"] {
+			run(app.ID, func() error {
+				rdds, err := t.scrapeRDDs(ctx, app.ID)
+				if err != nil {
+					return err
+				}
+				for _, rdd := range rdds {
+					e.setRDDMetrics(key, rdd)
+				}
+				return nil
+			})
+		}
+		// The sql endpoint was added in Spark 3.0 and the streaming one
+		// in 2.2.
+		if e.endpoints["sql"] && apiVersion.atLeast(3, 0) {
+			run(app.ID, func() error {
+				executions, err := t.scrapeSQL(ctx, app.ID)
+				if err != nil {
+					return err
+				}
+				for _, execution := range limitSQLExecutions(executions, e.sqlMaxExecutions) {
+					e.setSQLMetrics(key, execution)
+				}
+				return nil
+			})
+		}
+		if e.endpoints["streaming"] && apiVersion.atLeast(2, 2) {
+			run(app.ID, func() error {
+				streaming, err := t.scrapeStreaming(ctx, app.ID)
+				if err != nil {
+					return err
+				}
+				if streaming != nil {
+					e.setStreamingMetrics(key, streaming)
+				}
+				return nil
+			})
+		}
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	result.failures = int(failures)
+
+	// The applications whose executors couldn't be scraped are left out.
+	for _, info := range applications {
+		if info.ID != "" {
+			result.applications = append(result.applications, info)
+		}
+	}
+	for _, s := range stages {
+		result.stages = append(result.stages, s...)
+	}
+	return result, nil
+}
+
+// scrapeApplication sets the executor and attempt metrics of app and returns
+// the information kept about it after the scrape. The executors are left out
+// when their endpoint isn't scraped.
+func (e *Exporter) scrapeApplication(ctx context.Context, t *target, key appKey, app ApplicationMetrics) (ApplicationInfo, error) {
+	var exported []ExecutorInfo
+	if e.endpoints["executors"] {
+		var err error
+		if exported, err = e.scrapeApplicationExecutors(ctx, t, key, app); err != nil {
+			return ApplicationInfo{}, err
+		}
+	}
+	now := time.Now()
+	for i, attempt := range app.Attempts {
+		e.setAttemptMetrics(key, attemptID(app.Attempts, i), attempt, now)
+	}
+	return ApplicationInfo{app, t.endpoint, exported}, nil
+}
+
+// scrapeApplicationExecutors sets the executor metrics of app and returns the
+// exported executors. With e.allExecutors, the metrics of the removed
+// executors are set too.
+func (e *Exporter) scrapeApplicationExecutors(ctx context.Context, t *target, key appKey, app ApplicationMetrics) ([]ExecutorInfo, error) {
+	executors, err := t.scrapeAllExecutors(ctx, app.ID)
+	if err != nil {
+		return nil, err
+	}
+	active, activeTasks, completedTasks := 0, 0, 0
+	var exported []ExecutorInfo
+	for _, ex := range executors {
+		// The driver runs the tasks of local applications.
+		activeTasks += ex.ActiveTasks
+		completedTasks += ex.CompletedTasks
+		// Without e.allExecutors, the removed executors only have the
+		// removed executor metrics.
+		if !ex.IsActive && !e.allExecutors {
+			if e.exportExecutor(ex) {
+				e.setRemovedExecutorMetrics(key, ex)
+			}
+			continue
+		}
+		if ex.IsActive {
+			active++
+		}
+		if e.separateDriver && ex.ID == driverExecutorID {
+			e.setDriverMetrics(key, ex)
+			continue
+		}
+		if !e.exportExecutor(ex) {
+			e.executorsFiltered.Inc()
+			continue
+		}
+		e.setExecutorMetrics(key, ex)
+		if !ex.IsActive {
+			e.setRemovedExecutorMetrics(key, ex)
+		}
+		exported = append(exported, ex)
+	}
+	appLabels := applicationLabelValues(key, app)
+	e.applicationGaugeMetrics["executors_total"].WithLabelValues(appLabels...).Set(float64(len(executors)))
+	e.applicationGaugeMetrics["active_executors"].WithLabelValues(appLabels...).Set(float64(active))
+	e.applicationGaugeMetrics["active_tasks_total"].WithLabelValues(appLabels...).Set(float64(activeTasks))
+	e.applicationGaugeMetrics["completed_tasks_total"].WithLabelValues(appLabels...).Set(float64(completedTasks))
+	return exported, nil
+}
+
+// scrapeApplicationStages sets the metrics of the stages of the application
+// and returns the exported stages.
+func (e *Exporter) scrapeApplicationStages(ctx context.Context, t *target, key appKey) ([]appStage, error) {
+	stages, err := t.scrapeStages(ctx, key.id)
+	if err != nil {
+		return nil, err
+	}
+	if e.taskDurations != nil {
+		if err := e.observeTaskDurations(ctx, t, key, stages); err != nil {
+			return nil, err
+		}
+	}
+	e.setPoolMetrics(key, stages)
+	var exported []appStage
+	for _, stage := range stages {
+		if !e.includeStage(stage) {
+			continue
+		}
+		e.setStageMetrics(key, stage)
+		e.setStagePoolMetrics(key, stage)
+		exported = append(exported, appStage{key, stage})
+		if e.taskDistributions && stage.Status == "ACTIVE" {
+			summary, err := t.scrapeTaskSummary(ctx, key.id, stage)
+			if err != nil {
+				return nil, err
+			}
+			if summary != nil {
+				e.setTaskSummaryMetrics(key, stage, summary)
+			}
+		}
+	}
+	return exported, nil
+}
+
+// scrapeExecutors returns the executors of the application listed by
+// endpoint, executors or allexecutors.
+func (t *target) scrapeExecutors(ctx context.Context, appID, endpoint string) ([]ExecutorInfo, error) {
+	body, err := t.fetch(ctx, "applications/"+url.PathEscape(appID)+"/"+endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return parseExecutors(body)
+}
+
+// newExecutorPeakMemoryMetrics returns the metrics exported with
+// --executor.peak-memory-metrics.
+func newExecutorPeakMemoryMetrics(namespace string, labelNames []string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"peak_jvm_heap_memory_bytes":             newGaugeExecutorMetrics(namespace, "peak_jvm_heap_memory_bytes", "Peak JVM heap memory used by the executor in bytes", labelNames, constLabels),
+		"peak_jvm_off_heap_memory_bytes":         newGaugeExecutorMetrics(namespace, "peak_jvm_off_heap_memory_bytes", "Peak JVM off-heap memory used by the executor in bytes", labelNames, constLabels),
+		"peak_on_heap_execution_memory_bytes":    newGaugeExecutorMetrics(namespace, "peak_on_heap_execution_memory_bytes", "Peak on-heap execution memory used by the executor in bytes", labelNames, constLabels),
+		"peak_off_heap_execution_memory_bytes":   newGaugeExecutorMetrics(namespace, "peak_off_heap_execution_memory_bytes", "Peak off-heap execution memory used by the executor in bytes", labelNames, constLabels),
+		"peak_on_heap_storage_memory_bytes":      newGaugeExecutorMetrics(namespace, "peak_on_heap_storage_memory_bytes", "Peak on-heap storage memory used by the executor in bytes", labelNames, constLabels),
+		"peak_off_heap_storage_memory_bytes":     newGaugeExecutorMetrics(namespace, "peak_off_heap_storage_memory_bytes", "Peak off-heap storage memory used by the executor in bytes", labelNames, constLabels),
+		"peak_direct_pool_memory_bytes":          newGaugeExecutorMetrics(namespace, "peak_direct_pool_memory_bytes", "Peak memory used by the direct buffer pool of the executor in bytes", labelNames, constLabels),
+		"peak_mapped_pool_memory_bytes":          newGaugeExecutorMetrics(namespace, "peak_mapped_pool_memory_bytes", "Peak memory used by the mapped buffer pool of the executor in bytes", labelNames, constLabels),
+		"peak_process_tree_jvm_rss_memory_bytes": newGaugeExecutorMetrics(namespace, "peak_process_tree_jvm_rss_memory_bytes", "Peak resident set size of the executor JVM process tree in bytes", labelNames, constLabels),
+	}
+}
+
+func (e *Exporter) setExecutorMetrics(key appKey, ex ExecutorInfo) {
+	labels := e.executorLabelValues(key, ex)
+	e.executorGaugeMetrics["active_tasks"].WithLabelValues(labels...).Set(float64(ex.ActiveTasks))
+	e.executorGaugeMetrics["memory_used_bytes"].WithLabelValues(labels...).Set(float64(ex.MemoryUsed))
+	e.executorGaugeMetrics["max_memory_bytes"].WithLabelValues(labels...).Set(float64(ex.MaxMemory))
+	e.executorGaugeMetrics["disk_used_bytes"].WithLabelValues(labels...).Set(float64(ex.DiskUsed))
+	e.executorGaugeMetrics["rdd_blocks"].WithLabelValues(labels...).Set(float64(ex.RddBlocks))
+	e.executorGaugeMetrics["total_cores"].WithLabelValues(labels...).Set(float64(ex.TotalCores))
+	e.executorGaugeMetrics["max_tasks"].WithLabelValues(labels...).Set(float64(ex.MaxTasks))
+	excluded := 0.0
+	if ex.excluded() {
+		excluded = 1
+	}
+	e.executorGaugeMetrics["excluded"].WithLabelValues(labels...).Set(excluded)
+	if e.executorLogsLabel {
+		e.setExecutorLogsMetric(labels, ex)
+	}
+	if t, err := parseSparkTime(ex.AddTime); err == nil {
+		e.executorGaugeMetrics["add_time_seconds"].WithLabelValues(labels...).Set(timeSeconds(t))
+	}
+	if m := ex.MemoryMetrics; m != nil {
+		e.executorGaugeMetrics["on_heap_storage_memory_used_bytes"].WithLabelValues(labels...).Set(float64(m.UsedOnHeapStorageMemory))
+		e.executorGaugeMetrics["off_heap_storage_memory_used_bytes"].WithLabelValues(labels...).Set(float64(m.UsedOffHeapStorageMemory))
+		e.executorGaugeMetrics["on_heap_storage_memory_total_bytes"].WithLabelValues(labels...).Set(float64(m.TotalOnHeapStorageMemory))
+		e.executorGaugeMetrics["off_heap_storage_memory_total_bytes"].WithLabelValues(labels...).Set(float64(m.TotalOffHeapStorageMemory))
+	}
+	if m := ex.PeakMemoryMetrics; m != nil && e.peakMemoryMetrics {
+		e.executorGaugeMetrics["peak_jvm_heap_memory_bytes"].WithLabelValues(labels...).Set(float64(m.JVMHeapMemory))
+		e.executorGaugeMetrics["peak_jvm_off_heap_memory_bytes"].WithLabelValues(labels...).Set(float64(m.JVMOffHeapMemory))
+		e.executorGaugeMetrics["peak_on_heap_execution_memory_bytes"].WithLabelValues(labels...).Set(float64(m.OnHeapExecutionMemory))
+		e.executorGaugeMetrics["peak_off_heap_execution_memory_bytes"].WithLabelValues(labels...).Set(float64(m.OffHeapExecutionMemory))
+		e.executorGaugeMetrics["peak_on_heap_storage_memory_bytes"].WithLabelValues(labels...).Set(float64(m.OnHeapStorageMemory))
+		e.executorGaugeMetrics["peak_off_heap_storage_memory_bytes"].WithLabelValues(labels...).Set(float64(m.OffHeapStorageMemory))
+		e.executorGaugeMetrics["peak_direct_pool_memory_bytes"].WithLabelValues(labels...).Set(float64(m.DirectPoolMemory))
+		e.executorGaugeMetrics["peak_mapped_pool_memory_bytes"].WithLabelValues(labels...).Set(float64(m.MappedPoolMemory))
+		e.executorGaugeMetrics["peak_process_tree_jvm_rss_memory_bytes"].WithLabelValues(labels...).Set(float64(m.ProcessTreeJVMRSSMemory))
+	}
+}
+
+// exportExecutor reports whether the metrics of ex are exported.
+func (e *Exporter) exportExecutor(ex ExecutorInfo) bool {
+	if e.keepDriver && ex.ID == driverExecutorID {
+		return true
+	}
+	return e.executorFilter.match(ex.ID)
+}
+
+func applicationLabelValues(key appKey, app ApplicationMetrics) []string {
+	name := app.Name
+	if name == "" {
+		name = app.ID
+	}
+	return append(key.labelValues(), name)
+}
 
+func (e *Exporter) executorLabelValues(key appKey, ex ExecutorInfo) []string {
+	labels := append(key.labelValues(), ex.ID)
+	if e.hostPortLabel {
+		labels = append(labels, ex.HostPort)
+	}
+	if e.allExecutors {
+		labels = append(labels, strconv.FormatBool(ex.IsActive))
+	}
+	return labels
+}
+
+// parseApplications decodes the applications listed in r one at a time,
+// keeping only those selected by keep, so that the listing of a History
+// Server holding thousands of applications is never held in memory at once.
+func parseApplications(r io.Reader, keep func(ApplicationMetrics) bool) (ClusterApplicationsInfo, error) {
+	var info ClusterApplicationsInfo
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '['); err != nil {
+		return info, newDecodeError("applications", err)
+	}
+	for dec.More() {
+		var app ApplicationMetrics
+		if err := dec.Decode(&app); err != nil {
+			return info, newDecodeError("applications", err)
+		}
+		info.Listed++
+		if keep(app) {
+			info.Applications = append(info.Applications, app)
+		}
+	}
+	if err := expectDelim(dec, ']'); err != nil {
+		return info, newDecodeError("applications", err)
+	}
+	return info, nil
+}
+
+// expectDelim reads the next token of dec, failing unless it is delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v, got %v", delim, tok)
+	}
+	return nil
+}
+
+func parseExecutors(r io.Reader) ([]ExecutorInfo, error) {
+	var executors []ExecutorInfo
+	if err := json.NewDecoder(r).Decode(&executors); err != nil {
+		return nil, newDecodeError("executors", err)
+	}
+	return executors, nil
+}
+
+// gaugeMetrics returns all the gauge vectors set while scraping.
+func (e *Exporter) gaugeMetrics() []*prometheus.GaugeVec {
+	var metrics []*prometheus.GaugeVec
+	for _, group := range []map[string]*prometheus.GaugeVec{
+		e.executorGaugeMetrics,
+		e.removedExecutorGaugeMetrics,
+		e.driverGaugeMetrics,
+		e.applicationGaugeMetrics,
+		e.attemptGaugeMetrics,
+		e.jobGaugeMetrics,
+		e.stageGaugeMetrics,
+		e.poolGaugeMetrics,
+		e.stageQuantileMetrics,
+		e.rddGaugeMetrics,
+		e.streamingGaugeMetrics,
+		e.sqlGaugeMetrics,
+		e.masterGaugeMetrics,
+		e.yarnATSGaugeMetrics,
+	} {
+		for _, m := range group {
+			metrics = append(metrics, m)
+		}
+	}
+	return append(metrics, e.applicationInfo, e.applicationsDiscovered, e.applicationsScraped)
+}
+
+func (e *Exporter) resetMetrics() {
+	e.up.Reset()
+	for _, m := range e.gaugeMetrics() {
+		m.Reset()
+	}
+	e.applications = nil
+	e.stages = nil
+	e.failedTargets = nil
+}
+
+func (e *Exporter) collectMetrics(ch chan<- prometheus.Metric) {
+	for _, m := range e.gaugeMetrics() {
+		m.Collect(ch)
+	}
+	for _, app := range e.applications {
+		for _, ex := range app.Executors {
+			labels := e.executorLabelValues(appKey{app.Endpoint, app.ID}, ex)
+			for _, m := range e.executorCounterMetrics {
+				ch <- prometheus.MustNewConstMetric(m.desc, prometheus.CounterValue, m.value(ex), labels...)
+			}
+		}
+	}
+	e.collectStageCounters(ch)
+	if e.taskDurations != nil {
+		e.taskDurations.Collect(ch)
+	}
 }
 
 // ClusterApplicationsInfo holds all applications metrics
 type ClusterApplicationsInfo struct {
+	// Listed is the number of applications listed, Applications holding
+	// only the selected ones.
+	Listed       int
 	Applications []ApplicationMetrics
 }
 
+// ApplicationMetrics holds an application as returned by the applications
+// endpoint
+type ApplicationMetrics struct {
+	Attempts []ApplicationAttempt `json:"attempts"`
+	ID       string               `json:"id"`
+	Name     string               `json:"name"`
+}
+
+// ApplicationAttempt holds the information of a single application attempt
+type ApplicationAttempt struct {
+	AttemptID      string `json:"attemptId"`
+	Completed      bool   `json:"completed"`
+	Duration       int64  `json:"duration"`
+	EndTime        string `json:"endTime"`
+	EndTimeEpoch   int64  `json:"endTimeEpoch"`
+	SparkUser      string `json:"sparkUser"`
+	StartTime      string `json:"startTime"`
+	StartTimeEpoch int64  `json:"startTimeEpoch"`
+}
+
 // ApplicationInfo holds all application metrics including executors information
 type ApplicationInfo struct {
-	Attempts []struct {
-		Completed bool   `json:"completed"`
-		EndTime   string `json:"endTime"`
-		SparkUser string `json:"sparkUser"`
-		StartTime string `json:"startTime"`
-	} `json:"attempts"`
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Executors []ExecutorMetrics
+	ApplicationMetrics
+	Endpoint  string         `json:"-"`
+	Executors []ExecutorInfo `json:"-"`
 }
 
 // ExecutorInfo holds all executor metrics it's used on each application
 type ExecutorInfo struct {
-	ActiveTasks    int `json:"activeTasks"`
-	CompletedTasks int `json:"completedTasks"`
-	DiskUsed       int `json:"diskUsed"`
+	ActiveTasks    int    `json:"activeTasks"`
+	AddTime        string `json:"addTime"`
+	CompletedTasks int    `json:"completedTasks"`
+	DiskUsed       int64  `json:"diskUsed"`
 	ExecutorLogs   struct {
 		Stderr string `json:"stderr"`
 		Stdout string `json:"stdout"`
 	} `json:"executorLogs"`
-	FailedTasks       int    `json:"failedTasks"`
-	HostPort          string `json:"hostPort"`
-	ID                string `json:"id"`
-	MaxMemory         int64  `json:"maxMemory"`
-	MemoryUsed        int    `json:"memoryUsed"`
-	RddBlocks         int    `json:"rddBlocks"`
-	TotalDuration     int    `json:"totalDuration"`
-	TotalInputBytes   int    `json:"totalInputBytes"`
-	TotalShuffleRead  int    `json:"totalShuffleRead"`
-	TotalShuffleWrite int    `json:"totalShuffleWrite"`
+	FailedTasks int    `json:"failedTasks"`
+	HostPort    string `json:"hostPort"`
+	ID          string `json:"id"`
+	IsActive    bool   `json:"isActive"`
+	// Spark 3.1 renamed the blacklisted fields to excluded.
+	IsBlacklisted       bool  `json:"isBlacklisted"`
+	BlacklistedInStages []int `json:"blacklistedInStages"`
+	IsExcluded          bool  `json:"isExcluded"`
+	ExcludedInStages    []int `json:"excludedInStages"`
+	MaxMemory           int64 `json:"maxMemory"`
+	MaxTasks            int   `json:"maxTasks"`
+	MemoryUsed          int64 `json:"memoryUsed"`
+	// MemoryMetrics is missing from the responses of older Spark versions.
+	MemoryMetrics *ExecutorMemoryMetrics `json:"memoryMetrics"`
+	// PeakMemoryMetrics is only returned by Spark 3 and later.
+	PeakMemoryMetrics *ExecutorPeakMemoryMetrics `json:"peakMemoryMetrics"`
+	RddBlocks         int                        `json:"rddBlocks"`
+	// RemoveTime and RemoveReason are only set for removed executors,
+	// returned by the allexecutors endpoint.
+	RemoveTime        string `json:"removeTime"`
+	RemoveReason      string `json:"removeReason"`
+	TotalCores        int    `json:"totalCores"`
+	TotalDuration     int64  `json:"totalDuration"`
+	TotalGCTime       int64  `json:"totalGCTime"`
+	TotalInputBytes   int64  `json:"totalInputBytes"`
+	TotalShuffleRead  int64  `json:"totalShuffleRead"`
+	TotalShuffleWrite int64  `json:"totalShuffleWrite"`
 	TotalTasks        int    `json:"totalTasks"`
 }
 
+// excluded reports whether Spark stopped scheduling tasks on the executor,
+// for the whole application or for some of its stages.
+func (ex ExecutorInfo) excluded() bool {
+	return ex.IsBlacklisted || ex.IsExcluded || len(ex.BlacklistedInStages) > 0 || len(ex.ExcludedInStages) > 0
+}
+
+// ExecutorMemoryMetrics holds the storage memory of an executor, split
+// between on-heap and off-heap memory.
+type ExecutorMemoryMetrics struct {
+	UsedOnHeapStorageMemory   int64 `json:"usedOnHeapStorageMemory"`
+	UsedOffHeapStorageMemory  int64 `json:"usedOffHeapStorageMemory"`
+	TotalOnHeapStorageMemory  int64 `json:"totalOnHeapStorageMemory"`
+	TotalOffHeapStorageMemory int64 `json:"totalOffHeapStorageMemory"`
+}
+
+// ExecutorPeakMemoryMetrics holds the peak memory used by an executor. Only
+// the exported subset of the fields returned by Spark is decoded.
+type ExecutorPeakMemoryMetrics struct {
+	JVMHeapMemory           int64 `json:"JVMHeapMemory"`
+	JVMOffHeapMemory        int64 `json:"JVMOffHeapMemory"`
+	OnHeapExecutionMemory   int64 `json:"OnHeapExecutionMemory"`
+	OffHeapExecutionMemory  int64 `json:"OffHeapExecutionMemory"`
+	OnHeapStorageMemory     int64 `json:"OnHeapStorageMemory"`
+	OffHeapStorageMemory    int64 `json:"OffHeapStorageMemory"`
+	DirectPoolMemory        int64 `json:"DirectPoolMemory"`
+	MappedPoolMemory        int64 `json:"MappedPoolMemory"`
+	ProcessTreeJVMRSSMemory int64 `json:"ProcessTreeJVMRSSMemory"`
+}
+
+// metricsHandler returns a handler serving the metrics of the default
+// registry together with the ones of the exporter returned by exporter,
+// scraped for the lifetime of each request.
+func metricsHandler(exporter func() *Exporter, processMetrics prometheus.Gatherer, timeoutOffset time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := scrapeContext(r, timeoutOffset)
+		defer cancel()
+		e := exporter()
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(e.WithContext(ctx))
+		// The exporter is gathered first, so that the scrape is already
+		// observed by the histogram of the process metrics.
+		gatherers := prometheus.Gatherers{e.gatherer(registry), processMetrics}
+		promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// scrapeContext returns the context of the scrape requested by r. It is done
+// timeoutOffset before the scrape timeout sent by Prometheus in the
+// X-Prometheus-Scrape-Timeout-Seconds header, so that the exporter answers
+// before Prometheus gives up on the scrape.
+func scrapeContext(r *http.Request, timeoutOffset time.Duration) (context.Context, context.CancelFunc) {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return context.WithCancel(r.Context())
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		log.Debugf("Ignoring invalid scrape timeout %q", header)
+		return context.WithCancel(r.Context())
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > timeoutOffset {
+		timeout -= timeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// gatherer returns the gatherer serving the metrics of registry, where the
+// exporter is registered.
+func (e *Exporter) gatherer(registry *prometheus.Registry) prometheus.Gatherer {
+	g := e.targetLabelGatherer(registry)
+	if e.appInName {
+		return appInNameGatherer(g, e.namespace)
+	}
+	return g
+}
+
+// setupLogging sets the level and format of the messages logged by logger.
+func setupLogging(logger *log.Logger, level, format string) error {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q: %v", level, err)
+	}
+	logger.SetLevel(lvl)
+	switch format {
+	case "logfmt":
+		logger.SetFormatter(&log.TextFormatter{DisableColors: true, FullTimestamp: true})
+	case "json":
+		logger.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format %q: must be logfmt or json", format)
+	}
+	return nil
+}
+
 func main() {
+	cfg := DefaultConfig()
+	registerConfigFlags(flag.CommandLine, cfg)
 	var (
-		listenAddress       = flag.String("web.listen-address", ":9110", "Address to listen on for web interface and telemetry.")
-		metricsPath         = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
-		sparkApplicationURI = flag.String("spark.application-uri", "http://localhost:4040", "URI on which to scrape Spark application metrics")
-		sparkTimeout        = flag.Duration("spark.timeout", 5*time.Second, "Timeout for trying to get stats from Spark application")
+		configFile         = flag.String("config.file", "", "YAML file holding the scrape settings, overridden by the flags set on the command line")
+		listenAddress      = flag.String("web.listen-address", ":9110", "Address to listen on for web interface and telemetry, host:port or unix:<path> for a Unix domain socket.")
+		metricsPath        = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+		webTLSCertFile     = flag.String("web.tls-cert-file", "", "Certificate file to serve metrics over HTTPS")
+		webTLSKeyFile      = flag.String("web.tls-key-file", "", "Key file to serve metrics over HTTPS")
+		webTLSClientCAFile = flag.String("web.tls-client-ca-file", "", "CA certificate file used to require and verify client certificates")
+		shutdownTimeout    = flag.Duration("web.shutdown-timeout", 30*time.Second, "Time to wait for active requests to complete when shutting down")
+		logLevel           = flag.String("log.level", "info", "Only log messages with the given severity or above, one of debug, info, warn, error or fatal")
+		logFormat          = flag.String("log.format", "logfmt", "Output format of log messages, one of logfmt or json")
+		timeoutOffset      = flag.Duration("web.timeout-offset", 500*time.Millisecond, "Time subtracted from the scrape timeout sent by Prometheus to bound the time taken by a scrape")
+		dryRunFlag         = flag.Bool("dry-run", false, "Scrape the targets once, print the metrics on stdout and exit, with a non-zero status if a scrape failed")
+		maxRequests        = flag.Int("web.max-requests", 0, "Maximum number of concurrent scrape requests, the others being answered with 429, 0 for no limit")
+		failOnStartupError = flag.Bool("spark.fail-on-startup-error", false, "Exit when the scrape made on startup fails, instead of logging a warning")
+		enablePprof        = flag.Bool("web.enable-pprof", false, "Serve the Go profiling endpoints under /debug/pprof/")
+		disableRuntime     = flag.Bool("web.disable-exporter-metrics", false, "Leave the Go runtime and process metrics of the exporter, go_* and process_*, out of the metrics served")
+		readyThreshold     = flag.Int("web.ready-failure-threshold", 3, "Number of consecutive failed scrapes after which /-/ready reports the exporter as not ready, 0 to never")
+		showVersion        = flag.Bool("version", false, "Print version information and exit")
 	)
 	flag.Parse()
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version.Print("spark_exporter"))
+		return
+	}
+	if err := setupLogging(log.StandardLogger(), *logLevel, *logFormat); err != nil {
+		log.Fatal(err)
+	}
 
 	log.Infoln("Starting spark_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	exporter, err := NewExporter(*sparkApplicationURI, *sparkTimeout)
+	if *configFile != "" {
+		var err error
+		cfg, err = LoadConfig(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := overrideConfig(cfg, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+	}
+	readiness := newReadiness(*readyThreshold)
+	reloader, err := newReloader(cfg, *configFile, os.Args[1:], readiness)
 	if err != nil {
 		log.Fatal(err)
 	}
-	prometheus.MustRegister(exporter)
-	prometheus.MustRegister(version.NewCollector("spark_exporter"))
+	if *dryRunFlag {
+		if err := dryRun(reloader.Exporter(), os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := checkStartup(reloader.Exporter(), *failOnStartupError, log.StandardLogger()); err != nil {
+		log.Fatal(err)
+	}
 
-	log.Infoln("Listening on", *listenAddress)
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-             <head><title>Spark Exporter</title></head>
-             <body>
-             <h1>Spark Exporter</h1>
-             <p><a href='` + *metricsPath + `'>Metrics</a></p>
-             </body>
-             </html>`))
-	})
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	go scrapeInBackground(reloader, nil)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloader.reload(); err != nil {
+				log.Errorf("Error reloading config: %v", err)
+				continue
+			}
+			log.Infoln("Reloaded config file", *configFile)
+		}
+	}()
+
+	webOpts := WebOptions{
+		ListenAddress:   *listenAddress,
+		MetricsPath:     *metricsPath,
+		TLSCertFile:     *webTLSCertFile,
+		TLSKeyFile:      *webTLSKeyFile,
+		TLSClientCAFile: *webTLSClientCAFile,
+		ShutdownTimeout: *shutdownTimeout,
+		TimeoutOffset:   *timeoutOffset,
+		MaxRequests:     *maxRequests,
+		EnablePprof:     *enablePprof,
 
+		DisableExporterMetrics: *disableRuntime,
+	}
+	server, err := newServer(webOpts, newMux(webOpts, reloader, readiness))
+	if err != nil {
+		log.Fatal(err)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	log.Infoln("Listening on", *listenAddress)
+	if err := run(server, webOpts, stop); err != nil {
+		log.Fatal(err)
+	}
+	log.Infoln("Shut down")
 }
@@ -0,0 +1,1402 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// sparkServer is a fake Spark REST API answering the paths of routes,
+// relative to /api/v1 and without their query, with their bodies, and the
+// other paths with 404. It records the requests it gets.
+type sparkServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	routes   map[string]string
+	requests []string
+}
+
+func newSparkServer(t *testing.T, routes map[string]string) *sparkServer {
+	t.Helper()
+	s := &sparkServer{routes: map[string]string{}}
+	for path, body := range routes {
+		s.routes[path] = body
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requests = append(s.requests, r.URL.RequestURI())
+		body, ok := s.routes[strings.TrimPrefix(r.URL.Path, "/api/v1/")]
+		s.mutex.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// setRoute answers path with body from now on.
+func (s *sparkServer) setRoute(path, body string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.routes[path] = body
+}
+
+// requested returns the number of requests made to path, relative to
+// /api/v1 and including its query if any.
+func (s *sparkServer) requested(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := 0
+	for _, r := range s.requests {
+		if r == "/api/v1/"+path {
+			n++
+		}
+	}
+	return n
+}
+
+// newTestExporter returns an exporter of uris with opts.
+func newTestExporter(t *testing.T, uris []string, opts Options) *Exporter {
+	t.Helper()
+	e, err := NewExporter(uris, FetchOptions{}, opts)
+	if err != nil {
+		t.Fatalf("NewExporter: %v", err)
+	}
+	return e
+}
+
+// gather scrapes e and returns the gathered metric families by name.
+func gather(t *testing.T, e *Exporter) map[string]*dto.MetricFamily {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	mfs, err := e.gatherer(registry).Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	families := map[string]*dto.MetricFamily{}
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf
+	}
+	return families
+}
+
+// findMetric returns the metric of mf whose labels include labels, nil if
+// there is none.
+func findMetric(mf *dto.MetricFamily, labels map[string]string) *dto.Metric {
+	if mf == nil {
+		return nil
+	}
+	for _, m := range mf.Metric {
+		matched := 0
+		for _, l := range m.Label {
+			if v, ok := labels[l.GetName()]; ok && v == l.GetValue() {
+				matched++
+			}
+		}
+		if matched == len(labels) {
+			return m
+		}
+	}
+	return nil
+}
+
+// metricValue returns the value of the gauge or counter of the family name
+// whose labels include labels, and whether it was found.
+func metricValue(families map[string]*dto.MetricFamily, name string, labels map[string]string) (float64, bool) {
+	m := findMetric(families[name], labels)
+	switch {
+	case m == nil:
+		return 0, false
+	case m.Gauge != nil:
+		return m.Gauge.GetValue(), true
+	case m.Counter != nil:
+		return m.Counter.GetValue(), true
+	case m.Untyped != nil:
+		return m.Untyped.GetValue(), true
+	}
+	return 0, false
+}
+
+// series is a single value expected to be exported.
+type series struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// checkSeries reports the series of want that families doesn't export with
+// their value.
+func checkSeries(t *testing.T, families map[string]*dto.MetricFamily, want []series) {
+	t.Helper()
+	for _, m := range want {
+		if got, ok := metricValue(families, m.name, m.labels); !ok || got != m.value {
+			t.Errorf("got %s%v %v (exported: %v), want %v", m.name, m.labels, got, ok, m.value)
+		}
+	}
+}
+
+// appRoutes returns routes completed with the listing of testApplications
+// and empty answers for the endpoints of app-1 they leave out.
+func appRoutes(routes map[string]string) map[string]string {
+	all := map[string]string{
+		"applications":                   testApplications,
+		"applications/app-1/executors":   `[]`,
+		"applications/app-1/jobs":        `[]`,
+		"applications/app-1/stages":      `[]`,
+		"applications/app-1/storage/rdd": `[]`,
+		"applications/app-1/environment": `{}`,
+	}
+	for path, body := range routes {
+		all[path] = body
+	}
+	return all
+}
+
+const testApplications = `[{"id": "app-1", "name": "etl", "attempts": [{"startTime": "2021-01-01T00:00:00.000GMT", "endTime": "1969-12-31T23:59:59.999GMT", "lastUpdated": "2021-01-01T00:00:00.000GMT", "duration": 0, "sparkUser": "spark", "completed": false}]}]`
+
+func TestParseSparkURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		wantErr string
+	}{
+		{uri: "http://localhost:4040"},
+		{uri: "https://driver:4040/proxy"},
+		{uri: "localhost:4040", wantErr: "invalid spark URI: missing scheme"},
+		{uri: "ftp://localhost:4040", wantErr: `invalid spark URI: unsupported scheme "ftp"`},
+		{uri: "http://", wantErr: "invalid spark URI: missing host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			_, err := parseSparkURI(tt.uri)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("parseSparkURI(%q): %v", tt.uri, err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("parseSparkURI(%q) = %v, want error %q", tt.uri, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// applicationsSample is a response of the applications endpoint of a Spark
+// 3.1 driver.
+const applicationsSample = `[ {
+  "id" : "app-20210105101010-0001",
+  "name" : "etl",
+  "attempts" : [ {
+    "startTime" : "2021-01-05T10:10:10.123GMT",
+    "endTime" : "1969-12-31T23:59:59.999GMT",
+    "lastUpdated" : "2021-01-05T10:10:10.123GMT",
+    "duration" : 0,
+    "sparkUser" : "spark",
+    "completed" : false,
+    "appSparkVersion" : "3.1.1",
+    "startTimeEpoch" : 1609841410123,
+    "endTimeEpoch" : -1,
+    "lastUpdatedEpoch" : 1609841410123
+  } ]
+}, {
+  "id" : "app-20210105090000-0000",
+  "name" : "report",
+  "attempts" : [ {
+    "attemptId" : "1",
+    "startTime" : "2021-01-05T09:00:00.000GMT",
+    "endTime" : "2021-01-05T09:30:00.000GMT",
+    "lastUpdated" : "2021-01-05T09:30:00.000GMT",
+    "duration" : 1800000,
+    "sparkUser" : "analyst",
+    "completed" : true,
+    "appSparkVersion" : "3.1.1",
+    "startTimeEpoch" : 1609837200000,
+    "endTimeEpoch" : 1609839000000,
+    "lastUpdatedEpoch" : 1609839000000
+  } ]
+} ]`
+
+func TestParseApplications(t *testing.T) {
+	etl := ApplicationMetrics{
+		ID:   "app-20210105101010-0001",
+		Name: "etl",
+		Attempts: []ApplicationAttempt{{
+			StartTime:      "2021-01-05T10:10:10.123GMT",
+			StartTimeEpoch: 1609841410123,
+			EndTime:        "1969-12-31T23:59:59.999GMT",
+			EndTimeEpoch:   -1,
+			SparkUser:      "spark",
+		}},
+	}
+	report := ApplicationMetrics{
+		ID:   "app-20210105090000-0000",
+		Name: "report",
+		Attempts: []ApplicationAttempt{{
+			AttemptID:      "1",
+			StartTime:      "2021-01-05T09:00:00.000GMT",
+			StartTimeEpoch: 1609837200000,
+			EndTime:        "2021-01-05T09:30:00.000GMT",
+			EndTimeEpoch:   1609839000000,
+			Duration:       1800000,
+			SparkUser:      "analyst",
+			Completed:      true,
+		}},
+	}
+	tests := []struct {
+		name    string
+		body    string
+		want    ClusterApplicationsInfo
+		wantErr bool
+	}{
+		{
+			name: "captured",
+			body: applicationsSample,
+			want: ClusterApplicationsInfo{Listed: 2, Applications: []ApplicationMetrics{etl, report}},
+		},
+		{
+			name: "none",
+			body: `[]`,
+			want: ClusterApplicationsInfo{},
+		},
+		{
+			name:    "not a list",
+			body:    `{"id": "app-1"}`,
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			body:    applicationsSample[:100],
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseApplications(strings.NewReader(tt.body), func(ApplicationMetrics) bool { return true })
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseApplications succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseApplications: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseApplications = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutorMemory(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		// The max memory of the executor is beyond 32 bits.
+		"applications/app-1/executors": `[
+			{"id": "driver", "memoryUsed": 1048576, "maxMemory": 455501414},
+			{"id": "1", "memoryUsed": 3221225472, "maxMemory": 8589934592}]`,
+	}))
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{})), []series{
+		{"spark_executor_memory_used_bytes", map[string]string{"executor_id": "driver"}, 1048576},
+		{"spark_executor_max_memory_bytes", map[string]string{"executor_id": "driver"}, 455501414},
+		{"spark_executor_memory_used_bytes", map[string]string{"executor_id": "1"}, 3221225472},
+		{"spark_executor_max_memory_bytes", map[string]string{"executor_id": "1"}, 8589934592},
+	})
+}
+
+func TestExecutorDiskUsed(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[
+			{"id": "1", "diskUsed": 0},
+			{"id": "2", "diskUsed": 53687091200}]`,
+	}))
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{})), []series{
+		{"spark_executor_disk_used_bytes", map[string]string{"executor_id": "1"}, 0},
+		{"spark_executor_disk_used_bytes", map[string]string{"executor_id": "2"}, 53687091200},
+	})
+}
+
+func TestExecutorTaskCounters(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "1", "failedTasks": 2, "totalTasks": 40}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{}))
+	checkSeries(t, families, []series{
+		{"spark_executor_failed_tasks", map[string]string{"executor_id": "1"}, 2},
+		{"spark_executor_total_tasks", map[string]string{"executor_id": "1"}, 40},
+	})
+	for _, name := range []string{"spark_executor_failed_tasks", "spark_executor_total_tasks"} {
+		if got := families[name].GetType(); got != dto.MetricType_COUNTER {
+			t.Errorf("%s is a %v, want a counter", name, got)
+		}
+	}
+}
+
+func TestExecutorCounters(t *testing.T) {
+	s := newSparkServer(t, appRoutes(nil))
+	e := newTestExporter(t, []string{s.URL}, Options{})
+	labels := map[string]string{"executor_id": "1"}
+	for _, tt := range []struct {
+		executors string
+		want      map[string]float64
+	}{
+		{
+			executors: `[{"id": "1", "completedTasks": 10, "failedTasks": 1, "totalTasks": 11}]`,
+			want:      map[string]float64{"spark_executor_completed_tasks": 10, "spark_executor_failed_tasks": 1, "spark_executor_total_tasks": 11},
+		},
+		{
+			executors: `[{"id": "1", "completedTasks": 25, "failedTasks": 3, "totalTasks": 28}]`,
+			want:      map[string]float64{"spark_executor_completed_tasks": 25, "spark_executor_failed_tasks": 3, "spark_executor_total_tasks": 28},
+		},
+	} {
+		s.setRoute("applications/app-1/executors", tt.executors)
+		families := gather(t, e)
+		for name, want := range tt.want {
+			if families[name].GetType() != dto.MetricType_COUNTER {
+				t.Errorf("%s is a %v, want a counter", name, families[name].GetType())
+			}
+			if got, ok := metricValue(families, name, labels); !ok || got != want {
+				t.Errorf("got %s %v (exported: %v), want %v", name, got, ok, want)
+			}
+		}
+	}
+}
+
+func TestExecutorShuffle(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[
+			{"id": "1", "totalShuffleRead": 1024, "totalShuffleWrite": 2048},
+			{"id": "2", "totalShuffleRead": -1},
+			{"id": "3"}]`,
+	}))
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{})), []series{
+		{"spark_executor_shuffle_read_bytes", map[string]string{"executor_id": "1"}, 1024},
+		{"spark_executor_shuffle_write_bytes", map[string]string{"executor_id": "1"}, 2048},
+		// Negative and missing values are exported as 0.
+		{"spark_executor_shuffle_read_bytes", map[string]string{"executor_id": "2"}, 0},
+		{"spark_executor_shuffle_write_bytes", map[string]string{"executor_id": "2"}, 0},
+		{"spark_executor_shuffle_read_bytes", map[string]string{"executor_id": "3"}, 0},
+		{"spark_executor_shuffle_write_bytes", map[string]string{"executor_id": "3"}, 0},
+	})
+}
+
+func TestExecutorMemoryMetrics(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[
+			{"id": "1", "memoryMetrics": {"usedOnHeapStorageMemory": 1024, "usedOffHeapStorageMemory": 256,
+				"totalOnHeapStorageMemory": 4096, "totalOffHeapStorageMemory": 2048}},
+			{"id": "2"}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{}))
+	checkSeries(t, families, []series{
+		{"spark_executor_on_heap_storage_memory_used_bytes", map[string]string{"executor_id": "1"}, 1024},
+		{"spark_executor_off_heap_storage_memory_used_bytes", map[string]string{"executor_id": "1"}, 256},
+		{"spark_executor_on_heap_storage_memory_total_bytes", map[string]string{"executor_id": "1"}, 4096},
+		{"spark_executor_off_heap_storage_memory_total_bytes", map[string]string{"executor_id": "1"}, 2048},
+		{"spark_executor_memory_used_bytes", map[string]string{"executor_id": "2"}, 0},
+	})
+	// The executor of an older Spark version without the memoryMetrics
+	// block has none of these metrics.
+	for _, name := range []string{
+		"spark_executor_on_heap_storage_memory_used_bytes",
+		"spark_executor_off_heap_storage_memory_used_bytes",
+		"spark_executor_on_heap_storage_memory_total_bytes",
+		"spark_executor_off_heap_storage_memory_total_bytes",
+	} {
+		if m := findMetric(families[name], map[string]string{"executor_id": "2"}); m != nil {
+			t.Errorf("got %s for the executor without memory metrics: %v", name, m)
+		}
+	}
+}
+
+func TestExecutorPeakMemoryMetrics(t *testing.T) {
+	// An executor of Spark 3.1, with the fields of peakMemoryMetrics that
+	// aren't exported.
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "1", "peakMemoryMetrics": {
+			"JVMHeapMemory": 629815808, "JVMOffHeapMemory": 97297368,
+			"OnHeapExecutionMemory": 1048576, "OffHeapExecutionMemory": 0,
+			"OnHeapStorageMemory": 2097152, "OffHeapStorageMemory": 0,
+			"OnHeapUnifiedMemory": 3145728, "OffHeapUnifiedMemory": 0,
+			"DirectPoolMemory": 131072, "MappedPoolMemory": 4096,
+			"ProcessTreeJVMVMemory": 0, "ProcessTreeJVMRSSMemory": 873463808,
+			"MinorGCCount": 20, "MinorGCTime": 380, "MajorGCCount": 3, "MajorGCTime": 240}}]`,
+	}))
+	tests := []struct {
+		name              string
+		peakMemoryMetrics bool
+	}{
+		{name: "enabled", peakMemoryMetrics: true},
+		{name: "disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families := gather(t, newTestExporter(t, []string{s.URL}, Options{PeakMemoryMetrics: tt.peakMemoryMetrics}))
+			if !tt.peakMemoryMetrics {
+				for name := range families {
+					if strings.HasPrefix(name, "spark_executor_peak_") {
+						t.Errorf("got %s without --executor.peak-memory-metrics", name)
+					}
+				}
+				return
+			}
+			labels := map[string]string{"executor_id": "1"}
+			checkSeries(t, families, []series{
+				{"spark_executor_peak_jvm_heap_memory_bytes", labels, 629815808},
+				{"spark_executor_peak_jvm_off_heap_memory_bytes", labels, 97297368},
+				{"spark_executor_peak_on_heap_execution_memory_bytes", labels, 1048576},
+				{"spark_executor_peak_off_heap_execution_memory_bytes", labels, 0},
+				{"spark_executor_peak_on_heap_storage_memory_bytes", labels, 2097152},
+				{"spark_executor_peak_off_heap_storage_memory_bytes", labels, 0},
+				{"spark_executor_peak_direct_pool_memory_bytes", labels, 131072},
+				{"spark_executor_peak_mapped_pool_memory_bytes", labels, 4096},
+				{"spark_executor_peak_process_tree_jvm_rss_memory_bytes", labels, 873463808},
+			})
+		})
+	}
+}
+
+func TestExecutorGCTime(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "1", "totalGCTime": 12345}, {"id": "2"}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{}))
+	checkSeries(t, families, []series{
+		{"spark_executor_gc_time_seconds_total", map[string]string{"executor_id": "1"}, 12.345},
+		{"spark_executor_gc_time_seconds_total", map[string]string{"executor_id": "2"}, 0},
+	})
+	if mf := families["spark_executor_gc_time_seconds_total"]; mf.GetType() != dto.MetricType_COUNTER {
+		t.Errorf("got spark_executor_gc_time_seconds_total of type %v, want a counter", mf.GetType())
+	}
+}
+
+func TestExecutorInputBytes(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		// 6 GiB, beyond 32 bits.
+		"applications/app-1/executors": `[{"id": "1", "totalInputBytes": 6442450944}]`,
+	}))
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{})), []series{
+		{"spark_executor_total_input_bytes", map[string]string{"executor_id": "1"}, 6442450944},
+	})
+}
+
+func TestExecutorDuration(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "1", "totalDuration": 125000}]`,
+	}))
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{})), []series{
+		{"spark_executor_total_duration_seconds", map[string]string{"executor_id": "1"}, 125},
+	})
+}
+
+func TestExecutorCores(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		// Older Spark versions don't return maxTasks.
+		"applications/app-1/executors": `[
+			{"id": "1", "isActive": true, "totalCores": 4, "maxTasks": 2},
+			{"id": "2", "isActive": true, "totalCores": 8}]`,
+	}))
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{})), []series{
+		{"spark_executor_total_cores", map[string]string{"executor_id": "1"}, 4},
+		{"spark_executor_max_tasks", map[string]string{"executor_id": "1"}, 2},
+		{"spark_executor_total_cores", map[string]string{"executor_id": "2"}, 8},
+		{"spark_executor_max_tasks", map[string]string{"executor_id": "2"}, 0},
+	})
+}
+
+func TestExecutorExcluded(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		// Spark 3.0 and earlier report blacklisted executors, later
+		// versions excluded ones.
+		"applications/app-1/executors": `[
+			{"id": "1", "isActive": true, "isBlacklisted": false, "blacklistedInStages": [3]},
+			{"id": "2", "isActive": true, "isBlacklisted": true, "blacklistedInStages": []},
+			{"id": "3", "isActive": true, "isExcluded": false, "excludedInStages": [1, 2]},
+			{"id": "4", "isActive": true, "isExcluded": true},
+			{"id": "5", "isActive": true, "isExcluded": false, "excludedInStages": []},
+			{"id": "6", "isActive": true}]`,
+	}))
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{})), []series{
+		{"spark_executor_excluded", map[string]string{"executor_id": "1"}, 1},
+		{"spark_executor_excluded", map[string]string{"executor_id": "2"}, 1},
+		{"spark_executor_excluded", map[string]string{"executor_id": "3"}, 1},
+		{"spark_executor_excluded", map[string]string{"executor_id": "4"}, 1},
+		{"spark_executor_excluded", map[string]string{"executor_id": "5"}, 0},
+		{"spark_executor_excluded", map[string]string{"executor_id": "6"}, 0},
+	})
+}
+
+func TestExecutorRDDBlocks(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[
+			{"id": "driver", "rddBlocks": 0},
+			{"id": "1", "rddBlocks": 12},
+			{"id": "2", "rddBlocks": 7}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{}))
+	checkSeries(t, families, []series{
+		{"spark_executor_rdd_blocks", map[string]string{"executor_id": "driver"}, 0},
+		{"spark_executor_rdd_blocks", map[string]string{"executor_id": "1"}, 12},
+		{"spark_executor_rdd_blocks", map[string]string{"executor_id": "2"}, 7},
+	})
+	if n := len(families["spark_executor_rdd_blocks"].GetMetric()); n != 3 {
+		t.Errorf("got %d spark_executor_rdd_blocks series, want 3", n)
+	}
+}
+
+func TestExecutorHostPortLabel(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "1", "hostPort": "worker-1:37017", "activeTasks": 2, "totalTasks": 9}]`,
+	}))
+	target := strings.TrimPrefix(s.URL, "http://")
+	tests := []struct {
+		name          string
+		hostPortLabel bool
+		want          map[string]string
+	}{
+		{name: "disabled", want: map[string]string{"endpoint": s.URL, "target": target, "app_id": "app-1", "executor_id": "1"}},
+		{name: "enabled", hostPortLabel: true, want: map[string]string{"endpoint": s.URL, "target": target, "app_id": "app-1", "executor_id": "1", "host_port": "worker-1:37017"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families := gather(t, newTestExporter(t, []string{s.URL}, Options{HostPortLabel: tt.hostPortLabel}))
+			for _, name := range []string{"spark_executor_active_tasks", "spark_executor_total_tasks"} {
+				m := findMetric(families[name], tt.want)
+				if m == nil {
+					t.Fatalf("%s%v not exported", name, tt.want)
+				}
+				if len(m.Label) != len(tt.want) {
+					t.Errorf("got %s labels %v, want %v", name, m.Label, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplicationLabelValues(t *testing.T) {
+	tests := []struct {
+		app  ApplicationMetrics
+		want []string
+	}{
+		{ApplicationMetrics{ID: "app-1", Name: "etl"}, []string{"http://driver:4040", "app-1", "etl"}},
+		// Applications of the same name are told apart by their id.
+		{ApplicationMetrics{ID: "app-2", Name: "etl"}, []string{"http://driver:4040", "app-2", "etl"}},
+		{ApplicationMetrics{ID: "app-3"}, []string{"http://driver:4040", "app-3", "app-3"}},
+	}
+	for _, tt := range tests {
+		key := appKey{"http://driver:4040", tt.app.ID}
+		if got := applicationLabelValues(key, tt.app); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("applicationLabelValues(%+v) = %q, want %q", tt.app, got, tt.want)
+		}
+	}
+}
+
+func TestApplicationExecutors(t *testing.T) {
+	s := newSparkServer(t, map[string]string{
+		"applications": `[{"id": "app-1", "name": "etl", "attempts": []}, {"id": "app-2", "name": "etl", "attempts": []}]`,
+		"applications/app-1/allexecutors": `[
+			{"id": "driver", "isActive": true},
+			{"id": "1", "isActive": true},
+			{"id": "2", "isActive": false}]`,
+		"applications/app-2/allexecutors": `[]`,
+		"applications/app-1/jobs":         `[]`,
+		"applications/app-2/jobs":         `[]`,
+		"applications/app-1/stages":       `[]`,
+		"applications/app-2/stages":       `[]`,
+		"applications/app-1/storage/rdd":  `[]`,
+		"applications/app-2/storage/rdd":  `[]`,
+		"applications/app-1/environment":  `{}`,
+		"applications/app-2/environment":  `{}`,
+	})
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{})), []series{
+		{"spark_application_executors_total", map[string]string{"app_id": "app-1", "app_name": "etl"}, 3},
+		{"spark_application_active_executors", map[string]string{"app_id": "app-1", "app_name": "etl"}, 2},
+		{"spark_application_executors_total", map[string]string{"app_id": "app-2", "app_name": "etl"}, 0},
+		{"spark_application_active_executors", map[string]string{"app_id": "app-2", "app_name": "etl"}, 0},
+	})
+}
+
+func TestApplicationTasks(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/allexecutors": `[
+			{"id": "driver", "isActive": true, "activeTasks": 1, "completedTasks": 2},
+			{"id": "1", "isActive": true, "activeTasks": 3, "completedTasks": 10},
+			{"id": "2", "isActive": true, "activeTasks": 4, "completedTasks": 20},
+			{"id": "3", "isActive": false, "completedTasks": 30}]`,
+	}))
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{})), []series{
+		// The driver and the removed executors are summed too.
+		{"spark_application_active_tasks_total", map[string]string{"app_id": "app-1"}, 1 + 3 + 4},
+		{"spark_application_completed_tasks_total", map[string]string{"app_id": "app-1"}, 2 + 10 + 20 + 30},
+		// The executor series are kept.
+		{"spark_executor_active_tasks", map[string]string{"app_id": "app-1", "executor_id": "driver"}, 1},
+		{"spark_executor_active_tasks", map[string]string{"app_id": "app-1", "executor_id": "1"}, 3},
+	})
+}
+
+func TestApplicationsPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		want    string
+		wantErr bool
+	}{
+		{name: "default", want: "applications"},
+		{name: "live", opts: Options{Mode: "live", HistoryStatus: "running"}, want: "applications"},
+		{name: "history", opts: Options{Mode: "history", HistoryStatus: "running"}, want: "applications?status=running"},
+		{name: "history dates", opts: Options{Mode: "history", HistoryStatus: "completed", MinDate: "2015-02-10", MaxDate: "2015-02-12"}, want: "applications?maxDate=2015-02-12&minDate=2015-02-10&status=completed"},
+		{name: "history unfiltered", opts: Options{Mode: "history"}, want: "applications"},
+		{name: "invalid", opts: Options{Mode: "standalone"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.applicationsPath()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want one: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryMode(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "driver", "isActive": true, "activeTasks": 1}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{Mode: "history", HistoryStatus: "running", MinDate: "2015-02-10"}))
+	if n := s.requested("applications?minDate=2015-02-10&status=running"); n != 1 {
+		t.Errorf("got %d filtered requests to the applications listing, want 1", n)
+	}
+	checkSeries(t, families, []series{
+		{"spark_up", nil, 1},
+		{"spark_executor_active_tasks", map[string]string{"app_id": "app-1", "executor_id": "driver"}, 1},
+	})
+}
+
+func TestScrapeErrors(t *testing.T) {
+	unavailable := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		closed     bool
+		fetchOpts  FetchOptions
+		wantReason string
+	}{
+		{name: "status", handler: unavailable, wantReason: "http_status"},
+		{
+			name:       "invalid JSON",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html>")) },
+			wantReason: "decode",
+		},
+		{
+			name:       "connection refused",
+			handler:    func(w http.ResponseWriter, r *http.Request) {},
+			closed:     true,
+			wantReason: "connection",
+		},
+		{
+			name:       "retries exhausted",
+			handler:    unavailable,
+			fetchOpts:  FetchOptions{Retries: 1, RetryBackoff: time.Millisecond},
+			wantReason: "retries_exhausted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+			if tt.closed {
+				server.Close()
+			}
+			e, err := NewExporter([]string{server.URL}, tt.fetchOpts, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for want := 1.0; want <= 2; want++ {
+				families := gather(t, e)
+				if got, _ := metricValue(families, "spark_exporter_scrape_errors_total", map[string]string{"reason": tt.wantReason}); got != want {
+					t.Errorf("got %v errors with reason %s, want %v", got, tt.wantReason, want)
+				}
+				if got, ok := metricValue(families, "spark_up", nil); !ok || got != 0 {
+					t.Errorf("got spark_up %v (exported: %v), want 0", got, ok)
+				}
+				if _, ok := metricValue(families, "spark_exporter_scrape_duration_seconds", nil); !ok {
+					t.Error("spark_exporter_scrape_duration_seconds not exported")
+				}
+			}
+			// Each error is counted under a single reason.
+			var total float64
+			for _, m := range gather(t, e)["spark_exporter_scrape_errors_total"].GetMetric() {
+				total += m.GetCounter().GetValue()
+			}
+			if total != 3 {
+				t.Errorf("got %v scrape errors in all, want 3", total)
+			}
+		})
+	}
+
+	ok := newSparkServer(t, appRoutes(nil))
+	e := newTestExporter(t, []string{ok.URL}, Options{})
+	families := gather(t, e)
+	for _, m := range families["spark_exporter_scrape_errors_total"].GetMetric() {
+		if m.GetCounter().GetValue() != 0 {
+			t.Errorf("got %v scrape errors with %v after a successful scrape, want 0", m.GetCounter().GetValue(), m.Label)
+		}
+	}
+	if got, _ := metricValue(families, "spark_up", nil); got != 1 {
+		t.Errorf("got spark_up %v, want 1", got)
+	}
+}
+
+func TestScrapeTargetsConcurrently(t *testing.T) {
+	// Each target answers the applications listing once both were asked
+	// for it, so that the scrape only completes if they are scraped at the
+	// same time.
+	var listed sync.WaitGroup
+	listed.Add(2)
+	bothListed := make(chan struct{})
+	go func() {
+		listed.Wait()
+		close(bothListed)
+	}()
+	newTarget := func(executors string) *httptest.Server {
+		var once sync.Once
+		s := newSparkServer(t, appRoutes(map[string]string{
+			"applications/app-1/executors": executors,
+		}))
+		handler := s.Config.Handler
+		s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/v1/applications" {
+				once.Do(listed.Done)
+				select {
+				case <-bothListed:
+				case <-time.After(5 * time.Second):
+					http.Error(w, "targets not scraped concurrently", http.StatusServiceUnavailable)
+					return
+				}
+			}
+			handler.ServeHTTP(w, r)
+		})
+		return s.Server
+	}
+	first := newTarget(`[{"id": "driver", "isActive": true, "activeTasks": 1}]`)
+	second := newTarget(`[{"id": "driver", "isActive": true, "activeTasks": 2}]`)
+
+	e := newTestExporter(t, []string{first.URL, second.URL}, Options{TargetConcurrency: 2})
+	families := gather(t, e)
+	checkSeries(t, families, []series{
+		{"spark_up", nil, 1},
+		{"spark_executor_active_tasks", map[string]string{"endpoint": first.URL, "app_id": "app-1", "executor_id": "driver"}, 1},
+		{"spark_executor_active_tasks", map[string]string{"endpoint": second.URL, "app_id": "app-1", "executor_id": "driver"}, 2},
+		{"spark_application_executors_total", map[string]string{"endpoint": first.URL, "app_id": "app-1"}, 1},
+		{"spark_application_executors_total", map[string]string{"endpoint": second.URL, "app_id": "app-1"}, 1},
+	})
+}
+
+func TestNoURI(t *testing.T) {
+	if _, err := NewExporter(nil, FetchOptions{}, Options{}); err == nil {
+		t.Error("NewExporter without URIs succeeded, want an error")
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	s := newSparkServer(t, appRoutes(nil))
+	handler := s.Config.Handler
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/executors") {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+	// The requests are allowed more time than the whole scrape.
+	e, err := NewExporter([]string{s.URL}, FetchOptions{Timeout: time.Minute}, Options{ScrapeTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	families := gather(t, e)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("scrape took %v, want it bounded by the 200ms scrape timeout", elapsed)
+	}
+	if got, _ := metricValue(families, "spark_exporter_scrape_errors_total", map[string]string{"reason": "timeout"}); got != 1 {
+		t.Errorf("got %v scrape errors with reason timeout, want 1", got)
+	}
+}
+
+func TestCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		ttl          time.Duration
+		wait         time.Duration
+		wantRequests int
+		wantHits     float64
+	}{
+		{name: "disabled", wantRequests: 2},
+		{name: "within the TTL", ttl: time.Minute, wantRequests: 1, wantHits: 1},
+		{name: "expired", ttl: 50 * time.Millisecond, wait: 100 * time.Millisecond, wantRequests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSparkServer(t, appRoutes(nil))
+			e := newTestExporter(t, []string{s.URL}, Options{CacheTTL: tt.ttl})
+			gather(t, e)
+			time.Sleep(tt.wait)
+			families := gather(t, e)
+			if n := s.requested("applications"); n != tt.wantRequests {
+				t.Errorf("got %d requests to the driver, want %d", n, tt.wantRequests)
+			}
+			if got, _ := metricValue(families, "spark_exporter_cache_hit_total", nil); got != tt.wantHits {
+				t.Errorf("got %v cache hits, want %v", got, tt.wantHits)
+			}
+			// The cached result is served whole.
+			if got, ok := metricValue(families, "spark_application_executors_total", map[string]string{"app_id": "app-1"}); !ok || got != 0 {
+				t.Errorf("got spark_application_executors_total %v (exported: %v), want 0", got, ok)
+			}
+		})
+	}
+}
+
+func TestUpPerTarget(t *testing.T) {
+	reachable := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "driver", "isActive": true, "activeTasks": 1}]`,
+	}))
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	families := gather(t, newTestExporter(t, []string{reachable.URL, unreachable.URL}, Options{}))
+	checkSeries(t, families, []series{
+		{"spark_up", map[string]string{"endpoint": reachable.URL}, 1},
+		// The unreachable target reports itself down instead of
+		// disappearing, and doesn't prevent the other from being exported.
+		{"spark_up", map[string]string{"endpoint": unreachable.URL}, 0},
+		{"spark_executor_active_tasks", map[string]string{"endpoint": reachable.URL, "executor_id": "driver"}, 1},
+	})
+	if n := len(families["spark_up"].GetMetric()); n != 2 {
+		t.Errorf("got %d spark_up series, want 2", n)
+	}
+}
+
+func TestFailedTargetIsolated(t *testing.T) {
+	var healthy []string
+	for i := 0; i < 2; i++ {
+		s := newSparkServer(t, appRoutes(map[string]string{
+			"applications/app-1/executors": `[{"id": "driver", "isActive": true, "activeTasks": 1}]`,
+		}))
+		healthy = append(healthy, s.URL)
+	}
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	e := newTestExporter(t, append(healthy, failing.URL), Options{})
+	rec := httptest.NewRecorder()
+	metricsHandler(func() *Exporter { return e }, prometheus.NewRegistry(), 0).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	for _, uri := range healthy {
+		target := strings.TrimPrefix(uri, "http://")
+		for _, want := range []string{
+			`spark_up{endpoint="` + uri + `",target="` + target + `"} 1`,
+			`spark_executor_active_tasks{app_id="app-1",endpoint="` + uri + `",executor_id="driver",target="` + target + `"} 1`,
+			`spark_exporter_endpoint_scrape_errors_total{endpoint="` + uri + `",target="` + target + `"} 0`,
+		} {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("the metrics don't contain %q:\n%s", want, rec.Body)
+			}
+		}
+	}
+	target := strings.TrimPrefix(failing.URL, "http://")
+	for _, want := range []string{
+		`spark_up{endpoint="` + failing.URL + `",target="` + target + `"} 0`,
+		`spark_exporter_endpoint_scrape_errors_total{endpoint="` + failing.URL + `",target="` + target + `"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("the metrics don't contain %q:\n%s", want, rec.Body)
+		}
+	}
+}
+
+func TestFailedApplicationIsolated(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications": `[{"id": "app-1", "name": "etl", "attempts": []}, {"id": "app-2", "name": "etl", "attempts": []}, {"id": "app-3", "name": "etl", "attempts": []}]`,
+		// The executors of app-2 can't be scraped.
+		"applications/app-1/executors":   `[{"id": "driver", "isActive": true, "activeTasks": 1}]`,
+		"applications/app-2/jobs":        `[{"jobId": 1, "status": "RUNNING", "numTasks": 4}]`,
+		"applications/app-2/stages":      `[]`,
+		"applications/app-2/storage/rdd": `[]`,
+		"applications/app-2/environment": `{}`,
+		"applications/app-3/executors":   `[{"id": "driver", "isActive": true, "activeTasks": 3}]`,
+		"applications/app-3/jobs":        `[]`,
+		"applications/app-3/stages":      `[]`,
+		"applications/app-3/storage/rdd": `[]`,
+		"applications/app-3/environment": `{}`,
+	}))
+	e := newTestExporter(t, []string{s.URL}, Options{Endpoints: scrapeEndpoints})
+	families := gather(t, e)
+	checkSeries(t, families, []series{
+		// The target is still up and the other applications are exported.
+		{"spark_up", map[string]string{"endpoint": s.URL}, 1},
+		{"spark_executor_active_tasks", map[string]string{"app_id": "app-1", "executor_id": "driver"}, 1},
+		{"spark_executor_active_tasks", map[string]string{"app_id": "app-3", "executor_id": "driver"}, 3},
+		// The other endpoints of the failing application are still scraped.
+		{"spark_job_tasks", map[string]string{"app_id": "app-2", "job_id": "1"}, 4},
+		{"spark_exporter_endpoint_scrape_errors_total", map[string]string{"endpoint": s.URL}, 1},
+		{"spark_exporter_scrape_errors_total", map[string]string{"reason": "http_status"}, 1},
+	})
+	if _, ok := metricValue(families, "spark_application_executors_total", map[string]string{"app_id": "app-2"}); ok {
+		t.Error("got spark_application_executors_total for app-2, want its executors left out")
+	}
+	// The failures are counted across scrapes.
+	checkSeries(t, gather(t, e), []series{
+		{"spark_exporter_endpoint_scrape_errors_total", map[string]string{"endpoint": s.URL}, 2},
+	})
+}
+
+func TestLastScrapeTimestamp(t *testing.T) {
+	s := newSparkServer(t, appRoutes(nil))
+	e := newTestExporter(t, []string{s.URL}, Options{})
+	lastScrape := func() float64 {
+		t.Helper()
+		v, ok := metricValue(gather(t, e), "spark_exporter_last_scrape_timestamp_seconds", map[string]string{"endpoint": s.URL})
+		if !ok {
+			t.Fatal("spark_exporter_last_scrape_timestamp_seconds not exported")
+		}
+		return v
+	}
+
+	first := lastScrape()
+	if now := float64(time.Now().Unix()); first < now-60 || first > now+1 {
+		t.Errorf("got spark_exporter_last_scrape_timestamp_seconds %v, want about %v", first, now)
+	}
+	time.Sleep(10 * time.Millisecond)
+	second := lastScrape()
+	if second <= first {
+		t.Errorf("got spark_exporter_last_scrape_timestamp_seconds %v after %v, want it to advance", second, first)
+	}
+	// A failed scrape keeps the time of the last successful one.
+	s.setRoute("applications", "not json")
+	if got := lastScrape(); got != second {
+		t.Errorf("got spark_exporter_last_scrape_timestamp_seconds %v after a failed scrape, want %v", got, second)
+	}
+}
+
+// scrapeCount returns the number of scrapes observed by scrapeDurations.
+func scrapeCount(t *testing.T, scrapeDurations prometheus.Histogram) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := scrapeDurations.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestScrapeDurationHistogram(t *testing.T) {
+	s := newSparkServer(t, appRoutes(nil))
+	e := newTestExporter(t, []string{s.URL}, Options{})
+	metrics := newExporterMetrics(defaultNamespace)
+	e.scrapeDurations = metrics.scrapeDurations
+	handler := metricsHandler(func() *Exporter { return e }, metrics.registry, 0)
+	for i := 1; i <= 3; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if got := scrapeCount(t, metrics.scrapeDurations); got != uint64(i) {
+			t.Errorf("got %d scrapes observed after %d scrapes, want %d", got, i, i)
+		}
+		// The scrape being served is already observed.
+		want := fmt.Sprintf("spark_exporter_scrape_duration_histogram_seconds_count %d", i)
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("the metrics don't contain %q", want)
+		}
+	}
+}
+
+func TestConstLabels(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "1", "isActive": true, "activeTasks": 1}]`,
+		"applications/app-1/jobs":      `[{"jobId": 0, "status": "RUNNING", "numTasks": 4}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{ConstLabels: map[string]string{"cluster": "prod"}}))
+	if len(families) == 0 {
+		t.Fatal("got no metrics")
+	}
+	for name, mf := range families {
+		for _, m := range mf.Metric {
+			if !hasLabel(m, "cluster", "prod") {
+				t.Errorf("got %s%v without the cluster label", name, m.Label)
+			}
+		}
+	}
+}
+
+func hasLabel(m *dto.Metric, name, value string) bool {
+	for _, l := range m.Label {
+		if l.GetName() == name && l.GetValue() == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidateConstLabels(t *testing.T) {
+	tests := []struct {
+		labels  map[string]string
+		wantErr bool
+	}{
+		{labels: map[string]string{"cluster": "prod", "region": "eu-west-1"}},
+		{labels: map[string]string{"__name__": "spark"}, wantErr: true},
+		{labels: map[string]string{"__meta": "x"}, wantErr: true},
+		{labels: map[string]string{"1cluster": "prod"}, wantErr: true},
+		{labels: map[string]string{"cluster-name": "prod"}, wantErr: true},
+		// Names of the labels of the metrics would clash.
+		{labels: map[string]string{"app_id": "x"}, wantErr: true},
+		{labels: map[string]string{"executor_id": "x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := validateConstLabels(tt.labels)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateConstLabels(%v) = %v, want an error: %v", tt.labels, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSetupLogging(t *testing.T) {
+	var logged bytes.Buffer
+	logger := log.New()
+	logger.Out = &logged
+	if err := setupLogging(logger, "info", "json"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Debugf("Retrying request to %s", "http://driver:4040")
+	logger.Errorf("Can't scrape Spark: %v", "HTTP status 500")
+	lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got log lines %q, want only the error above the info level", lines)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("got invalid JSON log line %q: %v", lines[0], err)
+	}
+	if entry["level"] != "error" || entry["msg"] != "Can't scrape Spark: HTTP status 500" {
+		t.Errorf("got log entry %v", entry)
+	}
+
+	for _, tt := range []struct{ level, format string }{{"verbose", "logfmt"}, {"info", "xml"}} {
+		if err := setupLogging(log.New(), tt.level, tt.format); err == nil {
+			t.Errorf("setupLogging(%q, %q) returned no error", tt.level, tt.format)
+		}
+	}
+}
+
+// testCluster returns the routes of a driver running n applications, with
+// their executors, jobs, stages, RDDs and environment.
+func testCluster(n int) map[string]string {
+	routes := map[string]string{"version": `{"spark": "3.3.0"}`}
+	var apps []string
+	for i := 1; i <= n; i++ {
+		id := fmt.Sprintf("app-%d", i)
+		apps = append(apps, fmt.Sprintf(`{"id": %q, "name": "etl-%d", "attempts": [{"startTime": "2023-01-02T15:04:05.250GMT", "sparkUser": "spark", "completed": true, "duration": 1000}]}`, id, i))
+		routes["applications/"+id+"/executors"] = fmt.Sprintf(`[
+			{"id": "driver", "hostPort": "h0:1", "isActive": true, "memoryUsed": %d, "maxMemory": 1000},
+			{"id": "1", "hostPort": "h1:1", "isActive": true, "activeTasks": %d, "completedTasks": 10, "totalTasks": 12, "totalGCTime": 1500},
+			{"id": "2", "hostPort": "h2:1", "isActive": false, "completedTasks": 5}]`, i*100, i)
+		routes["applications/"+id+"/jobs"] = fmt.Sprintf(`[{"jobId": 0, "status": "RUNNING", "numTasks": %d}]`, i*10)
+		routes["applications/"+id+"/stages"] = fmt.Sprintf(`[
+			{"stageId": 3, "attemptId": 0, "status": "ACTIVE", "name": "save", "numActiveTasks": %d, "inputBytes": 100},
+			{"stageId": 2, "attemptId": 0, "status": "COMPLETE", "name": "count", "numCompleteTasks": 5}]`, i)
+		routes["applications/"+id+"/storage/rdd"] = fmt.Sprintf(`[{"id": 7, "name": "cached", "numPartitions": 10, "numCachedPartitions": %d, "memoryUsed": 1024}]`, i)
+		routes["applications/"+id+"/environment"] = `{"runtime": {"javaVersion": "1.8.0_292", "scalaVersion": "version 2.12.10"}, "sparkProperties": [["spark.scheduler.mode", "FAIR"]]}`
+	}
+	routes["applications"] = "[" + strings.Join(apps, ",") + "]"
+	return routes
+}
+
+// exposition returns the metrics gathered from e in the text format, without
+// the metrics of the exporter itself, which vary between scrapes.
+func exposition(t testing.TB, e *Exporter) string {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	var b strings.Builder
+	for _, mf := range mfs {
+		if strings.HasPrefix(mf.GetName(), "spark_exporter_") {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&b, mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.String()
+}
+
+func TestConcurrentScrapeMatchesSequential(t *testing.T) {
+	s := newSparkServer(t, testCluster(5))
+	sequential := exposition(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: scrapeEndpoints, MaxConcurrency: 1}))
+	if !strings.Contains(sequential, `spark_rdd_`) {
+		t.Fatalf("the sequential scrape exported no RDD metrics:\n%s", sequential)
+	}
+	for _, concurrency := range []int{2, 8, 32} {
+		e := newTestExporter(t, []string{s.URL}, Options{Endpoints: scrapeEndpoints, MaxConcurrency: concurrency})
+		if got := exposition(t, e); got != sequential {
+			t.Errorf("the metrics scraped with a concurrency of %d differ from the sequential ones:\n%s\nwant:\n%s", concurrency, got, sequential)
+		}
+	}
+}
+
+func BenchmarkScrape(b *testing.B) {
+	for _, concurrency := range []int{1, 8} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			routes := testCluster(20)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Spark takes some time to answer each request.
+				time.Sleep(time.Millisecond)
+				body, ok := routes[strings.TrimPrefix(r.URL.Path, "/api/v1/")]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(body))
+			}))
+			defer server.Close()
+			e, err := NewExporter([]string{server.URL}, FetchOptions{}, Options{Endpoints: scrapeEndpoints, MaxConcurrency: concurrency})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer closeHTTPClient(e.client)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				exposition(b, e)
+			}
+		})
+	}
+}
+
+func TestScrapeContext(t *testing.T) {
+	tests := []struct {
+		header       string
+		wantDeadline bool
+		want         time.Duration
+	}{
+		{header: ""},
+		{header: "invalid"},
+		{header: "-1"},
+		{header: "10", wantDeadline: true, want: 9500 * time.Millisecond},
+		{header: "2.5", wantDeadline: true, want: 2 * time.Second},
+		// The offset isn't subtracted from timeouts shorter than it.
+		{header: "0.2", wantDeadline: true, want: 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+			}
+			ctx, cancel := scrapeContext(r, 500*time.Millisecond)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			if ok != tt.wantDeadline {
+				t.Fatalf("got a deadline: %v, want one: %v", ok, tt.wantDeadline)
+			}
+			if got := time.Until(deadline); ok && (got > tt.want || got < tt.want-100*time.Millisecond) {
+				t.Errorf("got a timeout of %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsHandlerTimeout(t *testing.T) {
+	s := newSparkServer(t, appRoutes(nil))
+	handler := s.Config.Handler
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/executors") {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+	tests := []struct {
+		name          string
+		scrapeTimeout time.Duration
+		header        string
+		want          time.Duration
+	}{
+		{name: "Prometheus timeout", scrapeTimeout: time.Minute, header: "0.8", want: 300 * time.Millisecond},
+		{name: "shorter scrape timeout", scrapeTimeout: 200 * time.Millisecond, header: "10", want: 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestExporter(t, []string{s.URL}, Options{ScrapeTimeout: tt.scrapeTimeout})
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			metricsHandler(func() *Exporter { return e }, prometheus.NewRegistry(), 500*time.Millisecond).ServeHTTP(rec, r)
+			if elapsed := time.Since(start); elapsed < tt.want || elapsed > tt.want+time.Second {
+				t.Errorf("the scrape took %v, want %v", elapsed, tt.want)
+			}
+			if !strings.Contains(rec.Body.String(), `spark_exporter_scrape_errors_total{reason="timeout"} 1`) {
+				t.Errorf("the scrape didn't time out:\n%s", rec.Body)
+			}
+		})
+	}
+}
+func TestScrapeEndpoints(t *testing.T) {
+	paths := map[string]string{
+		"executors":   "applications/app-1/allexecutors",
+		"application": "applications/app-1/environment",
+		"jobs":        "applications/app-1/jobs",
+		"stages":      "applications/app-1/stages",
+		"storage":     "applications/app-1/storage/rdd",
+		"sql":         "applications/app-1/sql?details=false&planDescription=false",
+		"streaming":   "applications/app-1/streaming/statistics",
+	}
+	tests := []struct {
+		name      string
+		endpoints []string
+		want      []string
+	}{
+		{name: "default", want: []string{"executors", "application"}},
+		{name: "executors only", endpoints: []string{"executors"}, want: []string{"executors"}},
+		{name: "without stages", endpoints: []string{"executors", "application", "jobs", "storage", "sql", "streaming"}, want: []string{"executors", "application", "jobs", "storage", "sql", "streaming"}},
+		{name: "stages", endpoints: []string{"stages"}, want: []string{"stages"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := map[string]string{"applications": testApplications, "version": `{"spark": "3.3.0"}`}
+			for _, path := range paths {
+				routes[strings.SplitN(path, "?", 2)[0]] = `[]`
+			}
+			routes["applications/app-1/environment"] = `{}`
+			routes["applications/app-1/streaming/statistics"] = `{}`
+			s := newSparkServer(t, routes)
+			e := newTestExporter(t, []string{s.URL}, Options{Endpoints: tt.endpoints})
+			families := gather(t, e)
+			if got, _ := metricValue(families, "spark_up", nil); got != 1 {
+				t.Fatalf("got spark_up %v, want 1", got)
+			}
+			want := map[string]bool{}
+			for _, endpoint := range tt.want {
+				want[endpoint] = true
+			}
+			for endpoint, path := range paths {
+				if n := s.requested(path); (n > 0) != want[endpoint] {
+					t.Errorf("got %d requests to %s, want some: %v", n, path, want[endpoint])
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidScrapeEndpoint(t *testing.T) {
+	_, err := NewExporter([]string{"http://driver:4040"}, FetchOptions{}, Options{Endpoints: []string{"executors", "tasks"}})
+	if err == nil || !strings.Contains(err.Error(), `invalid scrape endpoint "tasks"`) {
+		t.Errorf("got error %v, want the tasks endpoint rejected", err)
+	}
+}
+
+// TestVersionFlag runs main in a child process, as it exits the process.
+func TestVersionFlag(t *testing.T) {
+	if os.Getenv("SPARK_EXPORTER_TEST_MAIN") == "1" {
+		os.Args = append([]string{"spark_exporter"}, strings.Fields(os.Getenv("SPARK_EXPORTER_TEST_ARGS"))...)
+		main()
+		return
+	}
+	socket := filepath.Join(t.TempDir(), "exporter.sock")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestVersionFlag$")
+	cmd.Env = append(os.Environ(),
+		"SPARK_EXPORTER_TEST_MAIN=1",
+		"SPARK_EXPORTER_TEST_ARGS=--version --web.listen-address=unix:"+socket,
+	)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("got %v running with --version, want exit status 0", err)
+	}
+	if !strings.Contains(stdout.String(), "spark_exporter, version") {
+		t.Errorf("got output %q, want the build information", stdout.String())
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("got %v for the listen socket, want it never created", err)
+	}
+}
+
+func TestParseApplicationsStreaming(t *testing.T) {
+	// The first application is followed by a body that blocks, so that it
+	// is only kept if the applications are decoded as they are read.
+	r, w := io.Pipe()
+	defer w.Close()
+	go w.Write([]byte(`[{"id": "app-1", "name": "etl", "attempts": []}, `))
+	kept := make(chan string, 1)
+	go parseApplications(r, func(app ApplicationMetrics) bool {
+		kept <- app.ID
+		return false
+	})
+	select {
+	case id := <-kept:
+		if id != "app-1" {
+			t.Errorf("got application %s, want app-1", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first application was not decoded before the end of the body")
+	}
+}
+
+func TestParseLargeApplications(t *testing.T) {
+	const n = 100000
+	// The listing is generated as it is read.
+	r, w := io.Pipe()
+	go func() {
+		bw := bufio.NewWriter(w)
+		bw.WriteString("[")
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				bw.WriteString(",")
+			}
+			fmt.Fprintf(bw, `{"id": "app-%d", "name": "etl-%d", "attempts": [{"completed": true, "duration": 1000}]}`, i, i%10)
+		}
+		bw.WriteString("]")
+		bw.Flush()
+		w.Close()
+	}()
+	info, err := parseApplications(r, func(app ApplicationMetrics) bool { return app.Name == "etl-0" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Listed != n || len(info.Applications) != n/10 {
+		t.Errorf("got %d applications listed and %d kept, want %d and %d", info.Listed, len(info.Applications), n, n/10)
+	}
+}
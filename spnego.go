@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// httpDoer sends HTTP requests, either directly with an *http.Client or
+// negotiating SPNEGO authentication first.
+type httpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// newSPNEGOClient logs in to the KDC with the keytab of opts and returns a
+// client sending requests through httpClient with a Negotiate authorization.
+// The Kerberos client renews its ticket granting ticket before it expires
+// and caches the service tickets it gets.
+func newSPNEGOClient(opts FetchOptions, httpClient *http.Client) (httpDoer, error) {
+	cfg, err := config.Load(opts.Krb5Config)
+	if err != nil {
+		return nil, fmt.Errorf("can't load krb5 config: %v", err)
+	}
+	kt, err := keytab.Load(opts.Keytab)
+	if err != nil {
+		return nil, fmt.Errorf("can't load keytab: %v", err)
+	}
+	username, realm := opts.Principal, cfg.LibDefaults.DefaultRealm
+	if i := strings.LastIndex(opts.Principal, "@"); i >= 0 {
+		username, realm = opts.Principal[:i], opts.Principal[i+1:]
+	}
+	cl := client.NewWithKeytab(username, realm, kt, cfg, client.DisablePAFXFAST(true))
+	if err := cl.Login(); err != nil {
+		return nil, fmt.Errorf("can't log in as %s: %v", opts.Principal, err)
+	}
+	// The session cookie set by the server after a negotiation is sent back
+	// by every request instead of negotiating again.
+	if httpClient.Jar, err = cookiejar.New(nil); err != nil {
+		return nil, err
+	}
+	return &spnegoClient{httpClient: httpClient, krb5: cl}, nil
+}
+
+// spnegoClient sends requests with a Negotiate authorization from its
+// Kerberos client, which is safe for concurrent use.
+type spnegoClient struct {
+	httpClient *http.Client
+	krb5       *client.Client
+}
+
+// Do sends req with a new spnego.Client: a spnego.Client records the
+// requests it redirects without locking and refuses to follow more than 10
+// redirects over its lifetime, so it can't be shared between requests. It
+// also wraps the CheckRedirect of its http.Client, so it gets a copy of
+// httpClient sharing its transport and cookies.
+func (c *spnegoClient) Do(req *http.Request) (*http.Response, error) {
+	httpClient := *c.httpClient
+	return spnego.NewClient(c.krb5, &httpClient, "").Do(req)
+}
+
+// close stops renewing the ticket granting ticket of the Kerberos client
+// and closes the idle connections.
+func (c *spnegoClient) close() {
+	c.krb5.Destroy()
+	c.httpClient.CloseIdleConnections()
+}
@@ -0,0 +1,45 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// TestSPNEGOIntegration scrapes the Spark UI at $SPNEGO_SPARK_URI, secured
+// with SPNEGO, logging in as $SPNEGO_PRINCIPAL with $SPNEGO_KEYTAB and the
+// Kerberos configuration $KRB5_CONFIG. Run it with go test -tags integration.
+func TestSPNEGOIntegration(t *testing.T) {
+	uri := os.Getenv("SPNEGO_SPARK_URI")
+	if uri == "" {
+		t.Skip("SPNEGO_SPARK_URI not set")
+	}
+	opts := FetchOptions{
+		AuthType:   "spnego",
+		Krb5Config: os.Getenv("KRB5_CONFIG"),
+		Keytab:     os.Getenv("SPNEGO_KEYTAB"),
+		Principal:  os.Getenv("SPNEGO_PRINCIPAL"),
+	}
+	if opts.Krb5Config == "" {
+		opts.Krb5Config = "/etc/krb5.conf"
+	}
+	if err := opts.validate(); err != nil {
+		t.Fatal(err)
+	}
+	// Enough requests to exceed the redirects allowed by a single
+	// spnego.Client when the UI redirects to its API.
+	for i := 0; i < 15; i++ {
+		if _, err := fetchBody(context.Background(), t, uri, opts, "applications"); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	e, err := NewExporter([]string{uri}, opts, Options{})
+	if err != nil {
+		t.Fatalf("NewExporter: %v", err)
+	}
+	checkSeries(t, gather(t, e), []series{
+		{"spark_up", map[string]string{"endpoint": uri}, 1},
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+)
+
+func TestSPNEGOClientRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/applications" {
+			http.Redirect(w, r, "/api/v1/applications/", http.StatusFound)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	// The server doesn't ask for a negotiation, so the Kerberos client
+	// doesn't need to log in.
+	c := &spnegoClient{
+		httpClient: &http.Client{},
+		krb5:       client.NewWithPassword("spark_exporter", "EXAMPLE.COM", "secret", config.New()),
+	}
+	fetch, err := fetchHTTPApi(server.URL, FetchOptions{}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More redirects are followed in total than a single spnego.Client
+	// allows, some of them concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, err := fetch(context.Background(), "applications")
+			if err != nil {
+				t.Errorf("fetch: %v", err)
+				return
+			}
+			defer body.Close()
+			if b, _ := ioutil.ReadAll(body); string(b) != "[]" {
+				t.Errorf("got body %q, want []", b)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var sqlLabelNames = []string{"endpoint", "app_id", "execution_id", "status"}
+
+// SQLExecution holds the metrics of a single SQL query execution of an
+// application. The details and plan description of the query are neither
+// requested nor decoded, as they can be very large.
+type SQLExecution struct {
+	ID            int64  `json:"id"`
+	Status        string `json:"status"`
+	Duration      int64  `json:"duration"`
+	RunningJobIDs []int  `json:"runningJobIds"`
+	SuccessJobIDs []int  `json:"successJobIds"`
+	FailedJobIDs  []int  `json:"failedJobIds"`
+}
+
+func newSQLMetrics(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "sql_" + metricName,
+			Help:        docString,
+			ConstLabels: constLabels,
+		},
+		sqlLabelNames,
+	)
+}
+
+func newSQLGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"duration_seconds": newSQLMetrics(namespace, "duration_seconds", "Duration of the SQL query execution in seconds", constLabels),
+		"running_jobs":     newSQLMetrics(namespace, "running_jobs", "Number of running jobs of the SQL query execution", constLabels),
+		"success_jobs":     newSQLMetrics(namespace, "success_jobs", "Number of succeeded jobs of the SQL query execution", constLabels),
+		"failed_jobs":      newSQLMetrics(namespace, "failed_jobs", "Number of failed jobs of the SQL query execution", constLabels),
+	}
+}
+
+// scrapeSQL returns the SQL executions of the application, none for Spark
+// versions without the SQL endpoint.
+func (t *target) scrapeSQL(ctx context.Context, appID string) ([]SQLExecution, error) {
+	body, err := t.fetch(ctx, "applications/"+url.PathEscape(appID)+"/sql?details=false&planDescription=false")
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return parseSQLExecutions(body)
+}
+
+// limitSQLExecutions returns at most max executions, running ones first and
+// then the most recent ones. A max of 0 doesn't limit the executions.
+func limitSQLExecutions(executions []SQLExecution, max int) []SQLExecution {
+	if max <= 0 || len(executions) <= max {
+		return executions
+	}
+	sort.Slice(executions, func(i, j int) bool {
+		ri, rj := executions[i].Status == "RUNNING", executions[j].Status == "RUNNING"
+		if ri != rj {
+			return ri
+		}
+		return executions[i].ID > executions[j].ID
+	})
+	return executions[:max]
+}
+
+func (e *Exporter) setSQLMetrics(key appKey, execution SQLExecution) {
+	labels := append(key.labelValues(), fmt.Sprint(execution.ID), execution.Status)
+	e.sqlGaugeMetrics["duration_seconds"].WithLabelValues(labels...).Set(float64(execution.Duration) / 1000)
+	e.sqlGaugeMetrics["running_jobs"].WithLabelValues(labels...).Set(float64(len(execution.RunningJobIDs)))
+	e.sqlGaugeMetrics["success_jobs"].WithLabelValues(labels...).Set(float64(len(execution.SuccessJobIDs)))
+	e.sqlGaugeMetrics["failed_jobs"].WithLabelValues(labels...).Set(float64(len(execution.FailedJobIDs)))
+}
+
+func parseSQLExecutions(r io.Reader) ([]SQLExecution, error) {
+	var executions []SQLExecution
+	if err := json.NewDecoder(r).Decode(&executions); err != nil {
+		return nil, newDecodeError("SQL executions", err)
+	}
+	return executions, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestSQLMetrics(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/sql": `[
+			{"id": 0, "status": "RUNNING", "duration": 90000, "runningJobIds": [4], "successJobIds": [1, 2], "failedJobIds": []},
+			{"id": 1, "status": "COMPLETED", "duration": 2500, "runningJobIds": [], "successJobIds": [3], "failedJobIds": []},
+			{"id": 2, "status": "FAILED", "duration": 500, "runningJobIds": [], "successJobIds": [], "failedJobIds": [5]}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"sql"}, SQLMaxExecutions: 2}))
+	if n := s.requested("applications/app-1/sql?details=false&planDescription=false"); n != 1 {
+		t.Errorf("got %d requests of the SQL executions without details, want 1", n)
+	}
+	running := map[string]string{"app_id": "app-1", "execution_id": "0", "status": "RUNNING"}
+	failed := map[string]string{"app_id": "app-1", "execution_id": "2", "status": "FAILED"}
+	checkSeries(t, families, []series{
+		{"spark_sql_duration_seconds", running, 90},
+		{"spark_sql_running_jobs", running, 1},
+		{"spark_sql_success_jobs", running, 2},
+		{"spark_sql_failed_jobs", running, 0},
+		{"spark_sql_duration_seconds", failed, 0.5},
+		{"spark_sql_failed_jobs", failed, 1},
+	})
+	// The running execution and the most recent one are kept.
+	if m := findMetric(families["spark_sql_duration_seconds"], map[string]string{"execution_id": "1"}); m != nil {
+		t.Errorf("got the execution 1 beyond --sql.max-executions: %v", m)
+	}
+}
+
+func TestSQLNotFound(t *testing.T) {
+	// Spark versions without the SQL endpoint answer it with a 404.
+	s := newSparkServer(t, appRoutes(nil))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"sql"}}))
+	checkSeries(t, families, []series{
+		{"spark_up", map[string]string{"endpoint": s.URL}, 1},
+	})
+	if mf := families["spark_sql_duration_seconds"]; mf != nil {
+		t.Errorf("got spark_sql_duration_seconds %v, want none", mf.Metric)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var stageLabelNames = []string{"endpoint", "app_id", "stage_id", "attempt_id", "status"}
+
+// StageInfo holds the metrics of a single stage attempt of an application
+type StageInfo struct {
+	StageID             int    `json:"stageId"`
+	AttemptID           int    `json:"attemptId"`
+	Name                string `json:"name"`
+	Status              string `json:"status"`
+	NumTasks            int    `json:"numTasks"`
+	NumActiveTasks      int    `json:"numActiveTasks"`
+	NumCompleteTasks    int    `json:"numCompleteTasks"`
+	NumFailedTasks      int    `json:"numFailedTasks"`
+	InputBytes          int64  `json:"inputBytes"`
+	InputRecords        int64  `json:"inputRecords"`
+	OutputBytes         int64  `json:"outputBytes"`
+	OutputRecords       int64  `json:"outputRecords"`
+	ShuffleReadBytes    int64  `json:"shuffleReadBytes"`
+	ShuffleReadRecords  int64  `json:"shuffleReadRecords"`
+	ShuffleWriteBytes   int64  `json:"shuffleWriteBytes"`
+	ShuffleWriteRecords int64  `json:"shuffleWriteRecords"`
+	// The spill sizes are missing from the listings of some Spark versions,
+	// in which case they are left at zero.
+	MemoryBytesSpilled int64 `json:"memoryBytesSpilled"`
+	DiskBytesSpilled   int64 `json:"diskBytesSpilled"`
+	// SchedulingPool is the fair scheduler pool of the stage, "default"
+	// unless the application assigns its jobs to pools.
+	SchedulingPool string `json:"schedulingPool"`
+}
+
+// appStage is a stage together with the application it belongs to.
+type appStage struct {
+	appKey
+	StageInfo
+}
+
+// stageCounter is a stage counter together with the function extracting its
+// value from the stage stats.
+type stageCounter struct {
+	desc  *prometheus.Desc
+	value func(StageInfo) float64
+}
+
+func newGaugeStageMetrics(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "stage_" + metricName,
+			Help:        docString,
+			ConstLabels: constLabels,
+		},
+		stageLabelNames,
+	)
+}
+
+func newCounterStageDesc(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "stage", metricName),
+		docString,
+		stageLabelNames,
+		constLabels,
+	)
+}
+
+func newStageGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"active_tasks":   newGaugeStageMetrics(namespace, "active_tasks", "Number of active tasks of the stage", constLabels),
+		"complete_tasks": newGaugeStageMetrics(namespace, "complete_tasks", "Number of completed tasks of the stage", constLabels),
+		"failed_tasks":   newGaugeStageMetrics(namespace, "failed_tasks", "Number of failed tasks of the stage", constLabels),
+	}
+}
+
+func newStageCounterMetrics(namespace string, constLabels prometheus.Labels) []stageCounter {
+	return []stageCounter{
+		{
+			newCounterStageDesc(namespace, "input_bytes", "Total input bytes read by the stage", constLabels),
+			func(s StageInfo) float64 { return nonNegative(s.InputBytes) },
+		},
+		{
+			newCounterStageDesc(namespace, "output_bytes", "Total output bytes written by the stage", constLabels),
+			func(s StageInfo) float64 { return nonNegative(s.OutputBytes) },
+		},
+		{
+			newCounterStageDesc(namespace, "shuffle_read_bytes", "Total shuffle bytes read by the stage", constLabels),
+			func(s StageInfo) float64 { return nonNegative(s.ShuffleReadBytes) },
+		},
+		{
+			newCounterStageDesc(namespace, "shuffle_write_bytes", "Total shuffle bytes written by the stage", constLabels),
+			func(s StageInfo) float64 { return nonNegative(s.ShuffleWriteBytes) },
+		},
+		{
+			newCounterStageDesc(namespace, "input_records", "Total input records read by the stage", constLabels),
+			func(s StageInfo) float64 { return nonNegative(s.InputRecords) },
+		},
+		{
+			newCounterStageDesc(namespace, "output_records", "Total output records written by the stage", constLabels),
+			func(s StageInfo) float64 { return nonNegative(s.OutputRecords) },
+		},
+		{
+			newCounterStageDesc(namespace, "shuffle_read_records", "Total shuffle records read by the stage", constLabels),
+			func(s StageInfo) float64 { return nonNegative(s.ShuffleReadRecords) },
+		},
+		{
+			newCounterStageDesc(namespace, "shuffle_write_records", "Total shuffle records written by the stage", constLabels),
+			func(s StageInfo) float64 { return nonNegative(s.ShuffleWriteRecords) },
+		},
+		{
+			newCounterStageDesc(namespace, "memory_spilled_bytes", "Total bytes spilled from memory by the stage, in their deserialized size", constLabels),
+			func(s StageInfo) float64 { return nonNegative(s.MemoryBytesSpilled) },
+		},
+		{
+			newCounterStageDesc(namespace, "disk_spilled_bytes", "Total bytes spilled to disk by the stage", constLabels),
+			func(s StageInfo) float64 { return nonNegative(s.DiskBytesSpilled) },
+		},
+	}
+}
+
+func (t *target) scrapeStages(ctx context.Context, appID string) ([]StageInfo, error) {
+	body, err := t.fetch(ctx, "applications/"+url.PathEscape(appID)+"/stages")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return parseStages(body)
+}
+
+// includeStage reports whether metrics for the stage should be exported.
+// Finished stages are only exported if asked for, as their number grows for
+// the whole life of the application.
+func (e *Exporter) includeStage(stage StageInfo) bool {
+	switch stage.Status {
+	case "ACTIVE", "PENDING":
+		return true
+	}
+	return e.includeCompletedStages
+}
+
+func (e *Exporter) setStageMetrics(key appKey, stage StageInfo) {
+	labels := stageLabelValues(key, stage)
+	e.stageGaugeMetrics["active_tasks"].WithLabelValues(labels...).Set(float64(stage.NumActiveTasks))
+	e.stageGaugeMetrics["complete_tasks"].WithLabelValues(labels...).Set(float64(stage.NumCompleteTasks))
+	e.stageGaugeMetrics["failed_tasks"].WithLabelValues(labels...).Set(float64(stage.NumFailedTasks))
+}
+
+func (e *Exporter) collectStageCounters(ch chan<- prometheus.Metric) {
+	for _, stage := range e.stages {
+		labels := stageLabelValues(stage.appKey, stage.StageInfo)
+		for _, m := range e.stageCounterMetrics {
+			ch <- prometheus.MustNewConstMetric(m.desc, prometheus.CounterValue, m.value(stage.StageInfo), labels...)
+		}
+	}
+}
+
+func stageLabelValues(key appKey, stage StageInfo) []string {
+	return append(key.labelValues(), fmt.Sprint(stage.StageID), fmt.Sprint(stage.AttemptID), stage.Status)
+}
+
+func parseStages(r io.Reader) ([]StageInfo, error) {
+	var stages []StageInfo
+	if err := json.NewDecoder(r).Decode(&stages); err != nil {
+		return nil, newDecodeError("stages", err)
+	}
+	return stages, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import "testing"
+
+func TestStageMetrics(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/stages": `[
+			{"stageId": 3, "attemptId": 0, "status": "ACTIVE", "numActiveTasks": 4, "numCompleteTasks": 6, "numFailedTasks": 1, "inputBytes": 1024, "shuffleReadBytes": 2048},
+			{"stageId": 4, "attemptId": 0, "status": "PENDING"},
+			{"stageId": 2, "attemptId": 1, "status": "COMPLETE", "numCompleteTasks": 10, "outputBytes": 512, "shuffleWriteBytes": 4096}]`,
+	}))
+	active := map[string]string{"app_id": "app-1", "stage_id": "3", "attempt_id": "0", "status": "ACTIVE"}
+	pending := map[string]string{"app_id": "app-1", "stage_id": "4", "attempt_id": "0", "status": "PENDING"}
+	complete := map[string]string{"app_id": "app-1", "stage_id": "2", "attempt_id": "1", "status": "COMPLETE"}
+	tests := []struct {
+		name             string
+		includeCompleted bool
+		want             []series
+		wantMissing      []map[string]string
+	}{
+		{
+			name: "active and pending",
+			want: []series{
+				{"spark_stage_active_tasks", active, 4},
+				{"spark_stage_complete_tasks", active, 6},
+				{"spark_stage_failed_tasks", active, 1},
+				{"spark_stage_input_bytes", active, 1024},
+				{"spark_stage_shuffle_read_bytes", active, 2048},
+				{"spark_stage_active_tasks", pending, 0},
+			},
+			wantMissing: []map[string]string{complete},
+		},
+		{
+			name:             "completed included",
+			includeCompleted: true,
+			want: []series{
+				{"spark_stage_active_tasks", active, 4},
+				{"spark_stage_complete_tasks", complete, 10},
+				{"spark_stage_output_bytes", complete, 512},
+				{"spark_stage_shuffle_write_bytes", complete, 4096},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families := gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"stages"}, IncludeCompletedStages: tt.includeCompleted}))
+			checkSeries(t, families, tt.want)
+			for _, labels := range tt.wantMissing {
+				for _, name := range []string{"spark_stage_complete_tasks", "spark_stage_output_bytes"} {
+					if _, ok := metricValue(families, name, labels); ok {
+						t.Errorf("got %s%v, want it missing", name, labels)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStageRecords(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/stages": `[
+			{"stageId": 3, "attemptId": 0, "status": "ACTIVE", "inputRecords": 1000, "outputRecords": 10, "shuffleReadRecords": 200, "shuffleWriteRecords": 300},
+			{"stageId": 4, "attemptId": 0, "status": "PENDING"}]`,
+	}))
+	active := map[string]string{"app_id": "app-1", "stage_id": "3", "attempt_id": "0", "status": "ACTIVE"}
+	pending := map[string]string{"app_id": "app-1", "stage_id": "4", "attempt_id": "0", "status": "PENDING"}
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"stages"}})), []series{
+		{"spark_stage_input_records", active, 1000},
+		{"spark_stage_output_records", active, 10},
+		{"spark_stage_shuffle_read_records", active, 200},
+		{"spark_stage_shuffle_write_records", active, 300},
+		// The records missing from the stage are exported as 0.
+		{"spark_stage_input_records", pending, 0},
+		{"spark_stage_output_records", pending, 0},
+		{"spark_stage_shuffle_read_records", pending, 0},
+		{"spark_stage_shuffle_write_records", pending, 0},
+	})
+}
+
+func TestStageSpill(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/stages": `[
+			{"stageId": 3, "attemptId": 0, "status": "ACTIVE", "memoryBytesSpilled": 8192, "diskBytesSpilled": 1024},
+			{"stageId": 4, "attemptId": 0, "status": "ACTIVE", "numActiveTasks": 2}]`,
+	}))
+	spilled := map[string]string{"app_id": "app-1", "stage_id": "3", "attempt_id": "0", "status": "ACTIVE"}
+	// The spill fields are missing from the second stage.
+	minimal := map[string]string{"app_id": "app-1", "stage_id": "4", "attempt_id": "0", "status": "ACTIVE"}
+	checkSeries(t, gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"stages"}})), []series{
+		{"spark_stage_memory_spilled_bytes", spilled, 8192},
+		{"spark_stage_disk_spilled_bytes", spilled, 1024},
+		{"spark_stage_memory_spilled_bytes", minimal, 0},
+		{"spark_stage_disk_spilled_bytes", minimal, 0},
+		{"spark_stage_active_tasks", minimal, 2},
+	})
+}
+
+func TestTaskSummaryMetrics(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/stages": `[
+			{"stageId": 3, "attemptId": 0, "status": "ACTIVE"},
+			{"stageId": 5, "attemptId": 1, "status": "ACTIVE"},
+			{"stageId": 4, "attemptId": 0, "status": "PENDING"}]`,
+		// Spark 3.1 returns the duration of the tasks.
+		"applications/app-1/stages/3/0/taskSummary": `{"quantiles": [0.25, 0.5, 0.75, 0.95],
+			"duration": [1000, 2000, 4000, 12000], "executorRunTime": [900, 1900, 3900, 11500],
+			"jvmGcTime": [0, 50, 100, 1500], "executorCpuTime": [800, 1700, 3500, 10000]}`,
+		// Older versions only return the executor run time.
+		"applications/app-1/stages/5/1/taskSummary": `{"quantiles": [0.25, 0.5, 0.75, 0.95],
+			"executorRunTime": [100, 200, 300, 400], "jvmGcTime": [0, 0, 10, 20]}`,
+	}))
+	const summaryQuery = "?quantiles=0.25,0.5,0.75,0.95"
+
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"stages"}}))
+	if mf := families["spark_stage_task_duration_seconds"]; mf != nil {
+		t.Errorf("got spark_stage_task_duration_seconds %v without --stages.task-distributions", mf.Metric)
+	}
+	if n := s.requested("applications/app-1/stages/3/0/taskSummary" + summaryQuery); n != 0 {
+		t.Errorf("got %d requests of the task summary without --stages.task-distributions", n)
+	}
+
+	families = gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"stages"}, TaskDistributions: true}))
+	stage3 := func(q string) map[string]string {
+		return map[string]string{"app_id": "app-1", "stage_id": "3", "attempt_id": "0", "status": "ACTIVE", "quantile": q}
+	}
+	stage5 := func(q string) map[string]string {
+		return map[string]string{"app_id": "app-1", "stage_id": "5", "attempt_id": "1", "status": "ACTIVE", "quantile": q}
+	}
+	checkSeries(t, families, []series{
+		{"spark_stage_task_duration_seconds", stage3("0.25"), 1},
+		{"spark_stage_task_duration_seconds", stage3("0.5"), 2},
+		{"spark_stage_task_duration_seconds", stage3("0.75"), 4},
+		{"spark_stage_task_duration_seconds", stage3("0.95"), 12},
+		{"spark_stage_task_gc_time_seconds", stage3("0.95"), 1.5},
+		{"spark_stage_task_duration_seconds", stage5("0.5"), 0.2},
+		{"spark_stage_task_gc_time_seconds", stage5("0.95"), 0.02},
+	})
+	// The summary is only requested for active stages.
+	if n := s.requested("applications/app-1/stages/4/0/taskSummary" + summaryQuery); n != 0 {
+		t.Errorf("got %d requests of the task summary of the pending stage", n)
+	}
+}
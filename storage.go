@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rddLabelNames    = []string{"endpoint", "app_id", "rdd_id"}
+	rddNameLabelName = "rdd_name"
+)
+
+// RDDInfo holds the storage metrics of a persisted RDD of an application
+type RDDInfo struct {
+	ID                  int    `json:"id"`
+	Name                string `json:"name"`
+	NumPartitions       int    `json:"numPartitions"`
+	NumCachedPartitions int    `json:"numCachedPartitions"`
+	StorageLevel        string `json:"storageLevel"`
+	MemoryUsed          int64  `json:"memoryUsed"`
+	DiskUsed            int64  `json:"diskUsed"`
+}
+
+func newRDDMetrics(namespace string, metricName string, docString string, labelNames []string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "rdd_" + metricName,
+			Help:        docString,
+			ConstLabels: constLabels,
+		},
+		labelNames,
+	)
+}
+
+func newRDDGaugeMetrics(namespace string, labelNames []string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"memory_used_bytes":     newRDDMetrics(namespace, "memory_used_bytes", "Memory used by the cached RDD in bytes", labelNames, constLabels),
+		"disk_used_bytes":       newRDDMetrics(namespace, "disk_used_bytes", "Disk space used by the cached RDD in bytes", labelNames, constLabels),
+		"num_partitions":        newRDDMetrics(namespace, "num_partitions", "Number of partitions of the RDD", labelNames, constLabels),
+		"num_cached_partitions": newRDDMetrics(namespace, "num_cached_partitions", "Number of cached partitions of the RDD", labelNames, constLabels),
+	}
+}
+
+func (t *target) scrapeRDDs(ctx context.Context, appID string) ([]RDDInfo, error) {
+	body, err := t.fetch(ctx, "applications/"+url.PathEscape(appID)+"/storage/rdd")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return parseRDDs(body)
+}
+
+func (e *Exporter) setRDDMetrics(key appKey, rdd RDDInfo) {
+	labels := append(key.labelValues(), fmt.Sprint(rdd.ID))
+	if e.rddNameLabel {
+		labels = append(labels, rdd.Name)
+	}
+	e.rddGaugeMetrics["memory_used_bytes"].WithLabelValues(labels...).Set(float64(rdd.MemoryUsed))
+	e.rddGaugeMetrics["disk_used_bytes"].WithLabelValues(labels...).Set(float64(rdd.DiskUsed))
+	// The partitions of RDDs without any are not exported, so that the
+	// cached ratio of an RDD is never computed from a zero total.
+	if rdd.NumPartitions > 0 {
+		e.rddGaugeMetrics["num_partitions"].WithLabelValues(labels...).Set(float64(rdd.NumPartitions))
+		e.rddGaugeMetrics["num_cached_partitions"].WithLabelValues(labels...).Set(float64(rdd.NumCachedPartitions))
+	}
+}
+
+func parseRDDs(r io.Reader) ([]RDDInfo, error) {
+	var rdds []RDDInfo
+	if err := json.NewDecoder(r).Decode(&rdds); err != nil {
+		return nil, newDecodeError("RDDs", err)
+	}
+	return rdds, nil
+}
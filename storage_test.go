@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRDDMetrics(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/storage/rdd": `[{"id": 7, "name": "users", "numPartitions": 10, "numCachedPartitions": 8, "storageLevel": "Memory Deserialized 1x Replicated", "memoryUsed": 4096, "diskUsed": 1024}]`,
+	}))
+	target := strings.TrimPrefix(s.URL, "http://")
+	tests := []struct {
+		name         string
+		rddNameLabel bool
+		labels       map[string]string
+	}{
+		{name: "with the name label", rddNameLabel: true, labels: map[string]string{"endpoint": s.URL, "target": target, "app_id": "app-1", "rdd_id": "7", "rdd_name": "users"}},
+		{name: "without the name label", labels: map[string]string{"endpoint": s.URL, "target": target, "app_id": "app-1", "rdd_id": "7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families := gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"storage"}, RDDNameLabel: tt.rddNameLabel}))
+			checkSeries(t, families, []series{
+				{"spark_rdd_memory_used_bytes", tt.labels, 4096},
+				{"spark_rdd_disk_used_bytes", tt.labels, 1024},
+				{"spark_rdd_num_partitions", tt.labels, 10},
+				{"spark_rdd_num_cached_partitions", tt.labels, 8},
+			})
+			if m := findMetric(families["spark_rdd_memory_used_bytes"], tt.labels); m != nil && len(m.Label) != len(tt.labels) {
+				t.Errorf("got labels %v, want %v", m.Label, tt.labels)
+			}
+		})
+	}
+}
+
+func TestRDDPartitions(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/storage/rdd": `[
+			{"id": 7, "name": "users", "numPartitions": 200, "numCachedPartitions": 50, "memoryUsed": 4096},
+			{"id": 8, "name": "empty", "numPartitions": 0, "numCachedPartitions": 0}]`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"storage"}}))
+	checkSeries(t, families, []series{
+		{"spark_rdd_num_partitions", map[string]string{"rdd_id": "7"}, 200},
+		{"spark_rdd_num_cached_partitions", map[string]string{"rdd_id": "7"}, 50},
+		{"spark_rdd_memory_used_bytes", map[string]string{"rdd_id": "8"}, 0},
+	})
+	// The RDD without partitions has no cache coverage.
+	for _, name := range []string{"spark_rdd_num_partitions", "spark_rdd_num_cached_partitions"} {
+		if m := findMetric(families[name], map[string]string{"rdd_id": "8"}); m != nil {
+			t.Errorf("got %s for the RDD without partitions: %v", name, m)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var streamingLabelNames = []string{"endpoint", "app_id"}
+
+// StreamingStatistics holds the statistics of a streaming application. The
+// average times are in milliseconds and missing until a batch completes.
+type StreamingStatistics struct {
+	BatchDuration            int64  `json:"batchDuration"`
+	NumReceivers             int    `json:"numReceivers"`
+	NumActiveReceivers       int    `json:"numActiveReceivers"`
+	NumActiveBatches         int    `json:"numActiveBatches"`
+	NumTotalCompletedBatches int64  `json:"numTotalCompletedBatches"`
+	NumProcessedRecords      int64  `json:"numProcessedRecords"`
+	AvgSchedulingDelay       *int64 `json:"avgSchedulingDelay"`
+	AvgProcessingTime        *int64 `json:"avgProcessingTime"`
+	AvgTotalDelay            *int64 `json:"avgTotalDelay"`
+}
+
+func newStreamingMetrics(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "streaming_" + metricName,
+			Help:        docString,
+			ConstLabels: constLabels,
+		},
+		streamingLabelNames,
+	)
+}
+
+func newStreamingGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"avg_processing_time_seconds":  newStreamingMetrics(namespace, "avg_processing_time_seconds", "Average processing time of the completed batches in seconds", constLabels),
+		"avg_scheduling_delay_seconds": newStreamingMetrics(namespace, "avg_scheduling_delay_seconds", "Average scheduling delay of the completed batches in seconds", constLabels),
+		"num_active_batches":           newStreamingMetrics(namespace, "num_active_batches", "Number of batches waiting or being processed", constLabels),
+		"num_total_completed_batches":  newStreamingMetrics(namespace, "num_total_completed_batches", "Number of completed batches", constLabels),
+		"num_receivers":                newStreamingMetrics(namespace, "num_receivers", "Number of receivers of the streaming application", constLabels),
+	}
+}
+
+// scrapeStreaming returns the streaming statistics of the application, or
+// nil if it isn't a streaming application.
+func (t *target) scrapeStreaming(ctx context.Context, appID string) (*StreamingStatistics, error) {
+	body, err := t.fetch(ctx, "applications/"+url.PathEscape(appID)+"/streaming/statistics")
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return parseStreamingStatistics(body)
+}
+
+func (e *Exporter) setStreamingMetrics(key appKey, stats *StreamingStatistics) {
+	labels := key.labelValues()
+	if stats.AvgProcessingTime != nil {
+		e.streamingGaugeMetrics["avg_processing_time_seconds"].WithLabelValues(labels...).Set(float64(*stats.AvgProcessingTime) / 1000)
+	}
+	if stats.AvgSchedulingDelay != nil {
+		e.streamingGaugeMetrics["avg_scheduling_delay_seconds"].WithLabelValues(labels...).Set(float64(*stats.AvgSchedulingDelay) / 1000)
+	}
+	e.streamingGaugeMetrics["num_active_batches"].WithLabelValues(labels...).Set(float64(stats.NumActiveBatches))
+	e.streamingGaugeMetrics["num_total_completed_batches"].WithLabelValues(labels...).Set(float64(stats.NumTotalCompletedBatches))
+	e.streamingGaugeMetrics["num_receivers"].WithLabelValues(labels...).Set(float64(stats.NumReceivers))
+}
+
+func parseStreamingStatistics(r io.Reader) (*StreamingStatistics, error) {
+	var stats StreamingStatistics
+	if err := json.NewDecoder(r).Decode(&stats); err != nil {
+		return nil, newDecodeError("streaming statistics", err)
+	}
+	return &stats, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestStreamingMetrics(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications": `[{"id": "app-1", "name": "etl"}, {"id": "app-2", "name": "ingest"}]`,
+		"applications/app-2/streaming/statistics": `{"batchDuration": 1000, "numReceivers": 2, "numActiveReceivers": 2, "numActiveBatches": 3,
+			"numTotalCompletedBatches": 120, "numProcessedRecords": 5000, "avgProcessingTime": 1500}`,
+		"applications/app-2/executors":   `[]`,
+		"applications/app-2/jobs":        `[]`,
+		"applications/app-2/stages":      `[]`,
+		"applications/app-2/storage/rdd": `[]`,
+		"applications/app-2/environment": `{}`,
+	}))
+	families := gather(t, newTestExporter(t, []string{s.URL}, Options{Endpoints: []string{"streaming"}}))
+	// The statistics of app-1, a batch application, are answered with a 404.
+	checkSeries(t, families, []series{
+		{"spark_up", map[string]string{"endpoint": s.URL}, 1},
+		{"spark_streaming_avg_processing_time_seconds", map[string]string{"app_id": "app-2"}, 1.5},
+		{"spark_streaming_num_active_batches", map[string]string{"app_id": "app-2"}, 3},
+		{"spark_streaming_num_total_completed_batches", map[string]string{"app_id": "app-2"}, 120},
+		{"spark_streaming_num_receivers", map[string]string{"app_id": "app-2"}, 2},
+	})
+	// The scheduling delay is missing from the statistics, so it isn't
+	// exported.
+	if mf := families["spark_streaming_avg_scheduling_delay_seconds"]; mf != nil {
+		t.Errorf("got spark_streaming_avg_scheduling_delay_seconds %v, want none", mf.Metric)
+	}
+	if m := findMetric(families["spark_streaming_num_receivers"], map[string]string{"app_id": "app-1"}); m != nil {
+		t.Errorf("got streaming metrics for the batch application app-1: %v", m)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	endpointLabelName = "endpoint"
+	targetLabelName   = "target"
+)
+
+// splitTargetAlias splits a target given as alias=uri, e.g.
+// prod-etl=http://driver:4040, into its alias and URI. A target is only
+// taken as aliased when the text before the first = can't be part of a URI
+// up to its path, so that a URI holding = in its query is left whole.
+func splitTargetAlias(spec string) (alias, uri string, err error) {
+	i := strings.Index(spec, "=")
+	if i < 0 || strings.ContainsAny(spec[:i], ":/?#@[") {
+		return "", spec, nil
+	}
+	if i == 0 {
+		return "", "", fmt.Errorf("invalid target %q: empty alias", spec)
+	}
+	if spec[i+1:] == "" {
+		return "", "", fmt.Errorf("invalid target %q: missing URI", spec)
+	}
+	return spec[:i], spec[i+1:], nil
+}
+
+// targetName returns the value of the target label of the metrics scraped
+// from endpoint: its alias if it has one, its host:port followed by its path
+// otherwise. The path tells apart the drivers discovered behind the same
+// YARN ResourceManager proxy, such as rm:8088/proxy/application_1_0001;
+// aliases only apply to the URIs they are given to, not to the targets
+// discovered from them.
+func (e *Exporter) targetName(endpoint string) string {
+	if alias, ok := e.targetAliases[endpoint]; ok {
+		return alias
+	}
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		return u.Host + strings.TrimRight(u.Path, "/")
+	}
+	return endpoint
+}
+
+// targetLabelGatherer returns a gatherer adding a target label to the
+// metrics gathered by g that have an endpoint label, holding the name of
+// their target given by e.targetName.
+func (e *Exporter) targetLabelGatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		if err != nil {
+			return nil, err
+		}
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				for _, l := range m.Label {
+					if l.GetName() == endpointLabelName {
+						m.Label = append(m.Label, &dto.LabelPair{
+							Name:  proto.String(targetLabelName),
+							Value: proto.String(e.targetName(l.GetValue())),
+						})
+						sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+						break
+					}
+				}
+			}
+		}
+		return mfs, nil
+	})
+}
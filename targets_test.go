@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSplitTargetAlias(t *testing.T) {
+	tests := []struct {
+		spec      string
+		wantAlias string
+		wantURI   string
+		wantErr   bool
+	}{
+		{spec: "http://driver:4040", wantURI: "http://driver:4040"},
+		{spec: "driver:4040", wantURI: "driver:4040"},
+		{spec: "prod-etl=http://driver:4040", wantAlias: "prod-etl", wantURI: "http://driver:4040"},
+		{spec: "prod-etl=driver:4040", wantAlias: "prod-etl", wantURI: "driver:4040"},
+		{spec: "http://driver:4040/proxy?user=spark", wantURI: "http://driver:4040/proxy?user=spark"},
+		{spec: "=http://driver:4040", wantErr: true},
+		{spec: "prod-etl=", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			alias, uri, err := splitTargetAlias(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want one: %v", err, tt.wantErr)
+			}
+			if alias != tt.wantAlias || uri != tt.wantURI {
+				t.Errorf("got alias %q and URI %q, want %q and %q", alias, uri, tt.wantAlias, tt.wantURI)
+			}
+		})
+	}
+}
+
+func TestTargetLabel(t *testing.T) {
+	routes := map[string]string{
+		"applications":                    testApplications,
+		"applications/app-1/allexecutors": `[{"id": "driver", "isActive": true}]`,
+	}
+	aliased, plain := newSparkServer(t, routes), newSparkServer(t, routes)
+	e := newTestExporter(t, []string{"prod-etl=" + aliased.URL, plain.URL}, Options{Endpoints: []string{"executors"}})
+	families := gather(t, e)
+
+	for _, tt := range []struct {
+		endpoint   string
+		wantTarget string
+	}{
+		{aliased.URL, "prod-etl"},
+		{plain.URL, strings.TrimPrefix(plain.URL, "http://")},
+	} {
+		for _, name := range []string{"spark_up", "spark_executor_active_tasks"} {
+			labels := map[string]string{"endpoint": tt.endpoint, targetLabelName: tt.wantTarget}
+			if findMetric(families[name], labels) == nil {
+				t.Errorf("%s%v not exported", name, labels)
+			}
+		}
+	}
+}
+
+func TestDuplicateTargetAlias(t *testing.T) {
+	_, err := NewExporter([]string{"etl=http://driver-1:4040", "etl=http://driver-2:4040"}, FetchOptions{}, Options{})
+	if err == nil || !strings.Contains(err.Error(), `duplicate target alias "etl"`) {
+		t.Errorf("got error %v, want the duplicate alias rejected", err)
+	}
+}
+
+func TestDiscoveredTargetLabel(t *testing.T) {
+	// The ResourceManager proxies the drivers of its two applications under
+	// its own host:port.
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	yarnApps := `{"apps": {"app": [
+		{"id": "application_1_0001", "name": "etl", "state": "RUNNING", "trackingUrl": "%[1]s/proxy/application_1_0001/"},
+		{"id": "application_1_0002", "name": "report", "state": "RUNNING", "trackingUrl": "%[1]s/proxy/application_1_0002/"}]}}`
+	mux.HandleFunc("/ws/v1/cluster/apps", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, yarnApps, server.URL)
+	})
+	for _, id := range []string{"application_1_0001", "application_1_0002"} {
+		id := id
+		api := "/proxy/" + id + "/api/v1/"
+		mux.HandleFunc(api+"applications", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(strings.Replace(testApplications, "app-1", id, 1)))
+		})
+		mux.HandleFunc(api+"applications/"+id+"/allexecutors", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"id": "driver", "isActive": true}]`))
+		})
+	}
+
+	e := newTestExporter(t, nil, Options{YarnRMURI: server.URL, Endpoints: []string{"executors"}})
+	families := gather(t, e)
+	host := strings.TrimPrefix(server.URL, "http://")
+	for _, id := range []string{"application_1_0001", "application_1_0002"} {
+		labels := map[string]string{
+			"endpoint":      server.URL + "/proxy/" + id + "/",
+			"app_id":        id,
+			targetLabelName: host + "/proxy/" + id,
+		}
+		if findMetric(families["spark_executor_active_tasks"], labels) == nil {
+			t.Errorf("spark_executor_active_tasks%v not exported", labels)
+		}
+	}
+}
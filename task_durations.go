@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var taskDurationLabelNames = []string{"endpoint", "app_id"}
+
+// TaskData holds the fields of a task listed by the taskList endpoint that
+// the task duration histogram needs. Duration is missing for tasks still
+// running.
+type TaskData struct {
+	TaskID   int64  `json:"taskId"`
+	Status   string `json:"status"`
+	Duration *int64 `json:"duration"`
+}
+
+// stageAttempt identifies a stage attempt within an application.
+type stageAttempt struct {
+	stageID   int
+	attemptID int
+}
+
+// completedStages holds, by application, the completed stage attempts
+// whose tasks were already observed by the task duration histogram.
+type completedStages struct {
+	mutex  sync.Mutex
+	stages map[appKey]map[stageAttempt]bool
+}
+
+// newTaskDurationHistogram returns the histogram of the durations of the
+// tasks of each application, with classic buckets or, with native, as a
+// native histogram.
+func newTaskDurationHistogram(namespace string, native bool, constLabels prometheus.Labels) *prometheus.HistogramVec {
+	opts := prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Name:        "task_duration_seconds",
+		Help:        "Histogram of the durations of the successful tasks of the stages completed since the exporter started, in seconds",
+		ConstLabels: constLabels,
+		Buckets:     []float64{.1, .5, 1, 5, 10, 30, 60, 300, 600, 1800},
+	}
+	if native {
+		opts.Buckets = nil
+		opts.NativeHistogramBucketFactor = 1.1
+		opts.NativeHistogramMaxBucketNumber = 100
+		opts.NativeHistogramMinResetDuration = time.Hour
+	}
+	return prometheus.NewHistogramVec(opts, taskDurationLabelNames)
+}
+
+// newlyCompleted returns the stages that completed since they were last
+// seen for the application of key. The stages already complete the first
+// time an application is seen are left out and marked as observed, so that
+// the histogram starts when the exporter does, as a counter would, without
+// requesting the tasks of all the retained stages at once.
+func (c *completedStages) newlyCompleted(key appKey, stages []StageInfo) []StageInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	seen, known := c.stages[key]
+	if !known {
+		seen = map[stageAttempt]bool{}
+		c.stages[key] = seen
+	}
+	var completed []StageInfo
+	for _, stage := range stages {
+		id := stageAttempt{stage.StageID, stage.AttemptID}
+		if stage.Status != "COMPLETE" || seen[id] {
+			continue
+		}
+		if !known {
+			seen[id] = true
+			continue
+		}
+		completed = append(completed, stage)
+	}
+	return completed
+}
+
+// observed marks the tasks of the stage as observed.
+func (c *completedStages) observed(key appKey, stage StageInfo) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stages[key][stageAttempt{stage.StageID, stage.AttemptID}] = true
+}
+
+// prune forgets the applications for which keep returns false.
+func (c *completedStages) prune(keep func(appKey) bool) []appKey {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var pruned []appKey
+	for key := range c.stages {
+		if !keep(key) {
+			delete(c.stages, key)
+			pruned = append(pruned, key)
+		}
+	}
+	return pruned
+}
+
+// observeTaskDurations observes the durations of the successful tasks of the
+// stages of the application that completed since the last scrape.
+func (e *Exporter) observeTaskDurations(ctx context.Context, t *target, key appKey, stages []StageInfo) error {
+	for _, stage := range e.completedStages.newlyCompleted(key, stages) {
+		tasks, err := t.scrapeTaskList(ctx, key.id, stage)
+		if err != nil {
+			return err
+		}
+		h := e.taskDurations.WithLabelValues(key.labelValues()...)
+		for _, task := range tasks {
+			if task.Status == "SUCCESS" && task.Duration != nil {
+				h.Observe(float64(*task.Duration) / 1000)
+			}
+		}
+		e.completedStages.observed(key, stage)
+	}
+	return nil
+}
+
+// pruneTaskDurations drops the task durations of the applications that
+// were not found by the last scrape of their target, unless it failed.
+func (e *Exporter) pruneTaskDurations() {
+	failed := map[string]bool{}
+	for _, endpoint := range e.failedTargets {
+		failed[endpoint] = true
+	}
+	scraped := map[appKey]bool{}
+	for _, app := range e.applications {
+		scraped[appKey{app.Endpoint, app.ID}] = true
+	}
+	pruned := e.completedStages.prune(func(key appKey) bool {
+		return scraped[key] || failed[key.endpoint]
+	})
+	for _, key := range pruned {
+		e.taskDurations.DeleteLabelValues(key.labelValues()...)
+	}
+}
+
+// scrapeTaskList returns all the tasks of the stage.
+func (t *target) scrapeTaskList(ctx context.Context, appID string, stage StageInfo) ([]TaskData, error) {
+	length := stage.NumTasks
+	if length <= 0 {
+		length = stage.NumActiveTasks + stage.NumCompleteTasks + stage.NumFailedTasks
+	}
+	path := fmt.Sprintf("applications/%s/stages/%d/%d/taskList?length=%d", url.PathEscape(appID), stage.StageID, stage.AttemptID, length)
+	body, err := t.fetch(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return parseTaskList(body)
+}
+
+func parseTaskList(r io.Reader) ([]TaskData, error) {
+	var tasks []TaskData
+	if err := json.NewDecoder(r).Decode(&tasks); err != nil {
+		return nil, newDecodeError("tasks", err)
+	}
+	return tasks, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestTaskDurationHistogram(t *testing.T) {
+	const (
+		runningStages   = `[{"stageId": 0, "attemptId": 0, "status": "COMPLETE", "numTasks": 2}, {"stageId": 1, "attemptId": 0, "status": "ACTIVE", "numTasks": 3}]`
+		completedStages = `[{"stageId": 0, "attemptId": 0, "status": "COMPLETE", "numTasks": 2}, {"stageId": 1, "attemptId": 0, "status": "COMPLETE", "numTasks": 3}]`
+		tasks           = `[{"taskId": 0, "status": "SUCCESS", "duration": 1500}, {"taskId": 1, "status": "SUCCESS", "duration": 2500}, {"taskId": 2, "status": "FAILED", "duration": 100}, {"taskId": 3, "status": "RUNNING"}]`
+	)
+	tests := []struct {
+		name       string
+		native     bool
+		wantSchema bool
+		wantBucket bool
+	}{
+		{name: "classic buckets", wantBucket: true},
+		{name: "native histogram", native: true, wantSchema: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSparkServer(t, appRoutes(map[string]string{
+				"applications/app-1/stages":              runningStages,
+				"applications/app-1/stages/0/0/taskList": tasks,
+				"applications/app-1/stages/1/0/taskList": tasks,
+			}))
+			e := newTestExporter(t, []string{s.URL}, Options{
+				Endpoints:             []string{"stages"},
+				TaskDurationHistogram: true,
+				NativeHistograms:      tt.native,
+			})
+
+			families := gather(t, e)
+			if m := findMetric(families["spark_task_duration_seconds"], nil); m != nil && m.Histogram.GetSampleCount() != 0 {
+				t.Errorf("got %d observations before any stage completed, want none", m.Histogram.GetSampleCount())
+			}
+			s.setRoute("applications/app-1/stages", completedStages)
+			gather(t, e)
+			families = gather(t, e)
+
+			m := findMetric(families["spark_task_duration_seconds"], map[string]string{"app_id": "app-1"})
+			if m == nil {
+				t.Fatal("spark_task_duration_seconds not exported")
+			}
+			h := m.Histogram
+			if got, want := h.GetSampleCount(), uint64(2); got != want {
+				t.Errorf("got %d observations, want %d", got, want)
+			}
+			if got, want := h.GetSampleSum(), 4.0; got != want {
+				t.Errorf("got a sum of %v, want %v", got, want)
+			}
+			if got := h.Schema != nil; got != tt.wantSchema {
+				t.Errorf("got native histogram schema %v, want one: %v", h.Schema, tt.wantSchema)
+			}
+			if got := len(h.Bucket) > 0; got != tt.wantBucket {
+				t.Errorf("got %d classic buckets, want some: %v", len(h.Bucket), tt.wantBucket)
+			}
+			// The stage already complete when the application was first
+			// seen is left out, and the tasks of the completed one are
+			// requested once.
+			if n := s.requested("applications/app-1/stages/0/0/taskList?length=2"); n != 0 {
+				t.Errorf("got %d requests for the tasks of the stage complete at start, want none", n)
+			}
+			if n := s.requested("applications/app-1/stages/1/0/taskList?length=3"); n != 1 {
+				t.Errorf("got %d requests for the tasks of the completed stage, want 1", n)
+			}
+		})
+	}
+}
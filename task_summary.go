@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// taskQuantiles are the quantiles of the task metrics requested for each
+// active stage.
+const taskQuantiles = "0.25,0.5,0.75,0.95"
+
+var stageQuantileLabelNames = append(append([]string{}, stageLabelNames...), "quantile")
+
+// TaskMetricDistributions holds quantiles of the metrics of the tasks of a
+// stage attempt. Times are in milliseconds. Duration is only returned by
+// Spark 3.1 and later, ExecutorRunTime is used instead before.
+type TaskMetricDistributions struct {
+	Quantiles       []float64 `json:"quantiles"`
+	Duration        []float64 `json:"duration"`
+	ExecutorRunTime []float64 `json:"executorRunTime"`
+	JVMGCTime       []float64 `json:"jvmGcTime"`
+}
+
+func newStageQuantileMetrics(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "stage_" + metricName,
+			Help:        docString,
+			ConstLabels: constLabels,
+		},
+		stageQuantileLabelNames,
+	)
+}
+
+func newStageQuantileGaugeMetrics(namespace string, constLabels prometheus.Labels) map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"task_duration_seconds": newStageQuantileMetrics(namespace, "task_duration_seconds", "Quantiles of the duration of the tasks of the active stage in seconds", constLabels),
+		"task_gc_time_seconds":  newStageQuantileMetrics(namespace, "task_gc_time_seconds", "Quantiles of the time spent by the tasks of the active stage in garbage collection in seconds", constLabels),
+	}
+}
+
+// scrapeTaskSummary returns the distributions of the task metrics of the
+// stage, or nil for Spark versions without the task summary endpoint.
+func (t *target) scrapeTaskSummary(ctx context.Context, appID string, stage StageInfo) (*TaskMetricDistributions, error) {
+	path := fmt.Sprintf("applications/%s/stages/%d/%d/taskSummary?quantiles=%s", url.PathEscape(appID), stage.StageID, stage.AttemptID, taskQuantiles)
+	body, err := t.fetch(ctx, path)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return parseTaskMetricDistributions(body)
+}
+
+func (e *Exporter) setTaskSummaryMetrics(key appKey, stage StageInfo, d *TaskMetricDistributions) {
+	duration := d.Duration
+	if len(duration) == 0 {
+		duration = d.ExecutorRunTime
+	}
+	labels := stageLabelValues(key, stage)
+	for i, q := range d.Quantiles {
+		ql := append(append([]string{}, labels...), fmt.Sprint(q))
+		if i < len(duration) {
+			e.stageQuantileMetrics["task_duration_seconds"].WithLabelValues(ql...).Set(duration[i] / 1000)
+		}
+		if i < len(d.JVMGCTime) {
+			e.stageQuantileMetrics["task_gc_time_seconds"].WithLabelValues(ql...).Set(d.JVMGCTime[i] / 1000)
+		}
+	}
+}
+
+func parseTaskMetricDistributions(r io.Reader) (*TaskMetricDistributions, error) {
+	var d TaskMetricDistributions
+	if err := json.NewDecoder(r).Decode(&d); err != nil {
+		return nil, newDecodeError("task summary", err)
+	}
+	return &d, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sparkVersion is the version of Spark serving a REST API, used to skip the
+// endpoints older versions don't have.
+type sparkVersion struct {
+	name         string
+	major, minor int
+	// known is false when the version could not be detected, in which case
+	// all the endpoints are requested.
+	known bool
+}
+
+// parseSparkVersion parses a version returned by the version endpoint, such
+// as 3.3.0 or 2.4.8-amzn-0.
+func parseSparkVersion(name string) sparkVersion {
+	v := sparkVersion{name: name}
+	parts := strings.SplitN(name, ".", 3)
+	if len(parts) < 2 {
+		return v
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return v
+	}
+	minor, err := strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return v
+	}
+	v.major, v.minor, v.known = major, minor, true
+	return v
+}
+
+// atLeast reports whether v is major.minor or later, or unknown.
+func (v sparkVersion) atLeast(major, minor int) bool {
+	if !v.known {
+		return true
+	}
+	return v.major > major || v.major == major && v.minor >= minor
+}
+
+// detectVersion returns the Spark version of the target, requested on the
+// first scrape finding applications only. When the request fails, the
+// version is unknown until the next scrape, so that the applications are
+// still scraped.
+func (t *target) detectVersion(ctx context.Context) sparkVersion {
+	if t.version != nil {
+		return *t.version
+	}
+	name, err := t.scrapeVersion(ctx)
+	if err != nil {
+		log.Warnf("Can't detect the Spark version of %s, requesting all the endpoints: %v", t.endpoint, err)
+		return sparkVersion{}
+	}
+	v := parseSparkVersion(name)
+	t.version = &v
+	return v
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseSparkVersion(t *testing.T) {
+	tests := []struct {
+		name         string
+		known        bool
+		major, minor int
+	}{
+		{"3.3.0", true, 3, 3},
+		{"2.4.8-amzn-0", true, 2, 4},
+		{"3.1", true, 3, 1},
+		{"3.2-SNAPSHOT", true, 3, 2},
+		{"", false, 0, 0},
+		{"unknown", false, 0, 0},
+		{"three.one", false, 0, 0},
+	}
+	for _, tt := range tests {
+		v := parseSparkVersion(tt.name)
+		if v.known != tt.known || v.major != tt.major || v.minor != tt.minor {
+			t.Errorf("parseSparkVersion(%q) = %+v, want %d.%d known: %v", tt.name, v, tt.major, tt.minor, tt.known)
+		}
+	}
+	if !(sparkVersion{}).atLeast(3, 0) {
+		t.Error("an unknown version is not considered recent enough for all endpoints")
+	}
+}
+
+func TestVersionPayloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		// executors is the executor listing of that version: Spark 2.4
+		// reports blacklisted executors and no peak memory metrics.
+		executors string
+		want      []series
+		wantSQL   int
+	}{
+		{
+			name:      "2.4",
+			version:   "2.4.8",
+			executors: `[{"id": "1", "isActive": true, "totalCores": 4, "isBlacklisted": true, "blacklistedInStages": [2], "memoryMetrics": {"usedOnHeapStorageMemory": 1024}}]`,
+			want: []series{
+				{"spark_executor_excluded", map[string]string{"executor_id": "1"}, 1},
+				{"spark_executor_total_cores", map[string]string{"executor_id": "1"}, 4},
+				{"spark_executor_on_heap_storage_memory_used_bytes", map[string]string{"executor_id": "1"}, 1024},
+			},
+			// The SQL endpoint was added in Spark 3.0.
+			wantSQL: 0,
+		},
+		{
+			name:      "3.3",
+			version:   "3.3.0",
+			executors: `[{"id": "1", "isActive": true, "totalCores": 4, "isExcluded": false, "excludedInStages": [], "peakMemoryMetrics": {"JVMHeapMemory": 2048}}]`,
+			want: []series{
+				{"spark_executor_excluded", map[string]string{"executor_id": "1"}, 0},
+				{"spark_executor_total_cores", map[string]string{"executor_id": "1"}, 4},
+			},
+			wantSQL: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSparkServer(t, appRoutes(map[string]string{
+				"version":                      `{"spark": "` + tt.version + `"}`,
+				"applications/app-1/executors": tt.executors,
+				"applications/app-1/sql":       `[]`,
+			}))
+			e := newTestExporter(t, []string{s.URL}, Options{Endpoints: scrapeEndpoints})
+			families := gather(t, e)
+			checkSeries(t, families, append(tt.want,
+				series{"spark_up", map[string]string{"endpoint": s.URL}, 1},
+				series{"spark_application_info", map[string]string{"app_id": "app-1", "spark_version": tt.version}, 1},
+			))
+			if n := s.requested("applications/app-1/sql?details=false&planDescription=false"); n != tt.wantSQL {
+				t.Errorf("got %d requests to the SQL endpoint, want %d", n, tt.wantSQL)
+			}
+			// The version is only requested once.
+			gather(t, e)
+			if n := s.requested("version"); n != 1 {
+				t.Errorf("got %d requests to the version endpoint, want 1", n)
+			}
+		})
+	}
+}
+
+func TestVersionDetectionFailure(t *testing.T) {
+	s := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "driver", "isActive": true, "activeTasks": 2}]`,
+	}))
+	var versionRequests int32
+	handler := s.Config.Handler
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/version" {
+			atomic.AddInt32(&versionRequests, 1)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+	e := newTestExporter(t, []string{s.URL}, Options{Endpoints: scrapeEndpoints})
+	// The applications are scraped with an unknown version, requesting all
+	// the endpoints.
+	families := gather(t, e)
+	checkSeries(t, families, []series{
+		{"spark_up", map[string]string{"endpoint": s.URL}, 1},
+		{"spark_executor_active_tasks", map[string]string{"executor_id": "driver"}, 2},
+		{"spark_application_info", map[string]string{"app_id": "app-1", "spark_version": ""}, 1},
+	})
+	if n := s.requested("applications/app-1/sql?details=false&planDescription=false"); n != 1 {
+		t.Errorf("got %d requests to the SQL endpoint, want 1", n)
+	}
+	// The detection is retried on the next scrape.
+	gather(t, e)
+	if n := atomic.LoadInt32(&versionRequests); n != 2 {
+		t.Errorf("got %d requests to the version endpoint, want 2", n)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WebOptions holds the settings of the exporter's own HTTP server.
+type WebOptions struct {
+	// ListenAddress is a TCP host:port, such as [::]:9110 for IPv6, or the
+	// path of a Unix domain socket prefixed with unix:.
+	ListenAddress string
+	MetricsPath   string
+
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set.
+	// TLSClientCAFile additionally requires clients to present a
+	// certificate signed by one of its CAs.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+
+	// ShutdownTimeout bounds the time waited for active requests to
+	// complete when shutting down.
+	ShutdownTimeout time.Duration
+
+	// TimeoutOffset is subtracted from the scrape timeout sent by
+	// Prometheus to bound the scrapes.
+	TimeoutOffset time.Duration
+	// MaxRequests limits the number of scrapes served at the same time,
+	// 0 for no limit.
+	MaxRequests int
+	// EnablePprof serves the Go profiling endpoints under /debug/pprof/.
+	EnablePprof bool
+	// DisableExporterMetrics leaves the Go runtime and process metrics of
+	// the exporter out of the metrics served.
+	DisableExporterMetrics bool
+}
+
+func (o WebOptions) tlsEnabled() bool {
+	return o.TLSCertFile != "" && o.TLSKeyFile != ""
+}
+
+func (o WebOptions) validate() error {
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		return fmt.Errorf("web TLS certificate and key files must be set together")
+	}
+	if o.TLSClientCAFile != "" && !o.tlsEnabled() {
+		return fmt.Errorf("web TLS client CA file requires a TLS certificate and key")
+	}
+	if network, address := o.listenNetwork(); network == "unix" && address == "" {
+		return fmt.Errorf("missing unix socket path in listen address %q", o.ListenAddress)
+	}
+	return nil
+}
+
+// listenNetwork returns the network and address of o.ListenAddress, a Unix
+// domain socket when prefixed with unix:.
+func (o WebOptions) listenNetwork() (string, string) {
+	if strings.HasPrefix(o.ListenAddress, "unix:") {
+		return "unix", strings.TrimPrefix(o.ListenAddress, "unix:")
+	}
+	return "tcp", o.ListenAddress
+}
+
+// listen returns the listener of o.ListenAddress. The socket file left by a
+// previous exporter is removed before listening on a Unix domain socket.
+func (o WebOptions) listen() (net.Listener, error) {
+	network, address := o.listenNetwork()
+	if network == "unix" {
+		if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(address); err != nil {
+				return nil, fmt.Errorf("can't remove stale socket: %v", err)
+			}
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// newMux returns the mux serving the routes of the exporter. The default mux
+// isn't used, as importing net/http/pprof registers the profiling endpoints
+// on it.
+func newMux(opts WebOptions, reloader *reloader, readiness *readiness) *http.ServeMux {
+	limit := limitRequests(opts.MaxRequests)
+	mux := http.NewServeMux()
+	mux.Handle(opts.MetricsPath, limit(metricsHandler(reloader.Exporter, reloader.gatherer(opts.DisableExporterMetrics), opts.TimeoutOffset)))
+	mux.Handle("/probe", limit(probeHandler(reloader.options, opts.TimeoutOffset)))
+	mux.HandleFunc("/-/healthy", healthyHandler)
+	mux.Handle("/-/ready", readyHandler(readiness))
+	mux.Handle("/", landingHandler(opts.MetricsPath, reloader.Exporter))
+	if opts.EnablePprof {
+		handlePprof(mux)
+	}
+	return mux
+}
+
+// handlePprof serves the Go profiling endpoints under /debug/pprof/ on mux.
+func handlePprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// newServer returns the HTTP server serving handler according to opts.
+func newServer(opts WebOptions, handler http.Handler) (*http.Server, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	server := &http.Server{
+		Addr:    opts.ListenAddress,
+		Handler: handler,
+	}
+	if opts.TLSClientCAFile != "" {
+		pem, err := ioutil.ReadFile(opts.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read web TLS client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in web TLS client CA file %s", opts.TLSClientCAFile)
+		}
+		server.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+	return server, nil
+}
+
+// serve runs server until it fails, over HTTPS if TLS is configured.
+func serve(server *http.Server, opts WebOptions) error {
+	listener, err := opts.listen()
+	if err != nil {
+		return err
+	}
+	if opts.tlsEnabled() {
+		return server.ServeTLS(listener, opts.TLSCertFile, opts.TLSKeyFile)
+	}
+	return server.Serve(listener)
+}
+
+// run serves server until it fails or a signal is received on stop. On a
+// signal, the server stops accepting connections and waits for the active
+// requests to complete, up to opts.ShutdownTimeout.
+func run(server *http.Server, opts WebOptions, stop <-chan os.Signal) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serve(server, opts)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-stop:
+		log.Infof("Received %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
+
+// limitRequests returns a function wrapping handlers so that at most max
+// requests are served by all of them at the same time, the others being
+// answered with 429 Too Many Requests. A max of 0 or less doesn't limit the
+// requests.
+func limitRequests(max int) func(http.Handler) http.Handler {
+	if max <= 0 {
+		return func(handler http.Handler) http.Handler { return handler }
+	}
+	sem := make(chan struct{}, max)
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				handler.ServeHTTP(w, r)
+			default:
+				http.Error(w, fmt.Sprintf("Limit of %d concurrent requests reached", max), http.StatusTooManyRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	stdlog "log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freeAddress returns a local TCP address no one listens on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startServer runs a server of handler with opts in the background, until
+// the returned function sends it SIGTERM and returns the error of run.
+func startServer(t *testing.T, opts WebOptions, handler http.Handler) func() error {
+	t.Helper()
+	server, err := newServer(opts, handler)
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	server.ErrorLog = stdlog.New(ioutil.Discard, "", 0)
+	stop := make(chan os.Signal, 1)
+	errc := make(chan error, 1)
+	go func() { errc <- run(server, opts, stop) }()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		conn, err := net.Dial("tcp", opts.ListenAddress)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server not listening: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return func() error {
+		stop <- syscall.SIGTERM
+		select {
+		case err := <-errc:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("server not shut down")
+			return nil
+		}
+	}
+}
+
+func TestWebOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    WebOptions
+		wantErr bool
+	}{
+		{name: "plain HTTP", opts: WebOptions{}},
+		{name: "TLS", opts: WebOptions{TLSCertFile: "a.crt", TLSKeyFile: "a.key"}},
+		{name: "certificate without key", opts: WebOptions{TLSCertFile: "a.crt"}, wantErr: true},
+		{name: "key without certificate", opts: WebOptions{TLSKeyFile: "a.key"}, wantErr: true},
+		{name: "client CA without TLS", opts: WebOptions{TLSClientCAFile: "ca.crt"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebTLS(t *testing.T) {
+	serverCert := newTestCertificate(t, "server")
+	clientCert := newTestCertificate(t, "client")
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	get := func(url string, config *tls.Config) (int, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get(url)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	tests := []struct {
+		name       string
+		clientCA   string
+		scheme     string
+		tlsConfig  *tls.Config
+		wantStatus int
+	}{
+		{
+			name:       "plain HTTP",
+			scheme:     "http",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "HTTPS",
+			scheme:     "https",
+			tlsConfig:  &tls.Config{RootCAs: serverCert.pool},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:      "HTTPS without client certificate",
+			clientCA:  clientCert.certFile,
+			scheme:    "https",
+			tlsConfig: &tls.Config{RootCAs: serverCert.pool},
+		},
+		{
+			name:       "HTTPS with client certificate",
+			clientCA:   clientCert.certFile,
+			scheme:     "https",
+			tlsConfig:  &tls.Config{RootCAs: serverCert.pool, Certificates: []tls.Certificate{clientCert.cert}},
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := WebOptions{
+				ListenAddress:   freeAddress(t),
+				TLSCertFile:     serverCert.certFile,
+				TLSKeyFile:      serverCert.keyFile,
+				TLSClientCAFile: tt.clientCA,
+				ShutdownTimeout: time.Second,
+			}
+			stop := startServer(t, opts, handler)
+			defer stop()
+			status, err := get(tt.scheme+"://"+opts.ListenAddress+"/", tt.tlsConfig)
+			if tt.wantStatus == 0 {
+				if err == nil {
+					t.Errorf("got status %d, want the connection to be rejected", status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GET: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("got status %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+	opts := WebOptions{ListenAddress: freeAddress(t), ShutdownTimeout: 5 * time.Second}
+	stop := startServer(t, opts, handler)
+
+	type result struct {
+		status int
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + opts.ListenAddress + "/")
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		resp.Body.Close()
+		results <- result{status: resp.StatusCode}
+	}()
+	<-started
+	if err := stop(); err != nil {
+		t.Errorf("run returned %v once shut down, want nil", err)
+	}
+	// The request in flight completes instead of being dropped.
+	select {
+	case r := <-results:
+		if r.err != nil || r.status != http.StatusOK {
+			t.Errorf("got status %d, error %v for the request in flight, want 200", r.status, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the request in flight did not complete")
+	}
+	if _, err := net.Dial("tcp", opts.ListenAddress); err == nil {
+		t.Error("the server still accepts connections once shut down")
+	}
+}
+
+func TestLimitRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        int
+		inFlight   int
+		wantStatus int
+	}{
+		{name: "no limit", inFlight: 3, wantStatus: http.StatusOK},
+		{name: "below the limit", max: 2, inFlight: 1, wantStatus: http.StatusOK},
+		{name: "at the limit", max: 2, inFlight: 2, wantStatus: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started, release := make(chan struct{}), make(chan struct{})
+			handler := limitRequests(tt.max)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow" {
+					started <- struct{}{}
+					<-release
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			done := make(chan struct{})
+			for i := 0; i < tt.inFlight; i++ {
+				go func() {
+					handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+					done <- struct{}{}
+				}()
+				<-started
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d with %d requests in flight, want %d", rec.Code, tt.inFlight, tt.wantStatus)
+			}
+			close(release)
+			for i := 0; i < tt.inFlight; i++ {
+				<-done
+			}
+			// The requests are served again once the ones in flight
+			// completed.
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("got status %d once the requests in flight completed, want 200", rec.Code)
+			}
+		})
+	}
+}
+
+func TestPprof(t *testing.T) {
+	tests := []struct {
+		enable     bool
+		wantStatus int
+	}{
+		{enable: false, wantStatus: http.StatusNotFound},
+		{enable: true, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		mux := http.NewServeMux()
+		mux.Handle("/", landingHandler("/metrics", func() *Exporter { return nil }))
+		if tt.enable {
+			handlePprof(mux)
+		}
+		for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline"} {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d for %s with pprof enabled: %v, want %d", rec.Code, path, tt.enable, tt.wantStatus)
+			}
+		}
+	}
+}
+
+// newTestReloader returns a reloader of the configuration content, flags
+// set on the command line being args.
+func newTestReloader(t *testing.T, content string, args ...string) *reloader {
+	t.Helper()
+	configFile := writeFile(t, "config.yml", content)
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := overrideConfig(cfg, args); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newReloader(cfg, configFile, args, newReadiness(3))
+	if err != nil {
+		t.Fatalf("newReloader: %v", err)
+	}
+	t.Cleanup(func() { closeHTTPClient(r.client) })
+	return r
+}
+
+func TestMux(t *testing.T) {
+	s := newSparkServer(t, map[string]string{"applications": `[]`})
+	r := newTestReloader(t, fmt.Sprintf("targets: [%s]\n", s.URL))
+	mux := newMux(WebOptions{MetricsPath: "/spark-metrics"}, r, r.readiness)
+
+	// The routes are requested in order, the readiness following the first
+	// scrape.
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{path: "/", wantStatus: http.StatusOK, wantBody: `<a href="/spark-metrics">`},
+		{path: "/-/healthy", wantStatus: http.StatusOK, wantBody: "Healthy."},
+		{path: "/-/ready", wantStatus: http.StatusServiceUnavailable},
+		{path: "/spark-metrics", wantStatus: http.StatusOK, wantBody: "spark_up{"},
+		{path: "/-/ready", wantStatus: http.StatusOK, wantBody: "Ready."},
+		{path: "/probe?target=" + s.URL, wantStatus: http.StatusOK, wantBody: "spark_up{"},
+		{path: "/probe", wantStatus: http.StatusBadRequest},
+		{path: "/metrics", wantStatus: http.StatusNotFound},
+		{path: "/debug/pprof/", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("got status %d for %s, want %d", rec.Code, tt.path, tt.wantStatus)
+		}
+		if !strings.Contains(rec.Body.String(), tt.wantBody) {
+			t.Errorf("the response to %s doesn't contain %q:\n%s", tt.path, tt.wantBody, rec.Body)
+		}
+	}
+
+	// Nothing is registered on the default mux, other than the pprof
+	// endpoints registered by importing net/http/pprof.
+	for _, path := range []string{"/", "/spark-metrics", "/probe", "/-/healthy", "/-/ready"} {
+		if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest("GET", path, nil)); pattern != "" {
+			t.Errorf("the default mux serves %s with %s", path, pattern)
+		}
+	}
+}
+
+func TestListenNetwork(t *testing.T) {
+	tests := []struct {
+		address     string
+		wantNetwork string
+		wantAddress string
+		wantErr     bool
+	}{
+		{address: ":9110", wantNetwork: "tcp", wantAddress: ":9110"},
+		{address: "[::]:9110", wantNetwork: "tcp", wantAddress: "[::]:9110"},
+		{address: "unix:/run/spark_exporter.sock", wantNetwork: "unix", wantAddress: "/run/spark_exporter.sock"},
+		{address: "unix:", wantNetwork: "unix", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			opts := WebOptions{ListenAddress: tt.address}
+			network, address := opts.listenNetwork()
+			if network != tt.wantNetwork || address != tt.wantAddress {
+				t.Errorf("got %s %q, want %s %q", network, address, tt.wantNetwork, tt.wantAddress)
+			}
+			if err := opts.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want one: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListenIPv6(t *testing.T) {
+	l, err := WebOptions{ListenAddress: "[::1]:0"}.listen()
+	if err != nil {
+		t.Skipf("IPv6 unavailable: %v", err)
+	}
+	defer l.Close()
+	if addr := l.Addr().(*net.TCPAddr); addr.IP.To4() != nil || !addr.IP.IsLoopback() {
+		t.Errorf("listening on %v, want the IPv6 loopback", addr)
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spark_exporter.sock")
+	opts := WebOptions{ListenAddress: "unix:" + path}
+	// A socket file left by a previous exporter is removed.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := opts.listen()
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	go server.Serve(l)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatalf("GET over the unix socket: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want 200", resp.StatusCode)
+	}
+
+	// Other files are left alone.
+	regular := writeFile(t, "regular", "")
+	if _, err := (WebOptions{ListenAddress: "unix:" + regular}).listen(); err == nil {
+		t.Error("listening over a regular file succeeded, want an error")
+	}
+}
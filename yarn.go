@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// yarnAppsPath lists the running Spark applications of a YARN
+// ResourceManager.
+const yarnAppsPath = "ws/v1/cluster/apps?applicationTypes=SPARK&states=RUNNING"
+
+// YarnApps holds the applications listed by a YARN ResourceManager.
+type YarnApps struct {
+	Apps struct {
+		App []YarnApp `json:"app"`
+	} `json:"apps"`
+}
+
+// YarnApp holds a single application listed by a YARN ResourceManager.
+type YarnApp struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	TrackingURL string `json:"trackingUrl"`
+}
+
+// newYarnDiscoverer returns a discoverer listing the driver UIs of the Spark
+// applications running on YARN. They are scraped through the
+// ResourceManager proxy given by their tracking URL.
+func newYarnDiscoverer(uri string, fetchOpts FetchOptions, client httpDoer) (*discoverer, error) {
+	if _, err := parseSparkURI(uri); err != nil {
+		return nil, err
+	}
+	fetch := fetchHTTP(uri, fetchOpts, client)
+	return &discoverer{
+		endpoint: uri,
+		discover: func(ctx context.Context) ([]string, error) {
+			body, err := fetch(ctx, yarnAppsPath)
+			if err != nil {
+				return nil, err
+			}
+			defer body.Close()
+
+			apps, err := parseYarnApps(body)
+			if err != nil {
+				return nil, err
+			}
+			var uris []string
+			for _, app := range apps.Apps.App {
+				if app.TrackingURL != "" {
+					uris = append(uris, app.TrackingURL)
+				}
+			}
+			return uris, nil
+		},
+	}, nil
+}
+
+func parseYarnApps(r io.Reader) (YarnApps, error) {
+	var apps YarnApps
+	if err := json.NewDecoder(r).Decode(&apps); err != nil {
+		return apps, newDecodeError("YARN applications", err)
+	}
+	return apps, nil
+}
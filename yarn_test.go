@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// yarnAppsSample is a response of the cluster applications endpoint of a
+// Hadoop 3.2 ResourceManager.
+const yarnAppsSample = `{"apps":{"app":[{
+  "id":"application_1609840000000_0001",
+  "user":"spark",
+  "name":"etl",
+  "queue":"default",
+  "state":"RUNNING",
+  "finalStatus":"UNDEFINED",
+  "progress":10.0,
+  "trackingUI":"ApplicationMaster",
+  "trackingUrl":"http://rm-1:8088/proxy/application_1609840000000_0001/",
+  "applicationType":"SPARK",
+  "startedTime":1609841410123,
+  "finishedTime":0,
+  "elapsedTime":600000,
+  "allocatedMB":12288,
+  "allocatedVCores":4,
+  "runningContainers":3
+},{
+  "id":"application_1609840000000_0002",
+  "user":"analyst",
+  "name":"report",
+  "queue":"default",
+  "state":"RUNNING",
+  "finalStatus":"UNDEFINED",
+  "progress":0.0,
+  "trackingUI":"UNASSIGNED",
+  "applicationType":"SPARK",
+  "startedTime":1609842000000,
+  "finishedTime":0,
+  "elapsedTime":1000
+}]}}`
+
+func TestParseYarnApps(t *testing.T) {
+	apps, err := parseYarnApps(strings.NewReader(yarnAppsSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []YarnApp{
+		{ID: "application_1609840000000_0001", Name: "etl", State: "RUNNING", TrackingURL: "http://rm-1:8088/proxy/application_1609840000000_0001/"},
+		{ID: "application_1609840000000_0002", Name: "report", State: "RUNNING"},
+	}
+	if !reflect.DeepEqual(apps.Apps.App, want) {
+		t.Errorf("parseYarnApps = %+v, want %+v", apps.Apps.App, want)
+	}
+
+	// A ResourceManager without running applications answers a null list.
+	apps, err = parseYarnApps(strings.NewReader(`{"apps":null}`))
+	if err != nil || len(apps.Apps.App) != 0 {
+		t.Errorf("parseYarnApps = %+v, %v, want no application", apps, err)
+	}
+	if _, err := parseYarnApps(strings.NewReader(`<html>`)); err == nil {
+		t.Error("parseYarnApps of HTML succeeded, want an error")
+	}
+}
+
+// newResourceManager returns a fake ResourceManager listing apps, where
+// {{rm}} stands for its own URL, and proxying /proxy/<id>/ to the driver UIs
+// of drivers, by application id.
+func newResourceManager(t *testing.T, apps string, drivers map[string]*sparkServer) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/v1/cluster/apps", func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query(); q.Get("applicationTypes") != "SPARK" || q.Get("states") != "RUNNING" {
+			http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(strings.ReplaceAll(apps, "{{rm}}", "http://"+r.Host)))
+	})
+	for id, driver := range drivers {
+		prefix := "/proxy/" + id
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, driver.Config.Handler))
+	}
+	rm := httptest.NewServer(mux)
+	t.Cleanup(rm.Close)
+	return rm
+}
+
+func TestYarnDiscovery(t *testing.T) {
+	driver := newSparkServer(t, appRoutes(map[string]string{
+		"applications/app-1/executors": `[{"id": "driver", "isActive": true, "activeTasks": 2}]`,
+	}))
+	active := newResourceManager(t, `{"apps":{"app":[
+		{"id":"application_1_0001","name":"etl","state":"RUNNING","trackingUrl":"{{rm}}/proxy/application_1_0001/"},
+		{"id":"application_1_0002","name":"report","state":"RUNNING"}]}}`,
+		map[string]*sparkServer{"application_1_0001": driver})
+	// The standby ResourceManager of an HA pair redirects to the active one.
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, active.URL+r.URL.RequestURI(), http.StatusFound)
+	}))
+	defer standby.Close()
+
+	for _, rm := range []*httptest.Server{active, standby} {
+		e := newTestExporter(t, nil, Options{YarnRMURI: rm.URL})
+		trackingURL := active.URL + "/proxy/application_1_0001/"
+		checkSeries(t, gather(t, e), []series{
+			{"spark_up", map[string]string{"endpoint": rm.URL}, 1},
+			{"spark_up", map[string]string{"endpoint": trackingURL}, 1},
+			{"spark_executor_active_tasks", map[string]string{"endpoint": trackingURL, "app_id": "app-1", "executor_id": "driver"}, 2},
+		})
+		// The targets are kept from one scrape to the next.
+		first := e.discovered[trackingURL]
+		gather(t, e)
+		if len(e.discovered) != 1 || e.discovered[trackingURL] != first {
+			t.Errorf("got discovered targets %v, want the target of %s kept", e.discovered, trackingURL)
+		}
+	}
+}
+
+func TestYarnDown(t *testing.T) {
+	rm := httptest.NewServer(http.NotFoundHandler())
+	defer rm.Close()
+	families := gather(t, newTestExporter(t, nil, Options{YarnRMURI: rm.URL}))
+	checkSeries(t, families, []series{
+		{"spark_up", map[string]string{"endpoint": rm.URL}, 0},
+	})
+}